@@ -2,6 +2,9 @@ package git
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -14,6 +17,38 @@ func openTestRepo(t *testing.T, name string) *Repository {
 	return r
 }
 
+// copyTestRepo copies a test repository into a temporary directory, for
+// tests that modify it. The caller must remove the returned directory.
+func copyTestRepo(t *testing.T, name string) string {
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join("testdata", name)
+	err = filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0775)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, rel), data, 0664)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir
+}
+
 func TestObject(t *testing.T) {
 	r := openTestRepo(t, "repo")
 	testObject(t, r, "30d74d258442c7c65512eafab474568dd706c430", "test")
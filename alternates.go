@@ -0,0 +1,109 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxAlternateDepth is how deeply alternates may list further alternates,
+// as in git.
+const maxAlternateDepth = 5
+
+// Alternates returns the object directories this store borrows objects
+// from, read from objects/info/alternates and, recursively, from the
+// alternates of those directories.
+func (s *FileObjectStore) Alternates() []string {
+	dirs := make([]string, len(s.alternates))
+	for i, alt := range s.alternates {
+		dirs[i] = alt.dir
+	}
+	return dirs
+}
+
+// addAlternates adds the object directories in paths as alternates, along
+// with the alternates they list themselves. Relative paths are relative to
+// the directory relativeTo, or to the working directory if it is empty.
+// Directories that are already in use are skipped, which breaks cycles, and
+// directories that do not exist are ignored like git does. The alternates
+// use the delta base cache and pack rescan interval of s.
+func (s *FileObjectStore) addAlternates(paths []string, relativeTo string, depth int) error {
+	for _, path := range paths {
+		if !filepath.IsAbs(path) && relativeTo != "" {
+			path = filepath.Join(relativeTo, path)
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if s.objectDirs[real] {
+			continue
+		}
+		if depth > maxAlternateDepth {
+			return fmt.Errorf("%s: alternate object directories nested too deeply", path)
+		}
+		s.objectDirs[real] = true
+
+		alt, err := openFileObjectStore(path, s.format)
+		if err != nil {
+			return err
+		}
+		// Like git, all packs share one delta base cache.
+		alt.deltaBaseCache = s.deltaBaseCache
+		s.mu.RLock()
+		alt.rescanInterval = s.rescanInterval
+		s.mu.RUnlock()
+		s.alternates = append(s.alternates, alt)
+
+		more, err := readAlternates(path)
+		if err != nil {
+			return err
+		}
+		if err := s.addAlternates(more, path, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAlternates returns the paths listed in the info/alternates file of the
+// objects directory dir. Lines may be quoted like C strings, and empty lines
+// and lines starting with # are ignored.
+func readAlternates(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, "info", "alternates"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimRight(scan.Text(), "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '"' {
+			unquoted, err := strconv.Unquote(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s/info/alternates: bad quoted path %s", dir, line)
+			}
+			line = unquoted
+		}
+		paths = append(paths, line)
+	}
+	return paths, scan.Err()
+}
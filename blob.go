@@ -3,12 +3,10 @@ package git
 import (
 	"bytes"
 	"compress/zlib"
-	"crypto"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 )
 
 type Blob struct {
@@ -23,6 +21,17 @@ func (b *Blob) Data() ([]byte, error) {
 	return o.Data, nil
 }
 
+// Reader returns a reader for the blob's contents, streaming them from the
+// object store where possible instead of holding the whole blob in memory.
+// The caller must Close the reader.
+func (b *Blob) Reader() (io.ReadCloser, error) {
+	r, err := b.ptree.repo.ObjectReader(b.Id)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 // Write `r` in git's compressed object format into `w`.
 func copyCompressed(w io.Writer, r io.Reader) error {
 	cw, err := zlib.NewWriterLevel(w, zlib.BestSpeed)
@@ -55,10 +64,16 @@ func PrependObjectHeader(objectType ObjectType, r io.ReadSeeker) (io.Reader, err
 		return nil, err
 	}
 
-	buf := bytes.NewBufferString(fmt.Sprintf("%s %d\x00", objectType, size))
+	buf := bytes.NewBufferString(objectHeader(objectType, size))
 	return io.MultiReader(buf, r), nil
 }
 
+// objectHeader returns the '<type> <size>\x00' header that is hashed along
+// with the contents of an object.
+func objectHeader(objectType ObjectType, size int64) string {
+	return fmt.Sprintf("%s %d\x00", objectType, size)
+}
+
 // Write an the object contents in `r` in compressed form to `w` and return the
 // hash.
 // Special case: if `w` is `ioutil.Discard`, the data is not compressed,
@@ -68,17 +83,28 @@ func StoreObjectSHA(
 	w io.Writer,
 	r io.ReadSeeker,
 ) (ObjectID, error) {
+	return StoreObjectHash(ObjectFormatSHA1, objectType, w, r)
+}
+
+// StoreObjectHash is like StoreObjectSHA, but hashes the object with the
+// hash function of the given format.
+func StoreObjectHash(
+	format ObjectFormat,
+	objectType ObjectType,
+	w io.Writer,
+	r io.ReadSeeker,
+) (ObjectID, error) {
 
 	reader, err := PrependObjectHeader(objectType, r)
 	if err != nil {
 		return "", err
 	}
 
-	hash := crypto.SHA1.New()
+	hash := format.New()
 	reader = io.TeeReader(reader, hash)
 
 	if w == ioutil.Discard {
-		_, err = io.Copy(w, r)
+		_, err = io.Copy(w, reader)
 	} else {
 		err = copyCompressed(w, reader)
 	}
@@ -90,45 +116,13 @@ func StoreObjectSHA(
 	return ObjectID(hash.Sum(nil)), nil
 }
 
-// Write an object into git's loose database.
+// Write an object into the repository's object store, which for repositories
+// backed by a FileObjectStore is git's loose database.
 // If the object already exists in the database, it is not overwritten, though
 // the compression is still performed.
 func (repo *Repository) StoreObjectLoose(
 	objectType ObjectType,
 	r io.ReadSeeker,
 ) (ObjectID, error) {
-	fd, err := ioutil.TempFile(filepath.Join(repo.Path, "objects"), ".gogit_")
-	if err != nil {
-		return "", fmt.Errorf("failed to make tmpfile: %v", err)
-	}
-
-	id, err := StoreObjectSHA(objectType, fd, r)
-	if err != nil {
-		fd.Close()
-		return "", err
-	}
-	fd.Close() // Not deferred, intentionally.
-
-	objectPath := filepathFromSHA1(repo.Path, id.String())
-	if _, err = os.Stat(objectPath); err == nil {
-		// Object already exists. Delete the temporary file.
-		err = os.Remove(fd.Name())
-		if err != nil {
-			return "", err
-		}
-		return id, nil
-	}
-
-	err = os.Mkdir(filepath.Dir(objectPath), 0775)
-	if err != nil && !os.IsExist(err) {
-		// Failed to create the directory, and not because it already exists.
-		return "", err
-	}
-
-	err = os.Rename(fd.Name(), objectPath)
-	if err != nil {
-		return "", err
-	}
-
-	return id, nil
+	return repo.store.Put(objectType, r)
 }
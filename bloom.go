@@ -0,0 +1,131 @@
+package git
+
+import "strings"
+
+// Settings of the changed-path Bloom filters in commit-graph files, matching
+// git's defaults.
+const (
+	bloomHashVersion     = 1
+	bloomNumHashes       = 7
+	bloomBitsPerEntry    = 10
+	bloomMaxChangedPaths = 512
+)
+
+// bloomKey holds the bit positions, before reduction modulo the filter size,
+// that a path sets in a changed-path Bloom filter.
+type bloomKey [bloomNumHashes]uint32
+
+func newBloomKey(path string) bloomKey {
+	var key bloomKey
+	hash0 := murmur3SeededV1(0x293ae76f, path)
+	hash1 := murmur3SeededV1(0x7e646e2c, path)
+	for i := range key {
+		key[i] = hash0 + uint32(i)*hash1
+	}
+	return key
+}
+
+// newBloomFilter returns the changed-path Bloom filter for the given changed
+// file paths. Like git, it also records every leading directory of a path,
+// and marks filters with too many changes as matching everything.
+func newBloomFilter(paths []string) []byte {
+	if len(paths) > bloomMaxChangedPaths {
+		return []byte{0xff}
+	}
+
+	keys := map[string]bool{}
+	for _, p := range paths {
+		for {
+			keys[p] = true
+			i := strings.LastIndexByte(p, '/')
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+		}
+	}
+
+	filter := make([]byte, (len(keys)*bloomBitsPerEntry+7)/8)
+	if len(filter) == 0 {
+		return []byte{0}
+	}
+	for p := range keys {
+		key := newBloomKey(p)
+		for _, h := range key {
+			pos := h % uint32(len(filter)*8)
+			filter[pos/8] |= 1 << (pos % 8)
+		}
+	}
+	return filter
+}
+
+// bloomFilterContains reports whether the path with the given key may be in
+// the filter. False positives are possible, false negatives are not.
+func bloomFilterContains(filter []byte, key bloomKey) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, h := range key {
+		pos := h % uint32(len(filter)*8)
+		if filter[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// murmur3SeededV1 is the 32-bit murmur3 hash as implemented by git for
+// version 1 Bloom filters, including its sign extension of bytes >= 0x80.
+func murmur3SeededV1(seed uint32, data string) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+		r1 = 15
+		r2 = 13
+		m  = 5
+		n  = 0xe6546b64
+	)
+
+	signed := func(b byte) uint32 {
+		return uint32(int32(int8(b)))
+	}
+	rotl := func(x uint32, r uint) uint32 {
+		return x<<r | x>>(32-r)
+	}
+
+	len4 := len(data) / 4
+	for i := 0; i < len4; i++ {
+		k := signed(data[4*i]) | signed(data[4*i+1])<<8 | signed(data[4*i+2])<<16 | signed(data[4*i+3])<<24
+		k *= c1
+		k = rotl(k, r1)
+		k *= c2
+
+		seed ^= k
+		seed = rotl(seed, r2)*m + n
+	}
+
+	tail := data[len4*4:]
+	var k1 uint32
+	switch len(tail) {
+	case 3:
+		k1 ^= signed(tail[2]) << 16
+		fallthrough
+	case 2:
+		k1 ^= signed(tail[1]) << 8
+		fallthrough
+	case 1:
+		k1 ^= signed(tail[0])
+		k1 *= c1
+		k1 = rotl(k1, r1)
+		k1 *= c2
+		seed ^= k1
+	}
+
+	seed ^= uint32(len(data))
+	seed ^= seed >> 16
+	seed *= 0x85ebca6b
+	seed ^= seed >> 13
+	seed *= 0xc2b2ae35
+	seed ^= seed >> 16
+	return seed
+}
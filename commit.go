@@ -61,80 +61,60 @@ func (c *Commit) ParentCount() int {
 	return len(c.parents)
 }
 
-// BehindAhead computes the number of commits are behind (missing) and ahead (extra) of commitId.
-// ErrDisjoint is returned when the two commits don't have a common ancestor,
-// and the length of the tree depth for each.
+// BehindAhead computes the number of commits are behind (missing) and ahead (extra) of commitId,
+// like `git rev-list --left-right --count commitId...c`.
+// ErrDisjoint is returned, with the counts, when the two commits don't have a common ancestor.
 //
-// BehindAhead will traverse the entire ancestry of this commit, then traversing
-// the ancestroy of the target commitId until a common ancestor is found.
-//
-// TODO: Use a bitmap index for reachability (https://github.com/shazow/go-git/issues/4)
+// BehindAhead finds the merge bases of the two commits and counts the commits reachable
+// from each of them but not from the merge bases, reading parents from the commit-graph
+// when it covers them.
+// When the repository has a reachability bitmap, the counts are taken from it instead.
 func (c *Commit) BehindAhead(commitId string) (behind int, ahead int, treeErr error) {
 	targetCommit, err := c.repo.GetCommit(commitId)
 	if err != nil {
-		return
+		return 0, 0, err
 	}
 	targetId := targetCommit.Id
-	found := errors.New("found")
-	seen := map[ObjectID]int{}
-	err = c.Walk(func(path []*Commit, cur *Commit, err error) error {
-		seen[cur.Id] = len(path)
-		if cur.Id == targetId {
-			return found
-		}
-		return nil
-	})
-
-	if err == found {
-		ahead = seen[targetId]
-		return
+
+	hasBitmap, err := c.repo.hasReachabilityBitmap()
+	if err != nil {
+		return 0, 0, err
+	}
+	if hasBitmap {
+		return c.repo.behindAhead(c.Id, targetId)
 	}
 
-	// Seek a common ancestor
-	err = targetCommit.Walk(func(path []*Commit, cur *Commit, err error) error {
-		if n, ok := seen[cur.Id]; ok {
-			ahead = n
-			behind = len(path)
-			return found
-		}
-		seen[cur.Id] = len(path)
-		return nil
-	})
-
-	if err != found {
+	bases, err := c.repo.mergeBases(c.Id, []ObjectID{targetId})
+	if err != nil {
+		return 0, 0, err
+	}
+	if ahead, err = c.repo.countCommits(c.Id, bases); err != nil {
+		return 0, 0, err
+	}
+	if behind, err = c.repo.countCommits(targetId, bases); err != nil {
+		return 0, 0, err
+	}
+	if len(bases) == 0 {
 		treeErr = ErrDisjoint
 	}
-
 	return
 }
 
-// IsAncestor returns whether commitId is an ancestor of this commit. False if it's the same commit.
+// IsAncestor returns whether commitId is an ancestor of this commit. False if it's the same commit,
+// unlike with Repository.IsAncestor, or if commitId cannot be resolved. The id may be abbreviated.
 // Similar to `git merge-base --is-ancestor`.
 //
-// IsAncestor will traverse the ancestry of the current commit until it finds the target commitIt.
-//
-// TODO: Use a bitmap index for reachability (https://github.com/shazow/go-git/issues/4)
+// IsAncestor will traverse the ancestry of the current commit until it finds the target commitIt,
+// skipping commits whose commit-graph generation number shows they cannot reach it.
+// When the repository has a reachability bitmap, it is used instead.
 func (c *Commit) IsAncestor(commitId string) bool {
-	ancestorId := ObjectIDHex(commitId)
-	if ancestorId == c.Id {
+	ancestorId, err := c.repo.resolveID(commitId)
+	if err != nil || ancestorId == c.Id {
 		return false
 	}
 
-	found := errors.New("found")
-	err := c.Walk(func(path []*Commit, cur *Commit, err error) error {
-		if err != nil {
-			// We're not expecting any errors.
-			return err
-		}
-		// Check parents before traversing into them
-		for _, parent := range cur.parents {
-			if parent == ancestorId {
-				return found
-			}
-		}
-		return nil
-	})
-	return err == found
+	ok, err := c.repo.isAncestor(ancestorId, c.Id)
+	return err == nil && ok
 }
 
 // Return oid of the (root) tree of this commit.
@@ -1,7 +1,6 @@
 package git
 
 import (
-	"bytes"
 	"os"
 	"path/filepath"
 
@@ -67,11 +66,13 @@ func createArchive(tree *Tree, streamer cae.Streamer, relPaths ...string) error
 				return err
 			}
 		} else {
-			data, err := te.Blob().Data()
+			r, err := te.Blob().Reader()
 			if err != nil {
 				return err
 			}
-			if err := streamer.StreamReader(relPath, te, bytes.NewReader(data)); err != nil {
+			err = streamer.StreamReader(relPath, te, r)
+			r.Close()
+			if err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,378 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GenerationNumberInfinity is the generation number of commits that are not
+// covered by a commit-graph. Such commits may be newer than any commit in the
+// graph.
+const GenerationNumberInfinity = math.MaxUint64
+
+const (
+	graphParentNone       = 0x70000000
+	graphExtraEdgesNeeded = 0x80000000
+	graphLastEdge         = 0x80000000
+	graphOverflowNeeded   = 0x80000000
+)
+
+var ErrCorruptCommitGraph = errors.New("corrupt commit-graph")
+
+// CommitGraph is a commit-graph file, or a chain of split commit-graph files,
+// as written by `git commit-graph write`. It answers questions about the
+// shape of history without inflating commit objects.
+type CommitGraph struct {
+	layers []*commitGraphLayer // base layer first
+
+	// generationData is set if every layer has corrected commit dates
+	// (generation number v2), which are then used as generation numbers.
+	// Otherwise topological levels (v1) are used.
+	generationData bool
+}
+
+// CommitGraphCommit is what the commit-graph records about a commit.
+type CommitGraphCommit struct {
+	Id         ObjectID
+	TreeId     ObjectID
+	ParentIds  []ObjectID
+	CommitTime time.Time
+
+	// Generation is greater than the generation of every parent, so a
+	// commit can never reach a commit with a greater or equal generation.
+	Generation uint64
+}
+
+type commitGraphLayer struct {
+	f          *os.File
+	format     ObjectFormat
+	numCommits uint32
+	base       uint32 // number of commits in the layers below
+
+	oidFanout              int64
+	oidLookup              int64
+	commitData             int64
+	extraEdges             int64
+	generationData         int64
+	generationDataOverflow int64
+}
+
+// OpenCommitGraph opens the commit-graph of the objects directory dir. Like
+// git, it prefers info/commit-graph over a chain in info/commit-graphs/.
+// os.ErrNotExist is returned if there is neither. The object format is read
+// from the files.
+func OpenCommitGraph(dir string) (*CommitGraph, error) {
+	layer, err := openCommitGraphLayer(filepath.Join(dir, "info", "commit-graph"), 0, 0)
+	if err == nil {
+		return newCommitGraph([]*commitGraphLayer{layer}), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	chain, err := os.Open(filepath.Join(dir, "info", "commit-graphs", "commit-graph-chain"))
+	if err != nil {
+		return nil, err
+	}
+	defer chain.Close()
+
+	g := &CommitGraph{}
+	scan := bufio.NewScanner(chain)
+	for scan.Scan() {
+		hash := strings.TrimSpace(scan.Text())
+		if !IsObjectIDHex(hash) {
+			g.Close()
+			return nil, fmt.Errorf("%v: bad commit-graph-chain line %q", ErrCorruptCommitGraph, hash)
+		}
+
+		var base uint32
+		if n := len(g.layers); n > 0 {
+			base = g.layers[n-1].base + g.layers[n-1].numCommits
+		}
+		path := filepath.Join(dir, "info", "commit-graphs", "graph-"+hash+".graph")
+		layer, err := openCommitGraphLayer(path, len(g.layers), base)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		if len(g.layers) > 0 && layer.format != g.layers[0].format {
+			layer.f.Close()
+			g.Close()
+			return nil, fmt.Errorf("%v: %s uses %v, the layers below %v", ErrCorruptCommitGraph, path, layer.format, g.layers[0].format)
+		}
+		g.layers = append(g.layers, layer)
+	}
+	if err := scan.Err(); err != nil {
+		g.Close()
+		return nil, err
+	}
+	if len(g.layers) == 0 {
+		return nil, fmt.Errorf("%v: empty commit-graph-chain", ErrCorruptCommitGraph)
+	}
+
+	return newCommitGraph(g.layers), nil
+}
+
+func newCommitGraph(layers []*commitGraphLayer) *CommitGraph {
+	g := &CommitGraph{layers: layers, generationData: true}
+	for _, l := range layers {
+		if l.generationData == 0 {
+			g.generationData = false
+		}
+	}
+	return g
+}
+
+func openCommitGraphLayer(path string, numBase int, base uint32) (*commitGraphLayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := readCommitGraphLayer(f, numBase, base)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return l, nil
+}
+
+func readCommitGraphLayer(f *os.File, numBase int, base uint32) (*commitGraphLayer, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+
+	header := readBytesAt(f, 0, 8)
+	if !bytes.Equal(header[:4], []byte("CGPH")) {
+		return nil, fmt.Errorf("%v: wrong signature", ErrCorruptCommitGraph)
+	}
+	if header[4] != 1 {
+		return nil, fmt.Errorf("unsupported commit-graph version %d", header[4])
+	}
+	format, err := fileHashFormat(header[5])
+	if err != nil {
+		return nil, fmt.Errorf("unsupported commit-graph hash version %d", header[5])
+	}
+	numChunks := int64(header[6])
+	if int(header[7]) != numBase {
+		return nil, fmt.Errorf("%v: expected %d base graphs, got %d", ErrCorruptCommitGraph, numBase, header[7])
+	}
+
+	l := &commitGraphLayer{f: f, format: format, base: base}
+
+	// The chunk table has one extra entry marking the end of the last chunk.
+	idLen := int64(format.Size())
+	chunkEnd := size - idLen
+	for i := int64(0); i < numChunks; i++ {
+		entry := readBytesAt(f, 8+12*i, 12)
+		offset := int64(binary.BigEndian.Uint64(entry[4:]))
+		if offset < 8+12*(numChunks+1) || offset > chunkEnd {
+			return nil, fmt.Errorf("%v: chunk offset out of bounds", ErrCorruptCommitGraph)
+		}
+		switch string(entry[:4]) {
+		case "OIDF":
+			l.oidFanout = offset
+		case "OIDL":
+			l.oidLookup = offset
+		case "CDAT":
+			l.commitData = offset
+		case "EDGE":
+			l.extraEdges = offset
+		case "GDA2":
+			l.generationData = offset
+		case "GDO2":
+			l.generationDataOverflow = offset
+		}
+	}
+	if l.oidFanout == 0 || l.oidLookup == 0 || l.commitData == 0 {
+		return nil, fmt.Errorf("%v: missing required chunk", ErrCorruptCommitGraph)
+	}
+
+	l.numCommits = binary.BigEndian.Uint32(readBytesAt(f, l.oidFanout+4*255, 4))
+	if l.commitData+int64(l.numCommits)*l.commitDataLen() > chunkEnd || l.oidLookup+int64(l.numCommits)*idLen > chunkEnd {
+		return nil, fmt.Errorf("%v: too many commits for file size", ErrCorruptCommitGraph)
+	}
+	return l, nil
+}
+
+// commitDataLen returns the length of a CDAT entry: the tree id, two parent
+// positions, and the generation number and commit time.
+func (l *commitGraphLayer) commitDataLen() int64 {
+	return int64(l.format.Size()) + 16
+}
+
+// idAt returns the id of the commit at position pos of the layer.
+func (l *commitGraphLayer) idAt(pos uint32) ObjectID {
+	idLen := l.format.Size()
+	return ObjectID(readBytesAt(l.f, l.oidLookup+int64(idLen)*int64(pos), idLen))
+}
+
+// Close closes the files of the commit-graph.
+func (g *CommitGraph) Close() (err error) {
+	for _, l := range g.layers {
+		if thisErr := l.f.Close(); thisErr != nil && err == nil {
+			err = thisErr
+		}
+	}
+	return
+}
+
+// NumCommits returns the number of commits in the commit-graph.
+func (g *CommitGraph) NumCommits() int {
+	top := g.layers[len(g.layers)-1]
+	return int(top.base + top.numCommits)
+}
+
+// Lookup returns what the commit-graph records about the commit with the
+// given id, or ObjectNotFound if the commit is not in the graph.
+func (g *CommitGraph) Lookup(id ObjectID) (*CommitGraphCommit, error) {
+	pos, ok := g.find(id)
+	if !ok {
+		return nil, ObjectNotFound(id)
+	}
+	return g.commitAt(pos)
+}
+
+// find returns the graph position of the commit with the given id.
+func (g *CommitGraph) find(id ObjectID) (uint32, bool) {
+	for _, l := range g.layers {
+		if pos, ok := l.find(id); ok {
+			return l.base + pos, true
+		}
+	}
+	return 0, false
+}
+
+func (l *commitGraphLayer) find(id ObjectID) (uint32, bool) {
+	if len(id) != l.format.Size() {
+		return 0, false
+	}
+	firstByte := id[0]
+	min := uint32(0)
+	if firstByte > 0 {
+		min = binary.BigEndian.Uint32(readBytesAt(l.f, l.oidFanout+4*int64(firstByte-1), 4))
+	}
+	max := binary.BigEndian.Uint32(readBytesAt(l.f, l.oidFanout+4*int64(firstByte), 4))
+	if min >= max || max > l.numCommits {
+		return 0, false
+	}
+
+	pos, err := binarySearch(l.f, l.oidLookup, min, max-1, id)
+	if err != nil {
+		return 0, false
+	}
+	return pos, true
+}
+
+// layerAt returns the layer holding the commit at graph position pos and the
+// commit's position within the layer.
+func (g *CommitGraph) layerAt(pos uint32) (*commitGraphLayer, uint32, error) {
+	for i := len(g.layers) - 1; i >= 0; i-- {
+		l := g.layers[i]
+		if pos >= l.base {
+			if pos-l.base >= l.numCommits {
+				break
+			}
+			return l, pos - l.base, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%v: commit position %d out of range", ErrCorruptCommitGraph, pos)
+}
+
+func (g *CommitGraph) idAt(pos uint32) (ObjectID, error) {
+	l, lpos, err := g.layerAt(pos)
+	if err != nil {
+		return "", err
+	}
+	return l.idAt(lpos), nil
+}
+
+func (g *CommitGraph) commitAt(pos uint32) (*CommitGraphCommit, error) {
+	l, lpos, err := g.layerAt(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	idLen := l.format.Size()
+	data := readBytesAt(l.f, l.commitData+l.commitDataLen()*int64(lpos), int(l.commitDataLen()))
+	c := &CommitGraphCommit{
+		Id:     l.idAt(lpos),
+		TreeId: ObjectID(data[:idLen]),
+	}
+	// The parent positions, generation and commit time follow the tree id.
+	data = data[idLen:]
+
+	parents, err := g.parentPositions(l, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parents {
+		id, err := g.idAt(p)
+		if err != nil {
+			return nil, err
+		}
+		c.ParentIds = append(c.ParentIds, id)
+	}
+
+	genAndTime := binary.BigEndian.Uint64(data[8:])
+	commitTime := int64(genAndTime & (1<<34 - 1))
+	c.CommitTime = time.Unix(commitTime, 0)
+	c.Generation = genAndTime >> 34
+
+	if g.generationData {
+		offset := uint64(binary.BigEndian.Uint32(readBytesAt(l.f, l.generationData+4*int64(lpos), 4)))
+		if offset&graphOverflowNeeded != 0 {
+			if l.generationDataOverflow == 0 {
+				return nil, fmt.Errorf("%v: missing generation data overflow chunk", ErrCorruptCommitGraph)
+			}
+			i := int64(offset &^ graphOverflowNeeded)
+			offset = binary.BigEndian.Uint64(readBytesAt(l.f, l.generationDataOverflow+8*i, 8))
+		}
+		c.Generation = uint64(commitTime) + offset
+	}
+
+	return c, nil
+}
+
+// parentPositions decodes the parents recorded in a commit data entry,
+// starting after the tree id.
+func (g *CommitGraph) parentPositions(l *commitGraphLayer, data []byte) ([]uint32, error) {
+	var parents []uint32
+
+	p1 := binary.BigEndian.Uint32(data)
+	if p1 == graphParentNone {
+		return nil, nil
+	}
+	parents = append(parents, p1)
+
+	p2 := binary.BigEndian.Uint32(data[4:])
+	switch {
+	case p2 == graphParentNone:
+	case p2&graphExtraEdgesNeeded == 0:
+		parents = append(parents, p2)
+	default:
+		if l.extraEdges == 0 {
+			return nil, fmt.Errorf("%v: missing extra edges chunk", ErrCorruptCommitGraph)
+		}
+		for i := int64(p2 &^ graphExtraEdgesNeeded); ; i++ {
+			edge := binary.BigEndian.Uint32(readBytesAt(l.f, l.extraEdges+4*i, 4))
+			parents = append(parents, edge&^graphLastEdge)
+			if edge&graphLastEdge != 0 {
+				break
+			}
+			if len(parents) > int(g.NumCommits()) {
+				return nil, fmt.Errorf("%v: unterminated extra edge list", ErrCorruptCommitGraph)
+			}
+		}
+	}
+	return parents, nil
+}
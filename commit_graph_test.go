@@ -0,0 +1,193 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Commits of testdata/repo4, see testdata/prepare_repo4.sh.
+const (
+	repo4A = "83d0f7870a75a28fa0fb4b0d5e190e451cbd97ab"
+	repo4B = "817d5c49b120028f87827f9c08c16ec197608b19"
+	repo4C = "3daa4461796af116024d7289456c0b1d7f6991aa"
+	repo4D = "cf6cdf17e780522ce04aa73afab39f6deb4dec53"
+	repo4M = "83ba50bae5cbe85d1b0c7863a445d8aa3a61ca98"
+	repo4E = "7c9050ebe09858101a66e507b96ecd5c13ec6db5"
+	repo4F = "430d7cd2491c852bb37cdaad4719573b240ace05"
+)
+
+func TestCommitGraph(t *testing.T) {
+	r := openTestRepo(t, "repo4")
+	defer r.Close()
+
+	g, err := r.CommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g == nil {
+		t.Fatal("expected a commit-graph")
+	}
+	if len(g.layers) != 2 || g.NumCommits() != 7 {
+		t.Errorf("expected 7 commits in 2 layers, got %d in %d", g.NumCommits(), len(g.layers))
+	}
+
+	var prevGen uint64
+	for _, id := range []string{repo4A, repo4B, repo4D, repo4M, repo4E, repo4F} {
+		gc, err := g.Lookup(ObjectIDHex(id))
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		c, err := r.GetCommit(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gc.TreeId != c.TreeId() || len(gc.ParentIds) != len(c.ParentIds()) || !gc.CommitTime.Equal(c.Committer.When) {
+			t.Errorf("%s: commit-graph entry %+v does not match commit", id, gc)
+		}
+		for i, p := range gc.ParentIds {
+			if p != c.ParentIds()[i] {
+				t.Errorf("%s: wrong parent %d: %s", id, i, p)
+			}
+		}
+		if gc.Generation <= prevGen {
+			t.Errorf("%s: generation %d not greater than %d", id, gc.Generation, prevGen)
+		}
+		prevGen = gc.Generation
+	}
+
+	if _, err := g.Lookup(ObjectIDHex("30d74d258442c7c65512eafab474568dd706c430")); err == nil {
+		t.Error("expected lookup of a non-commit to fail")
+	}
+}
+
+func TestIsAncestorAndBehindAhead(t *testing.T) {
+	// Remove the reachability bitmap, so that the commit-graph is used.
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+	bitmaps, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.bitmap"))
+	for _, b := range bitmaps {
+		if err := os.Remove(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	f, err := r.GetCommit(repo4F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := r.GetCommit(repo4D)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{repo4A, repo4B, repo4C, repo4D, repo4M, repo4E} {
+		if !f.IsAncestor(id) {
+			t.Errorf("expected %s to be an ancestor of F", id)
+		}
+	}
+	if f.IsAncestor(repo4F) || d.IsAncestor(repo4C) || d.IsAncestor(repo4F) {
+		t.Error("unexpected ancestor")
+	}
+
+	// Counts from `git rev-list --left-right --count`, which include C on
+	// the other side of the merge M.
+	behind, ahead, err := f.BehindAhead(repo4D)
+	if err != nil || behind != 0 || ahead != 4 {
+		t.Errorf("F vs D: expected 0 behind, 4 ahead, got %d, %d, %v", behind, ahead, err)
+	}
+	behind, ahead, err = d.BehindAhead(repo4F)
+	if err != nil || behind != 4 || ahead != 0 {
+		t.Errorf("D vs F: expected 4 behind, 0 ahead, got %d, %d, %v", behind, ahead, err)
+	}
+}
+
+func TestIsAncestorWithoutCommitGraph(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	c, err := r.GetCommit("40b7c29973f5ff265a241f29c8154fa05594454f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsAncestor("8b61789a76de9edaa49b2529d3aaa302ba238c0b") {
+		t.Error("expected 8b61789 to be an ancestor")
+	}
+	if g, err := r.CommitGraph(); g != nil || err != nil {
+		t.Errorf("expected no commit-graph, got %v, %v", g, err)
+	}
+}
+
+func TestWriteCommitGraph(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	chain, err := r.CommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteCommitGraph(CommitGraphWriteOptions{ChangedPaths: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "objects", "info", "commit-graph")); err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.CommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g == chain || len(g.layers) != 1 || g.NumCommits() != 7 {
+		t.Fatalf("expected the new commit-graph to be loaded")
+	}
+
+	for _, id := range []string{repo4A, repo4B, repo4C, repo4D, repo4M, repo4E, repo4F} {
+		want, err := chain.Lookup(ObjectIDHex(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := g.Lookup(ObjectIDHex(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", id, got, want)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		hash uint32
+	}{
+		// Test vectors from git's t/t0095-bloom.sh.
+		{"", 0x00000000},
+		{"Hello world!", 0x627b0c2c},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	} {
+		if h := murmur3SeededV1(0, test.data); h != test.hash {
+			t.Errorf("murmur3(%q) = %#x, want %#x", test.data, h, test.hash)
+		}
+	}
+
+	filter := newBloomFilter([]string{"a/b/c.txt", "README"})
+	for _, p := range []string{"a", "a/b", "a/b/c.txt", "README"} {
+		if !bloomFilterContains(filter, newBloomKey(p)) {
+			t.Errorf("expected filter to contain %q", p)
+		}
+	}
+	if len(filter) != 5 { // 5 paths * 10 bits
+		t.Errorf("expected a 5 byte filter, got %d", len(filter))
+	}
+}
@@ -0,0 +1,419 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrNotFileObjectStore is returned by operations that need the files of a
+// repository's objects directory when its objects are kept elsewhere.
+var ErrNotFileObjectStore = errors.New("repository is not backed by a FileObjectStore")
+
+const (
+	generationNumberV1Max       = 0x3fffffff
+	generationNumberV2OffsetMax = 0x7fffffff
+)
+
+// CommitGraphWriteOptions configures WriteCommitGraph.
+type CommitGraphWriteOptions struct {
+	// ChangedPaths adds changed-path Bloom filters to the commit-graph, like
+	// `git commit-graph write --changed-paths`.
+	ChangedPaths bool
+}
+
+// commitGraphNode is a commit to be written to a commit-graph.
+type commitGraphNode struct {
+	id         ObjectID
+	tree       ObjectID
+	parents    []ObjectID
+	commitTime int64
+
+	level     uint32 // topological level, generation number v1
+	corrected int64  // corrected commit date, generation number v2
+	bloom     []byte
+}
+
+// WriteCommitGraph writes a commit-graph of every commit reachable from the
+// repository's refs to objects/info/commit-graph, replacing any existing one.
+// The file is written under a temporary name and renamed into place, so
+// readers never see a partial commit-graph. Generation data (generation
+// number v2) is always included.
+func (repo *Repository) WriteCommitGraph(opts CommitGraphWriteOptions) error {
+	s, ok := repo.store.(*FileObjectStore)
+	if !ok {
+		return ErrNotFileObjectStore
+	}
+
+	tips, err := repo.refTips()
+	if err != nil {
+		return err
+	}
+	nodes, err := repo.reachableCommits(tips)
+	if err != nil {
+		return err
+	}
+	computeGenerations(nodes)
+	if opts.ChangedPaths {
+		for _, n := range nodes {
+			if n.bloom, err = repo.changedPathsFilter(n); err != nil {
+				return err
+			}
+		}
+	}
+
+	infoDir := filepath.Join(s.Dir(), "info")
+	if err := os.MkdirAll(infoDir, 0775); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(infoDir, "tmp_graph_")
+	if err != nil {
+		return err
+	}
+	if err := writeCommitGraph(f, repo.format, nodes, opts); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	os.Chmod(f.Name(), 0444)
+	if err := os.Rename(f.Name(), filepath.Join(infoDir, "commit-graph")); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	repo.reloadCommitGraph()
+	return nil
+}
+
+// refTips returns the objects that HEAD and all refs point at, with tags
+// peeled to the objects they tag.
+func (repo *Repository) refTips() ([]ObjectID, error) {
+	targets, err := repo.refTargets()
+	if err != nil {
+		return nil, err
+	}
+	tips := make([]ObjectID, 0, len(targets))
+	for _, id := range targets {
+		id, err := repo.peel(id)
+		if err != nil {
+			return nil, err
+		}
+		tips = append(tips, id)
+	}
+	return tips, nil
+}
+
+// refTargets returns the objects that HEAD and all refs point at.
+func (repo *Repository) refTargets() ([]ObjectID, error) {
+	names, err := repo.refs.Refs("refs")
+	if err != nil {
+		return nil, err
+	}
+	refpaths := []string{"HEAD"}
+	for _, name := range names {
+		refpaths = append(refpaths, "refs/"+filepath.ToSlash(name))
+	}
+
+	var targets []ObjectID
+	for _, refpath := range refpaths {
+		idStr, err := repo.GetCommitIdOfRef(refpath)
+		if err != nil {
+			if _, ok := err.(RefNotFound); ok && refpath == "HEAD" {
+				// Unborn HEAD.
+				continue
+			}
+			return nil, err
+		}
+		targets = append(targets, ObjectIDHex(idStr))
+	}
+	return targets, nil
+}
+
+// peel follows annotated tags until it reaches an object that is not a tag.
+func (repo *Repository) peel(id ObjectID) (ObjectID, error) {
+	for {
+		o, err := repo.object(id, false)
+		if err != nil {
+			return "", err
+		}
+		if o.Type != ObjectTag {
+			return id, nil
+		}
+		tag, err := parseTagData(o.Data)
+		if err != nil {
+			return "", err
+		}
+		id = tag.Object
+	}
+}
+
+// reachableCommits returns the commits reachable from tips, sorted by id.
+// Tips that are not commits are ignored.
+func (repo *Repository) reachableCommits(tips []ObjectID) ([]*commitGraphNode, error) {
+	seen := map[ObjectID]bool{}
+	var nodes []*commitGraphNode
+
+	queue := append([]ObjectID(nil), tips...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		o, err := repo.object(id, false)
+		if err != nil {
+			return nil, err
+		}
+		if o.Type != ObjectCommit {
+			continue
+		}
+		c, err := parseCommitData(o.Data)
+		if err != nil {
+			return nil, err
+		}
+		var commitTime int64
+		if c.Committer != nil {
+			commitTime = c.Committer.When.Unix()
+		}
+		nodes = append(nodes, &commitGraphNode{
+			id:         id,
+			tree:       c.Tree.Id,
+			parents:    c.parents,
+			commitTime: commitTime,
+		})
+		queue = append(queue, c.parents...)
+	}
+
+	sort.Sort(commitGraphNodesById(nodes))
+	return nodes, nil
+}
+
+type commitGraphNodesById []*commitGraphNode
+
+func (ns commitGraphNodesById) Len() int           { return len(ns) }
+func (ns commitGraphNodesById) Swap(i, j int)      { ns[i], ns[j] = ns[j], ns[i] }
+func (ns commitGraphNodesById) Less(i, j int) bool { return ns[i].id < ns[j].id }
+
+// computeGenerations fills in the topological levels and corrected commit
+// dates of nodes, visiting parents before children without recursion.
+func computeGenerations(nodes []*commitGraphNode) {
+	byId := make(map[ObjectID]*commitGraphNode, len(nodes))
+	for _, n := range nodes {
+		byId[n.id] = n
+	}
+
+	done := make(map[*commitGraphNode]bool, len(nodes))
+	for _, n := range nodes {
+		stack := []*commitGraphNode{n}
+		for len(stack) > 0 {
+			cur := stack[len(stack)-1]
+			if done[cur] {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			ready := true
+			var level uint32
+			corrected := cur.commitTime
+			for _, p := range cur.parents {
+				parent := byId[p]
+				if !done[parent] {
+					ready = false
+					stack = append(stack, parent)
+					continue
+				}
+				if parent.level > level {
+					level = parent.level
+				}
+				if parent.corrected+1 > corrected {
+					corrected = parent.corrected + 1
+				}
+			}
+			if !ready {
+				continue
+			}
+
+			cur.level = level + 1
+			if cur.level > generationNumberV1Max {
+				cur.level = generationNumberV1Max
+			}
+			cur.corrected = corrected
+			done[cur] = true
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// changedPathsFilter returns the Bloom filter of the paths changed by the
+// commit relative to its first parent.
+func (repo *Repository) changedPathsFilter(n *commitGraphNode) ([]byte, error) {
+	var parentTree ObjectID
+	if len(n.parents) > 0 {
+		parent, err := repo.getCommit(n.parents[0])
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parent.TreeId()
+	}
+
+	tooMany := errors.New("too many changes")
+	var paths []string
+	err := repo.diffTree(parentTree, n.tree, func(c treeChange) error {
+		paths = append(paths, c.Path)
+		if len(paths) > bloomMaxChangedPaths {
+			return tooMany
+		}
+		return nil
+	})
+	if err != nil && err != tooMany {
+		return nil, err
+	}
+	return newBloomFilter(paths), nil
+}
+
+// writeCommitGraph writes the commit-graph file for nodes, which must be
+// sorted by id and closed under parents, and have ids of the given format.
+func writeCommitGraph(w io.Writer, format ObjectFormat, nodes []*commitGraphNode, opts CommitGraphWriteOptions) error {
+	pos := make(map[ObjectID]uint32, len(nodes))
+	for i, n := range nodes {
+		pos[n.id] = uint32(i)
+	}
+
+	type chunk struct {
+		id   string
+		data []byte
+	}
+	var chunks []chunk
+	be := binary.BigEndian
+
+	var fanout [256 * 4]byte
+	var count uint32
+	for b := 0; b < 256; b++ {
+		for int(count) < len(nodes) && int(nodes[count].id[0]) <= b {
+			count++
+		}
+		be.PutUint32(fanout[4*b:], count)
+	}
+	chunks = append(chunks, chunk{"OIDF", fanout[:]})
+
+	var oids bytes.Buffer
+	for _, n := range nodes {
+		oids.WriteString(string(n.id))
+	}
+	chunks = append(chunks, chunk{"OIDL", oids.Bytes()})
+
+	var cdat, gda2, gdo2, edge bytes.Buffer
+	var buf [8]byte
+	for _, n := range nodes {
+		cdat.WriteString(string(n.tree))
+
+		parents := make([]uint32, len(n.parents))
+		for i, p := range n.parents {
+			parentPos, ok := pos[p]
+			if !ok {
+				return ObjectNotFound(p)
+			}
+			parents[i] = parentPos
+		}
+		p1, p2 := uint32(graphParentNone), uint32(graphParentNone)
+		switch {
+		case len(parents) >= 3:
+			p1 = parents[0]
+			p2 = graphExtraEdgesNeeded | uint32(edge.Len()/4)
+			for i, p := range parents[1:] {
+				if i == len(parents)-2 {
+					p |= graphLastEdge
+				}
+				be.PutUint32(buf[:4], p)
+				edge.Write(buf[:4])
+			}
+		case len(parents) == 2:
+			p1, p2 = parents[0], parents[1]
+		case len(parents) == 1:
+			p1 = parents[0]
+		}
+		be.PutUint32(buf[:4], p1)
+		cdat.Write(buf[:4])
+		be.PutUint32(buf[:4], p2)
+		cdat.Write(buf[:4])
+		be.PutUint64(buf[:], uint64(n.level)<<34|uint64(n.commitTime)&(1<<34-1))
+		cdat.Write(buf[:])
+
+		offset := uint64(n.corrected - n.commitTime)
+		if offset > generationNumberV2OffsetMax {
+			be.PutUint32(buf[:4], graphOverflowNeeded|uint32(gdo2.Len()/8))
+			gda2.Write(buf[:4])
+			be.PutUint64(buf[:], offset)
+			gdo2.Write(buf[:])
+		} else {
+			be.PutUint32(buf[:4], uint32(offset))
+			gda2.Write(buf[:4])
+		}
+	}
+	chunks = append(chunks, chunk{"CDAT", cdat.Bytes()}, chunk{"GDA2", gda2.Bytes()})
+	if gdo2.Len() > 0 {
+		chunks = append(chunks, chunk{"GDO2", gdo2.Bytes()})
+	}
+	if edge.Len() > 0 {
+		chunks = append(chunks, chunk{"EDGE", edge.Bytes()})
+	}
+
+	if opts.ChangedPaths {
+		var bidx, bdat bytes.Buffer
+		for _, v := range []uint32{bloomHashVersion, bloomNumHashes, bloomBitsPerEntry} {
+			be.PutUint32(buf[:4], v)
+			bdat.Write(buf[:4])
+		}
+		var end uint32
+		for _, n := range nodes {
+			bdat.Write(n.bloom)
+			end += uint32(len(n.bloom))
+			be.PutUint32(buf[:4], end)
+			bidx.Write(buf[:4])
+		}
+		chunks = append(chunks, chunk{"BIDX", bidx.Bytes()}, chunk{"BDAT", bdat.Bytes()})
+	}
+
+	hash := format.New()
+	hw := io.MultiWriter(w, hash)
+
+	header := []byte{'C', 'G', 'P', 'H', 1, format.hashVersion(), byte(len(chunks)), 0}
+	if _, err := hw.Write(header); err != nil {
+		return err
+	}
+
+	offset := uint64(len(header) + 12*(len(chunks)+1))
+	var table bytes.Buffer
+	for _, c := range chunks {
+		table.WriteString(c.id)
+		be.PutUint64(buf[:], offset)
+		table.Write(buf[:])
+		offset += uint64(len(c.data))
+	}
+	table.Write([]byte{0, 0, 0, 0})
+	be.PutUint64(buf[:], offset)
+	table.Write(buf[:])
+	if _, err := hw.Write(table.Bytes()); err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		if _, err := hw.Write(c.data); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(hash.Sum(nil))
+	return err
+}
@@ -1,6 +1,9 @@
 package git
 
-import "bytes"
+import (
+	"bytes"
+	"fmt"
+)
 
 // Parse commit information from the (uncompressed) raw
 // data from the commit object.
@@ -18,17 +21,23 @@ l:
 			line := data[nextline : nextline+eol]
 			spacepos := bytes.IndexByte(line, ' ')
 			if spacepos < 0 {
-				// XXX: What do here?
-				// return nil, fmt.Errorf("failed to parse commit data: %q", line)
-				break l
+				return nil, fmt.Errorf("failed to parse commit data: %q", line)
 			}
 			reftype := line[:spacepos]
 			switch string(reftype) {
 			case "tree":
-				commit.Tree.Id = ObjectIDHex(string(line[spacepos+1:]))
+				id, err := parseObjectIDHex(string(line[spacepos+1:]))
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse commit tree: %v", err)
+				}
+				commit.Tree.Id = id
 			case "parent":
 				// A commit can have one or more parents
-				commit.parents = append(commit.parents, ObjectIDHex(string(line[spacepos+1:])))
+				id, err := parseObjectIDHex(string(line[spacepos+1:]))
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse commit parent: %v", err)
+				}
+				commit.parents = append(commit.parents, id)
 			case "author":
 				sig, err := newSignatureFromCommitline(line[spacepos+1:])
 				if err != nil {
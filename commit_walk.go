@@ -28,15 +28,16 @@ func (c *Commit) Walk(walkFn CommitWalkFunc) error {
 
 	var id ObjectID
 	for len(stack) > 0 {
-		s := stack[0]
+		s := &stack[0]
 		id, s.parents = s.parents[0], s.parents[1:]
+		path := s.path
 		if len(s.parents) == 0 {
 			// Pop the stack
 			stack = stack[1:]
 		}
 
 		cur, err := c.repo.GetCommit(id.String())
-		err = walkFn(s.path, cur, err)
+		err = walkFn(path, cur, err)
 		if err == SkipCommit {
 			continue
 		}
@@ -48,7 +49,7 @@ func (c *Commit) Walk(walkFn CommitWalkFunc) error {
 		}
 		stack = append(stack, walkStack{
 			parents: cur.parents,
-			path:    append(s.path, cur),
+			path:    append(path[:len(path):len(path)], cur),
 		})
 	}
 	return nil
@@ -0,0 +1,62 @@
+package git
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestConcurrentReads exercises the read APIs from many goroutines sharing
+// one Repository. Run with -race to detect unsynchronized access.
+func TestConcurrentReads(t *testing.T) {
+	for _, name := range []string{"repo", "repo2", "repo3"} {
+		r := openTestRepo(t, name)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 64)
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := readRepoConcurrently(r); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("%s: %v", name, err)
+		}
+		r.Close()
+	}
+}
+
+func readRepoConcurrently(r *Repository) error {
+	c, err := r.GetCommitOfBranch("master")
+	if err != nil {
+		return err
+	}
+	if _, err := r.GetCommit("8b61789a76de9edaa49b2529d3aaa302ba238c0b"); err != nil {
+		return err
+	}
+	err = c.Walk(func(path []*Commit, cur *Commit, err error) error {
+		if err != nil {
+			return err
+		}
+		return cur.Tree.Walk(func(path string, te *TreeEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			te.Size()
+			if te.Type == ObjectBlob {
+				_, err = te.Blob().Data()
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.GetBlobByPath("test.txt")
+	return err
+}
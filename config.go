@@ -0,0 +1,308 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrCorruptConfig = errors.New("corrupt config")
+
+// config holds the variables of a git config file, by their
+// "section.subsection.name" key, with the section and name in lower case.
+// A variable may have several values, in file order.
+type config map[string][]string
+
+// get returns the last value of a variable, as git does for variables that
+// are set more than once.
+func (c config) get(section, subsection, name string) (string, bool) {
+	values := c[configKey(section, subsection, name)]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// getAll returns all values of a variable.
+func (c config) getAll(section, subsection, name string) []string {
+	return c[configKey(section, subsection, name)]
+}
+
+func configKey(section, subsection, name string) string {
+	key := strings.ToLower(section) + "."
+	if subsection != "" {
+		key += subsection + "."
+	}
+	return key + strings.ToLower(name)
+}
+
+// config reads the repository's config file. A repository without a
+// directory or a config file has an empty config.
+func (repo *Repository) config() (config, error) {
+	if repo.Path == "" {
+		return config{}, nil
+	}
+	return readConfig(filepath.Join(repo.Path, "config"))
+}
+
+// readConfig reads the git config file at path, returning an empty config if
+// there is none. Includes are not followed.
+func readConfig(path string) (config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// parseConfig parses the syntax documented in git-config(1): [section] and
+// [section "subsection"] headers, and "name = value" lines with quoting,
+// escapes, comments and line continuations. A name without a value is true.
+// A leading UTF-8 byte order mark is skipped, as git does. Like git, it
+// fails on the first line that cannot be parsed.
+func parseConfig(data []byte) (config, error) {
+	c, errs := scanConfig(data)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return c, nil
+}
+
+// scanConfig is parseConfig for callers that only need part of a config
+// that may have broken lines elsewhere. Lines that cannot be parsed are
+// skipped, as are the variables under a broken header, and returned with
+// the variables of the other lines.
+func scanConfig(data []byte) (config, configSyntaxErrors) {
+	c := config{}
+	p := &configParser{data: bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), line: 1}
+	var errs configSyntaxErrors
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return c, errs
+		}
+		start, line := p.pos, p.line
+		var err error
+		switch ch := p.peek(); {
+		case ch == '\n':
+			p.next()
+		case ch == '#' || ch == ';':
+			p.skipLine()
+		case ch == '[':
+			err = p.header()
+		case isConfigNameChar(ch):
+			if p.section == "" {
+				err = p.errorf("variable outside of a section")
+				break
+			}
+			var name, value string
+			name, value, err = p.variable()
+			if err == nil && !p.badHeader {
+				key := p.section + "." + strings.ToLower(name)
+				c[key] = append(c[key], value)
+			}
+		default:
+			err = p.errorf("unexpected %q", ch)
+		}
+		if err != nil {
+			errs = append(errs, err.(*configSyntaxError))
+			p.pos, p.line = start, line
+			p.skipLine()
+		}
+	}
+}
+
+// configSyntaxError is a line of a config file that cannot be parsed.
+type configSyntaxError struct {
+	line    int
+	section string // the section the line is in, "" if unknown
+	msg     string
+}
+
+func (e *configSyntaxError) Error() string {
+	return fmt.Sprintf("%v: line %d: %s", ErrCorruptConfig, e.line, e.msg)
+}
+
+// configSyntaxErrors are the lines of a config file that cannot be parsed,
+// in file order.
+type configSyntaxErrors []*configSyntaxError
+
+// inSection reports whether a line that cannot be parsed is, or may be, in
+// the given section or one of its subsections.
+func (errs configSyntaxErrors) inSection(section string) bool {
+	for _, e := range errs {
+		if e.section == "" || e.section == section || strings.HasPrefix(e.section, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+type configParser struct {
+	data []byte
+	pos  int
+	line int
+
+	section   string // key prefix of the variables, "" before the first header
+	badHeader bool   // whether the section header could not be parsed
+}
+
+func (p *configParser) eof() bool { return p.pos >= len(p.data) }
+
+func (p *configParser) peek() byte { return p.data[p.pos] }
+
+func (p *configParser) next() byte {
+	ch := p.data[p.pos]
+	p.pos++
+	if ch == '\n' {
+		p.line++
+	}
+	return ch
+}
+
+func (p *configParser) errorf(format string, args ...interface{}) error {
+	return &configSyntaxError{line: p.line, section: p.section, msg: fmt.Sprintf(format, args...)}
+}
+
+// skipSpace skips blanks, but not newlines.
+func (p *configParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r') {
+		p.next()
+	}
+}
+
+func (p *configParser) skipLine() {
+	for !p.eof() && p.next() != '\n' {
+	}
+}
+
+func isConfigNameChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-'
+}
+
+// header parses a section header and sets the key prefix of the variables
+// that follow. If the header is broken, they go in the section as far as it
+// could be read, and are skipped.
+func (p *configParser) header() error {
+	p.next() // '['
+	start := p.pos
+	for !p.eof() && (isConfigNameChar(p.peek()) || p.peek() == '.') {
+		p.next()
+	}
+	p.section = strings.ToLower(string(p.data[start:p.pos]))
+	p.badHeader = true
+	if p.section == "" {
+		return p.errorf("bad section header")
+	}
+
+	p.skipSpace()
+	if !p.eof() && p.peek() == '"' {
+		p.next()
+		var sub bytes.Buffer
+		for {
+			if p.eof() || p.peek() == '\n' {
+				return p.errorf("unterminated subsection")
+			}
+			ch := p.next()
+			if ch == '"' {
+				break
+			}
+			if ch == '\\' {
+				if p.eof() || p.peek() == '\n' {
+					return p.errorf("unterminated subsection")
+				}
+				ch = p.next()
+			}
+			sub.WriteByte(ch)
+		}
+		p.section += "." + sub.String()
+	}
+	if p.eof() || p.next() != ']' {
+		return p.errorf("bad section header")
+	}
+	p.badHeader = false
+	return nil
+}
+
+// variable parses a "name = value" line.
+func (p *configParser) variable() (string, string, error) {
+	start := p.pos
+	for !p.eof() && isConfigNameChar(p.peek()) {
+		p.next()
+	}
+	name := string(p.data[start:p.pos])
+	p.skipSpace()
+	if p.eof() || p.peek() == '\n' || p.peek() == '#' || p.peek() == ';' {
+		p.skipLine()
+		return name, "true", nil
+	}
+	if p.next() != '=' {
+		return "", "", p.errorf("bad variable %q", name)
+	}
+	p.skipSpace()
+
+	// Unquoted whitespace is kept as spaces between words, but not at the
+	// end.
+	var value bytes.Buffer
+	quoted := false
+	pending := 0 // length of value without unquoted trailing whitespace
+	for !p.eof() {
+		ch := p.next()
+		switch {
+		case ch == '\n':
+			if quoted {
+				return "", "", p.errorf("unterminated quote")
+			}
+			value.Truncate(pending)
+			return name, value.String(), nil
+		case !quoted && (ch == '#' || ch == ';'):
+			p.skipLine()
+			value.Truncate(pending)
+			return name, value.String(), nil
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\\':
+			if p.eof() {
+				return "", "", p.errorf("trailing backslash")
+			}
+			switch esc := p.next(); esc {
+			case '\n':
+				// Line continuation.
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			case '\\', '"':
+				value.WriteByte(esc)
+			default:
+				return "", "", p.errorf("bad escape \\%c", esc)
+			}
+			pending = value.Len()
+			continue
+		case !quoted && (ch == ' ' || ch == '\t' || ch == '\r'):
+			value.WriteByte(' ')
+			continue
+		default:
+			value.WriteByte(ch)
+		}
+		pending = value.Len()
+	}
+	if quoted {
+		return "", "", p.errorf("unterminated quote")
+	}
+	value.Truncate(pending)
+	return name, value.String(), nil
+}
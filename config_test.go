@@ -0,0 +1,104 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := parseConfig([]byte(`# comment
+[core]
+	bare = true
+	FileMode = false ; trailing comment
+	logAllRefUpdates
+[remote "origin"]
+	url = "https://example.com/a b.git"
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[Branch "Feature/\"x\""]
+	merge = refs/heads/feature
+[alias]
+	lg = log --graph \
+		--oneline
+	esc = "a\tb\\c\"d" # x
+	semi = "a;b"
+[section.Sub]
+	key = value
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		section, subsection, name, want string
+	}{
+		{"core", "", "bare", "true"},
+		{"CORE", "", "filemode", "false"},
+		{"core", "", "logallrefupdates", "true"},
+		{"remote", "origin", "url", "https://example.com/a b.git"},
+		{"remote", "origin", "fetch", "+refs/tags/*:refs/tags/*"},
+		{"branch", `Feature/"x"`, "merge", "refs/heads/feature"},
+		{"alias", "", "lg", "log --graph   --oneline"},
+		{"alias", "", "esc", "a\tb\\c\"d"},
+		{"alias", "", "semi", "a;b"},
+		{"section", "sub", "key", "value"},
+	} {
+		got, ok := c.get(test.section, test.subsection, test.name)
+		if !ok || got != test.want {
+			t.Errorf("%s.%s.%s: expected %q, got %q, %v", test.section, test.subsection, test.name, test.want, got, ok)
+		}
+	}
+	if _, ok := c.get("branch", "feature/\"x\"", "merge"); ok {
+		t.Error("subsections should be case sensitive")
+	}
+	want := []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}
+	if got := c.getAll("remote", "origin", "fetch"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	for _, bad := range []string{
+		"key = value\n",
+		"[core\n",
+		"[remote \"origin]\n",
+		"[core]\n\tkey = \"unterminated\n",
+		"[core]\n\tkey = \\q\n",
+		"[core]\n\tkey value\n",
+	} {
+		if _, err := parseConfig([]byte(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+
+	c, err = parseConfig([]byte("\xef\xbb\xbf[core]\n\tbare = true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.get("core", "", "bare"); v != "true" {
+		t.Errorf("expected core.bare after the byte order mark, got %q", v)
+	}
+}
+
+func TestScanConfig(t *testing.T) {
+	data := []byte("[core]\n\tbare = true\n\tx = \"y\n[remote \"origin]\n\turl = u\n[user]\n\tname = n\n")
+	if c, err := parseConfig(data); c != nil || err == nil {
+		t.Errorf("expected parseConfig to fail, got %v, %v", c, err)
+	}
+
+	// The other lines are still read, except under a broken header.
+	c, errs := scanConfig(data)
+	if len(errs) != 2 || errs[0].section != "core" || errs[1].section != "remote" {
+		t.Errorf("expected errors in core and remote, got %v", errs)
+	}
+	if v, _ := c.get("core", "", "bare"); v != "true" {
+		t.Errorf("expected core.bare before the broken line, got %q", v)
+	}
+	if v, _ := c.get("user", "", "name"); v != "n" {
+		t.Errorf("expected user.name after the broken lines, got %q", v)
+	}
+	if _, ok := c.get("remote", "origin", "url"); ok {
+		t.Error("expected no variables under a broken header")
+	}
+	if errs.inSection("user") || !errs.inSection("remote") {
+		t.Error("expected errors in remote only")
+	}
+}
@@ -0,0 +1,130 @@
+package git
+
+import "encoding/binary"
+
+const (
+	deltaBlockSize   = 16
+	deltaMaxInsert   = 0x7f
+	deltaMaxCopy     = 0x10000
+	deltaMaxBaseSize = 1 << 32
+)
+
+// deltaIndex indexes the blocks of a delta base, so that makeDelta can find
+// the parts of a target that also appear in the base.
+type deltaIndex struct {
+	base   []byte
+	blocks map[string]int // block content -> offset in base
+}
+
+func newDeltaIndex(base []byte) *deltaIndex {
+	idx := &deltaIndex{
+		base:   base,
+		blocks: make(map[string]int, len(base)/deltaBlockSize),
+	}
+	for i := 0; i+deltaBlockSize <= len(base) && i < deltaMaxBaseSize; i += deltaBlockSize {
+		block := string(base[i : i+deltaBlockSize])
+		if _, ok := idx.blocks[block]; !ok {
+			idx.blocks[block] = i
+		}
+	}
+	return idx
+}
+
+// makeDelta returns a delta in git's format that turns the indexed base into
+// target, as decoded by applyDelta. If maxSize is positive and the delta
+// would be larger, nil is returned.
+func makeDelta(idx *deltaIndex, target []byte, maxSize int) []byte {
+	base := idx.base
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(base)))
+	delta := append([]byte(nil), buf[:n]...)
+	n = binary.PutUvarint(buf[:], uint64(len(target)))
+	delta = append(delta, buf[:n]...)
+
+	tooBig := func() bool {
+		return maxSize > 0 && len(delta) > maxSize
+	}
+
+	insertStart := 0
+	for i := 0; i+deltaBlockSize <= len(target); {
+		offset, ok := idx.blocks[string(target[i:i+deltaBlockSize])]
+		if !ok {
+			i++
+			continue
+		}
+
+		// Grow the match backwards into the pending insert, and forwards.
+		start, baseStart := i, offset
+		for start > insertStart && baseStart > 0 && target[start-1] == base[baseStart-1] {
+			start--
+			baseStart--
+		}
+		end, baseEnd := i+deltaBlockSize, offset+deltaBlockSize
+		for end < len(target) && baseEnd < len(base) && target[end] == base[baseEnd] {
+			end++
+			baseEnd++
+		}
+
+		delta = appendDeltaInsert(delta, target[insertStart:start])
+		delta = appendDeltaCopy(delta, baseStart, end-start)
+		if tooBig() {
+			return nil
+		}
+		i, insertStart = end, end
+	}
+	delta = appendDeltaInsert(delta, target[insertStart:])
+	if tooBig() {
+		return nil
+	}
+	return delta
+}
+
+// appendDeltaInsert appends instructions inserting data literally.
+func appendDeltaInsert(delta, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > deltaMaxInsert {
+			n = deltaMaxInsert
+		}
+		delta = append(delta, byte(n))
+		delta = append(delta, data[:n]...)
+		data = data[n:]
+	}
+	return delta
+}
+
+// appendDeltaCopy appends instructions copying size bytes at offset of the
+// base. Only the non-zero bytes of the offset and size are stored, and a size
+// of 0x10000 is stored as zero.
+func appendDeltaCopy(delta []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > deltaMaxCopy {
+			n = deltaMaxCopy
+		}
+
+		op := byte(0x80)
+		var args [7]byte
+		numArgs := 0
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				op |= 1 << i
+				args[numArgs] = b
+				numArgs++
+			}
+		}
+		for i := uint(0); i < 3; i++ {
+			if b := byte(n >> (8 * i)); b != 0 {
+				op |= 0x10 << i
+				args[numArgs] = b
+				numArgs++
+			}
+		}
+		delta = append(delta, op)
+		delta = append(delta, args[:numArgs]...)
+
+		offset += n
+		size -= n
+	}
+	return delta
+}
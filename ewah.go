@@ -0,0 +1,145 @@
+package git
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+var errCorruptEWAH = errors.New("corrupt EWAH bitmap")
+
+// bitset is an uncompressed bitmap, bit i being bit i%64 of word i/64.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i uint32) {
+	b[i/64] |= 1 << (i % 64)
+}
+
+func (b bitset) test(i uint32) bool {
+	return int(i/64) < len(b) && b[i/64]&(1<<(i%64)) != 0
+}
+
+func (b bitset) or(o bitset) {
+	for i := range b {
+		if i >= len(o) {
+			break
+		}
+		b[i] |= o[i]
+	}
+}
+
+func (b bitset) xor(o bitset) {
+	for i := range b {
+		if i >= len(o) {
+			break
+		}
+		b[i] ^= o[i]
+	}
+}
+
+func (b bitset) and(o bitset) {
+	for i := range b {
+		if i >= len(o) {
+			b[i] = 0
+			continue
+		}
+		b[i] &= o[i]
+	}
+}
+
+func (b bitset) andNot(o bitset) {
+	for i := range b {
+		if i >= len(o) {
+			break
+		}
+		b[i] &^= o[i]
+	}
+}
+
+func (b bitset) count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+func (b bitset) clone() bitset {
+	return append(bitset(nil), b...)
+}
+
+// forEach calls fn with the index of every set bit, in increasing order.
+func (b bitset) forEach(fn func(i uint32) error) error {
+	for wi, w := range b {
+		for w != 0 {
+			bit := bits.TrailingZeros64(w)
+			if err := fn(uint32(wi*64 + bit)); err != nil {
+				return err
+			}
+			w &= w - 1
+		}
+	}
+	return nil
+}
+
+// ewahSize returns the number of bytes of the serialized EWAH bitmap at
+// offset, so a reader can skip over it.
+func ewahSize(r io.ReaderAt, offset int64) int64 {
+	numWords := binary.BigEndian.Uint32(readBytesAt(r, offset+4, 4))
+	return 4 + 4 + 8*int64(numWords) + 4
+}
+
+// readEWAH decodes the EWAH compressed bitmap at offset, as serialized by
+// git: the size in bits, the number of 64-bit words, the words and the
+// position of the last run length word. n is the number of bits the result
+// must be able to hold.
+func readEWAH(r io.ReaderAt, offset int64, n int) (bitset, error) {
+	header := readBytesAt(r, offset, 8)
+	bitSize := binary.BigEndian.Uint32(header)
+	numWords := binary.BigEndian.Uint32(header[4:])
+	b := newBitset(n)
+	if int(bitSize) > len(b)*64 || int(numWords) > 2*len(b)+1 {
+		return nil, errCorruptEWAH
+	}
+	words := readBytesAt(r, offset+8, 8*int(numWords))
+
+	pos := 0
+	for i := 0; i < int(numWords); {
+		rlw := binary.BigEndian.Uint64(words[8*i:])
+		i++
+
+		runningBit := rlw&1 != 0
+		runningLen := int((rlw >> 1) & 0xffffffff)
+		literalWords := int(rlw >> 33)
+		if pos+runningLen+literalWords > len(b) || i+literalWords > int(numWords) {
+			return nil, errCorruptEWAH
+		}
+
+		if runningBit {
+			for j := 0; j < runningLen; j++ {
+				b[pos+j] = ^uint64(0)
+			}
+		}
+		pos += runningLen
+
+		for j := 0; j < literalWords; j++ {
+			b[pos] = binary.BigEndian.Uint64(words[8*i:])
+			pos++
+			i++
+		}
+	}
+
+	// Clear any bits a run of ones set beyond the bitmap's size.
+	if w := int(bitSize / 64); w < len(b) {
+		b[w] &= 1<<(bitSize%64) - 1
+		for i := w + 1; i < len(b); i++ {
+			b[i] = 0
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,90 @@
+package git
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrEmptyPath = errors.New("file history needs a path")
+
+// FileHistoryEntry is a commit that changed a file, with the path the file
+// has in it. OldPath is the path the file was renamed or copied from by the
+// commit, if any.
+type FileHistoryEntry struct {
+	Commit  *Commit
+	Path    string
+	OldPath string
+}
+
+// FileHistory returns the commits that changed the file at path, from the
+// commit id back, newest first. Without follow it is a walk limited to the
+// path, with git's default history simplification. With follow it is
+// git log --follow: merges are left out, and when a commit adds the file,
+// the file it was renamed or copied from, if any, is tracked instead in
+// the commits before.
+func (repo *Repository) FileHistory(id ObjectID, path string, follow bool) ([]FileHistoryEntry, error) {
+	p := cleanPaths([]string{path})[0]
+	if p == "" {
+		return nil, ErrEmptyPath
+	}
+
+	var opts RevWalkOptions
+	if !follow {
+		opts.Paths = []string{p}
+	}
+	w := repo.NewRevWalk(opts)
+	if err := w.Push(id); err != nil {
+		return nil, err
+	}
+
+	var history []FileHistoryEntry
+	for {
+		c, err := w.Next()
+		if err == io.EOF {
+			return history, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !follow {
+			history = append(history, FileHistoryEntry{Commit: c, Path: p})
+			continue
+		}
+		if len(c.parents) > 1 {
+			continue
+		}
+
+		var parentTree ObjectID
+		if len(c.parents) == 1 {
+			parent, err := repo.getCommit(c.parents[0])
+			if err != nil {
+				return nil, err
+			}
+			parentTree = parent.TreeId()
+		}
+		same, err := repo.sameAtPath(parentTree, c.TreeId(), p)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			continue
+		}
+
+		entry := FileHistoryEntry{Commit: c, Path: p}
+		if parentTree != "" {
+			e, err := repo.treeEntryAtPath(parentTree, p)
+			if err != nil {
+				return nil, err
+			}
+			if e == nil {
+				if entry.OldPath, err = repo.findRenameSource(parentTree, c.TreeId(), p); err != nil {
+					return nil, err
+				}
+				if entry.OldPath != "" {
+					p = entry.OldPath
+				}
+			}
+		}
+		history = append(history, entry)
+	}
+}
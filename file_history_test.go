@@ -0,0 +1,75 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFileHistory(t *testing.T) {
+	r := openTestRepo(t, "repo7")
+	defer r.Close()
+
+	// Expected results are from git log [--follow] --name-status <rev> --
+	// <path>, as summaries with the paths that changed.
+	for _, test := range []struct {
+		rev    string
+		path   string
+		follow bool
+		want   string
+	}{
+		{repo7T, "copy.txt", true, "I dir/a2.txt->copy.txt, E a.txt->dir/a2.txt, B a.txt, A a.txt"},
+		{repo7L, "moved.txt", true, "L dir/a2.txt->moved.txt, I dir/a2.txt, E a.txt->dir/a2.txt, B a.txt, A a.txt"},
+		{repo7L, "/moved.txt", false, "L moved.txt"},
+		{repo7L, "b.txt", true, "F b.txt, C b.txt, A b.txt"},
+		{repo7T, "e.txt", true, "T2 e.txt, T1 e.txt"},
+		{repo7T, "e.txt", false, "T1 e.txt"},
+		{repo7T, "c.txt", true, "D c.txt, G c.txt"},
+		{repo7L, "d.txt", true, "K d.txt"},
+		{repo7L, "nothing", true, ""},
+	} {
+		history, err := r.FileHistory(ObjectIDHex(test.rev), test.path, test.follow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range history {
+			if e.OldPath != "" {
+				got = append(got, fmt.Sprintf("%s %s->%s", e.Commit.Summary(), e.OldPath, e.Path))
+			} else {
+				got = append(got, e.Commit.Summary()+" "+e.Path)
+			}
+		}
+		if g := strings.Join(got, ", "); g != test.want {
+			t.Errorf("%s %s follow=%v: expected %q, got %q", test.rev, test.path, test.follow, test.want, g)
+		}
+	}
+
+	if _, err := r.FileHistory(ObjectIDHex(repo7L), "/", true); err != ErrEmptyPath {
+		t.Errorf("expected ErrEmptyPath, got %v", err)
+	}
+}
+
+func TestRenameScore(t *testing.T) {
+	r := openTestRepo(t, "repo7")
+	defer r.Close()
+
+	// git reports L as a rename of dir/a2.txt to moved.txt with 80%
+	// similarity.
+	var data [2][]byte
+	for i, rev := range []string{repo7I + ":dir/a2.txt", repo7L + ":moved.txt"} {
+		id, err := r.ResolveRevision(rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := r.object(id, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[i] = o.Data
+	}
+	score := renameScore(data[0], newSpanHashes(data[0]), data[1], newSpanHashes(data[1]))
+	if got := score * 100 / maxRenameScore; got != 80 {
+		t.Errorf("expected a similarity of 80%%, got %d%% (%d)", got, score)
+	}
+}
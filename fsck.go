@@ -0,0 +1,502 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FsckProblem is the kind of a problem found by Repository.Fsck.
+type FsckProblem int
+
+const (
+	// FsckMissing is reported for objects that are referenced by another
+	// object or a ref, but are not in the repository.
+	FsckMissing FsckProblem = iota
+
+	// FsckCorrupt is reported for objects that cannot be read, do not hash
+	// to their id or are malformed, and for damaged packs.
+	FsckCorrupt
+
+	// FsckDangling is reported for objects that are not referenced by
+	// another object, a ref, a reflog or the index.
+	FsckDangling
+)
+
+func (p FsckProblem) String() string {
+	switch p {
+	case FsckMissing:
+		return "missing"
+	case FsckCorrupt:
+		return "corrupt"
+	case FsckDangling:
+		return "dangling"
+	default:
+		return "invalid"
+	}
+}
+
+// FsckFinding is a problem found by Repository.Fsck.
+type FsckFinding struct {
+	Problem FsckProblem
+
+	// Id and Type identify the object the problem is about. Id is empty for
+	// problems with a whole pack, and Type is 0 if it is not known.
+	Id   ObjectID
+	Type ObjectType
+
+	// Pack is the name of the pack the problem was found in, such as
+	// "pack-<checksum>", or empty for loose objects.
+	Pack string
+
+	// Message describes the problem, or is empty for dangling objects.
+	Message string
+}
+
+func (f FsckFinding) String() string {
+	s := f.Problem.String()
+	switch {
+	case f.Id == "":
+		s += " pack " + f.Pack
+	case f.Type != 0:
+		s += fmt.Sprintf(" %s %s", f.Type, f.Id)
+	default:
+		s += " object " + f.Id.String()
+	}
+	if f.Message != "" {
+		s += ": " + f.Message
+	}
+	return s
+}
+
+// FsckOptions configures Repository.Fsck.
+type FsckOptions struct {
+	// NoDangling disables the search for dangling objects.
+	NoDangling bool
+}
+
+// fsckLink is a reference from one object to another.
+type fsckLink struct {
+	from, to ObjectID
+	fromType ObjectType
+	toType   ObjectType // the type the referencing object expects
+}
+
+// fsckChecker collects the objects of a repository and the problems found
+// with them.
+type fsckChecker struct {
+	repo     *Repository
+	findings []FsckFinding
+	types    map[ObjectID]ObjectType
+	links    []fsckLink
+}
+
+// Fsck verifies the loose and packed objects of the repository, like
+// `git fsck`: that they hash to their ids, that commits, trees and tags are
+// well formed and refer to existing objects of the right type, and that
+// packs are not damaged. Objects that are not referenced by other objects,
+// the refs, the reflogs or the index are reported as dangling.
+//
+// Problems are returned as findings sorted by kind and id. The error is only
+// set if the check itself could not be completed.
+func (repo *Repository) Fsck(opts FsckOptions) ([]FsckFinding, error) {
+	c := &fsckChecker{repo: repo, types: map[ObjectID]ObjectType{}}
+
+	var err error
+	if s, ok := repo.store.(*FileObjectStore); ok {
+		err = c.checkFileStore(s)
+	} else {
+		err = repo.store.ForEach(func(id ObjectID) error {
+			o, err := repo.store.Get(id, false)
+			c.checkObject(id, o, err, "")
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.checkLinks()
+	if !opts.NoDangling {
+		if err := c.findDangling(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(c.findings, func(i, j int) bool {
+		a, b := c.findings[i], c.findings[j]
+		if a.Problem != b.Problem {
+			return a.Problem < b.Problem
+		}
+		return a.Id < b.Id
+	})
+	return c.findings, nil
+}
+
+func (c *fsckChecker) add(problem FsckProblem, id ObjectID, typ ObjectType, pack, format string, args ...interface{}) {
+	c.findings = append(c.findings, FsckFinding{
+		Problem: problem,
+		Id:      id,
+		Type:    typ,
+		Pack:    pack,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkFileStore checks every copy of every object in s, rather than only
+// the one Get returns.
+func (c *fsckChecker) checkFileStore(s *FileObjectStore) error {
+	err := s.forEachLoose(func(id ObjectID) error {
+		o, err := readLooseObject(s.looseObjectPath(id), false)
+		c.checkObject(id, o, err, "")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ps := s.acquire()
+	defer ps.release()
+	for _, p := range ps.packs {
+		if !c.checkPack(p) {
+			continue
+		}
+		err := p.forEach(func(id ObjectID) error {
+			offset, err := p.find(id)
+			var o *Object
+			if err == nil {
+				o, err = p.objectAtOffset(offset, false)
+			}
+			c.checkObject(id, o, err, p.id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPack verifies the checksums of a pack and its index, and that they
+// agree on the number of objects. It returns false if the pack's objects
+// cannot be read at all.
+func (c *fsckChecker) checkPack(p *pack) bool {
+	if _, err := p.indexFileReader(); err != nil {
+		c.add(FsckCorrupt, "", 0, p.id, "cannot read index: %v", err)
+		return false
+	}
+	if _, err := p.packFileReader(); err != nil {
+		c.add(FsckCorrupt, "", 0, p.id, "cannot read pack: %v", err)
+		return false
+	}
+
+	packSum, err := fileChecksum(p.packFile, p.store.format)
+	if err != nil {
+		c.add(FsckCorrupt, "", 0, p.id, "pack: %v", err)
+	} else if indexSum, err := p.checksum(); err != nil || !bytes.Equal(packSum, indexSum) {
+		c.add(FsckCorrupt, "", 0, p.id, "pack checksum does not match its index")
+	}
+	if _, err := fileChecksum(p.indexFile, p.store.format); err != nil {
+		c.add(FsckCorrupt, "", 0, p.id, "index: %v", err)
+	}
+
+	count := binary.BigEndian.Uint32(readBytesAt(p.packFile, 8, 4))
+	if n := readIndexLayout(p.indexFile, p.store.format).numObjects; n != count {
+		c.add(FsckCorrupt, "", 0, p.id, "pack has %d objects, index has %d", count, n)
+	}
+	return true
+}
+
+// fileChecksum verifies the trailer of a pack or index file, a hash of the
+// rest of the file in the given format, and returns it.
+func fileChecksum(f *os.File, format ObjectFormat) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := int64(format.Size())
+	if info.Size() < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	h := format.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, info.Size()-size)); err != nil {
+		return nil, err
+	}
+	sum := readBytesAt(f, info.Size()-size, int(size))
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return sum, nil
+}
+
+// checkObject checks one copy of an object, read with error err.
+func (c *fsckChecker) checkObject(id ObjectID, o *Object, err error, pack string) {
+	if err != nil {
+		c.add(FsckCorrupt, id, 0, pack, "cannot read object: %v", err)
+		return
+	}
+
+	if err := verifyObject(id, o); err != nil {
+		c.add(FsckCorrupt, id, o.Type, pack, "hash mismatch, content hashes to %s", err.(ObjectCorrupt).Actual)
+		return
+	}
+	c.types[id] = o.Type
+
+	switch o.Type {
+	case ObjectCommit:
+		err = c.checkCommit(id, o.Data)
+	case ObjectTree:
+		err = c.checkTree(id, o.Data)
+	case ObjectTag:
+		err = c.checkTag(id, o.Data)
+	case ObjectBlob:
+	default:
+		err = fmt.Errorf("unknown object type %d", o.Type)
+	}
+	if err != nil {
+		c.add(FsckCorrupt, id, o.Type, pack, "%v", err)
+	}
+}
+
+func (c *fsckChecker) link(from ObjectID, fromType ObjectType, to ObjectID, toType ObjectType) {
+	c.links = append(c.links, fsckLink{from: from, to: to, fromType: fromType, toType: toType})
+}
+
+// fsckHeaders splits the headers of a commit or tag into names and values. A
+// header line starting with a space continues the previous value.
+func fsckHeaders(data []byte) ([][2]string, error) {
+	var headers [][2]string
+	for len(data) > 0 {
+		eol := bytes.IndexByte(data, '\n')
+		if eol < 0 {
+			return nil, fmt.Errorf("unterminated header")
+		}
+		line := data[:eol]
+		data = data[eol+1:]
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, fmt.Errorf("continuation line without header")
+			}
+			continue
+		}
+		sp := bytes.IndexByte(line, ' ')
+		if sp <= 0 {
+			return nil, fmt.Errorf("malformed header %q", line)
+		}
+		headers = append(headers, [2]string{string(line[:sp]), string(line[sp+1:])})
+	}
+	return headers, nil
+}
+
+// expectHeader checks that the next header has the given name and returns
+// its value.
+func expectHeader(headers *[][2]string, name string) (string, error) {
+	if len(*headers) == 0 || (*headers)[0][0] != name {
+		return "", fmt.Errorf("missing %s header", name)
+	}
+	value := (*headers)[0][1]
+	*headers = (*headers)[1:]
+	return value, nil
+}
+
+func (c *fsckChecker) checkCommit(id ObjectID, data []byte) error {
+	headers, err := fsckHeaders(data)
+	if err != nil {
+		return err
+	}
+
+	tree, err := expectHeader(&headers, "tree")
+	if err != nil {
+		return err
+	}
+	if !IsObjectIDHex(tree) {
+		return fmt.Errorf("bad tree id %q", tree)
+	}
+	c.link(id, ObjectCommit, ObjectIDHex(tree), ObjectTree)
+
+	for len(headers) > 0 && headers[0][0] == "parent" {
+		parent := headers[0][1]
+		if !IsObjectIDHex(parent) {
+			return fmt.Errorf("bad parent id %q", parent)
+		}
+		c.link(id, ObjectCommit, ObjectIDHex(parent), ObjectCommit)
+		headers = headers[1:]
+	}
+
+	for _, name := range []string{"author", "committer"} {
+		sig, err := expectHeader(&headers, name)
+		if err != nil {
+			return err
+		}
+		if err := fsckSignature(sig); err != nil {
+			return fmt.Errorf("bad %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (c *fsckChecker) checkTag(id ObjectID, data []byte) error {
+	headers, err := fsckHeaders(data)
+	if err != nil {
+		return err
+	}
+
+	object, err := expectHeader(&headers, "object")
+	if err != nil {
+		return err
+	}
+	if !IsObjectIDHex(object) {
+		return fmt.Errorf("bad object id %q", object)
+	}
+	typeName, err := expectHeader(&headers, "type")
+	if err != nil {
+		return err
+	}
+	var typ ObjectType
+	for _, t := range []ObjectType{ObjectCommit, ObjectTree, ObjectBlob, ObjectTag} {
+		if t.String() == typeName {
+			typ = t
+		}
+	}
+	if typ == 0 {
+		return fmt.Errorf("bad object type %q", typeName)
+	}
+	c.link(id, ObjectTag, ObjectIDHex(object), typ)
+
+	if _, err := expectHeader(&headers, "tag"); err != nil {
+		return err
+	}
+	// Very old tags have no tagger.
+	if len(headers) > 0 && headers[0][0] == "tagger" {
+		if err := fsckSignature(headers[0][1]); err != nil {
+			return fmt.Errorf("bad tagger: %v", err)
+		}
+	}
+	return nil
+}
+
+// fsckSignature checks an author, committer or tagger of the form
+// "Name <email> seconds timezone".
+func fsckSignature(sig string) error {
+	lt := strings.IndexByte(sig, '<')
+	gt := strings.IndexByte(sig, '>')
+	if lt < 0 || gt < lt {
+		return fmt.Errorf("bad email in %q", sig)
+	}
+	fields := strings.Fields(sig[gt+1:])
+	if len(fields) != 2 {
+		return fmt.Errorf("bad date in %q", sig)
+	}
+	if _, err := strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return fmt.Errorf("bad date in %q", sig)
+	}
+	tz := fields[1]
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return fmt.Errorf("bad timezone in %q", sig)
+	}
+	if _, err := strconv.ParseUint(tz[1:], 10, 16); err != nil {
+		return fmt.Errorf("bad timezone in %q", sig)
+	}
+	return nil
+}
+
+// checkTree checks that the entries of a tree have valid modes and names,
+// and are sorted the way git sorts them, with trees compared as if their
+// names ended in a slash.
+func (c *fsckChecker) checkTree(id ObjectID, data []byte) error {
+	scanner := newTreeScanner(nil, bytes.NewReader(data), objectFormatOf(id))
+	seen := map[string]bool{}
+	var prev string
+	for scanner.Scan() {
+		e := scanner.TreeEntry()
+		switch {
+		case e.name == "." || e.name == ".." || e.name == ".git" || strings.Contains(e.name, "/"):
+			return fmt.Errorf("bad entry name %q", e.name)
+		case seen[e.name]:
+			return fmt.Errorf("duplicate entry %q", e.name)
+		}
+		seen[e.name] = true
+
+		name := e.name
+		if e.mode == ModeTree {
+			name += "/"
+		}
+		if name < prev {
+			return fmt.Errorf("entries not sorted at %q", e.name)
+		}
+		prev = name
+
+		// Submodule commits live in other repositories.
+		if e.mode != ModeCommit {
+			c.link(id, ObjectTree, e.Id, e.Type)
+		}
+	}
+	return scanner.Err()
+}
+
+// checkLinks reports references to objects that are missing or have the
+// wrong type. Objects that were not visited, such as objects of alternates,
+// are looked up in the store.
+func (c *fsckChecker) checkLinks() {
+	missing := map[ObjectID]bool{}
+	for _, l := range c.links {
+		typ, ok := c.types[l.to]
+		if !ok && !missing[l.to] {
+			o, err := c.repo.store.Get(l.to, true)
+			if err == nil {
+				typ, ok = o.Type, true
+				c.types[l.to] = typ
+			} else if _, notFound := err.(ObjectNotFound); !notFound {
+				c.add(FsckCorrupt, l.to, l.toType, "", "cannot read object: %v", err)
+				missing[l.to] = true
+			}
+		}
+		if !ok {
+			if !missing[l.to] {
+				missing[l.to] = true
+				c.add(FsckMissing, l.to, l.toType, "", "referenced by %s %s", l.fromType, l.from)
+			}
+			continue
+		}
+		if typ != l.toType {
+			c.add(FsckCorrupt, l.from, l.fromType, "", "refers to %s %s as a %s", typ, l.to, l.toType)
+		}
+	}
+}
+
+// findDangling reports the objects that are not referenced by another
+// object, a ref, a reflog or the index.
+func (c *fsckChecker) findDangling() error {
+	roots, err := c.repo.rootObjects()
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[ObjectID]bool, len(c.links))
+	for _, l := range c.links {
+		referenced[l.to] = true
+	}
+	for _, id := range roots {
+		if _, ok := c.types[id]; !ok {
+			if ok, err := c.repo.store.Has(id); err == nil && !ok {
+				c.add(FsckMissing, id, 0, "", "referenced by a ref, a reflog or the index")
+			}
+		}
+		referenced[id] = true
+	}
+
+	for id, typ := range c.types {
+		if !referenced[id] {
+			c.add(FsckDangling, id, typ, "", "")
+		}
+	}
+	return nil
+}
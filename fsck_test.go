@@ -0,0 +1,102 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	findings, err := r.Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same as `git fsck`.
+	want := "dangling blob d76bde4f5d1ed609dc82d8cd7d216d893830f1c9"
+	if len(findings) != 1 || findings[0].String() != want {
+		t.Errorf("expected %q, got %v", want, findings)
+	}
+
+	findings, err = r.Fsck(FsckOptions{NoDangling: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("expected no findings, got %v", findings)
+	}
+}
+
+func TestFsckProblems(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	put := func(typ ObjectType, data string) ObjectID {
+		id, err := r.store.Put(typ, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// A commit on a branch whose tree is missing.
+	missingTree := ObjectIDHex("0000000000000000000000000000000000000001")
+	commit := put(ObjectCommit, "tree "+missingTree.String()+"\nparent "+repo4F+"\n"+
+		"author A <a@example.com> 1 +0000\ncommitter C <c@example.com> 1 +0000\n\nbroken\n")
+	if err := r.refs.SetRef("refs/heads/broken", commit.String()+"\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unsorted tree referring to a commit as a blob, which nothing
+	// refers to.
+	unsorted := put(ObjectTree, "100644 b\x00"+string(ObjectIDHex(repo4F))+"100644 a\x00"+string(ObjectIDHex(repo4F)))
+
+	// A loose object whose content does not match its id.
+	good := put(ObjectBlob, "good\n")
+	bad := put(ObjectBlob, "bad\n")
+	s := r.store.(*FileObjectStore)
+	if err := os.Rename(s.looseObjectPath(bad), s.looseObjectPath(good)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A damaged pack trailer.
+	packs, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.pack"))
+	f, err := os.OpenFile(packs[0], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := f.Stat()
+	if _, err := f.WriteAt([]byte{0}, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	findings, err := r.Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.String())
+	}
+	packName := strings.TrimSuffix(filepath.Base(packs[0]), ".pack")
+	want := []string{
+		"missing tree " + missingTree.String() + ": referenced by commit " + commit.String(),
+		"corrupt pack " + packName + ": pack: checksum mismatch",
+		"corrupt blob " + good.String() + ": hash mismatch, content hashes to " + bad.String(),
+		"corrupt tree " + unsorted.String() + ": entries not sorted at \"a\"",
+		"corrupt tree " + unsorted.String() + ": refers to commit " + repo4F + " as a blob",
+		"dangling tree " + unsorted.String(),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected findings\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
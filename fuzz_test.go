@@ -0,0 +1,243 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fuzz tests check that parsing corrupt objects returns errors instead of
+// panicking. Run one with, for example:
+//
+//	go test -run '^$' -fuzz FuzzParseCommitData
+
+func FuzzParseCommitData(f *testing.F) {
+	f.Add([]byte("tree 095a057d4a651ec412d06b59e32e9b02871592d5\n" +
+		"parent 8b61789a76de9edaa49b2529d3aaa302ba238c0b\n" +
+		"author Test Author <author@example.com> 1112911993 +0200\n" +
+		"committer Test Committer <committer@example.com> 1112911994 +0200\n" +
+		"\ntest commit\n"))
+	f.Add([]byte("tree zz\n\n"))
+	f.Add([]byte("tree\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseCommitData(data)
+	})
+}
+
+func FuzzParseTagData(f *testing.F) {
+	f.Add([]byte("object 8b61789a76de9edaa49b2529d3aaa302ba238c0b\ntype commit\ntag v1\n" +
+		"tagger Test Author <author@example.com> 1112911993 +0200\n\nmessage\n"))
+	f.Add([]byte("object\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseTagData(data)
+	})
+}
+
+func FuzzSignature(f *testing.F) {
+	f.Add([]byte("Test Author <author@example.com> 1112911993 +0200"))
+	f.Add([]byte("<> 1"))
+	f.Add([]byte("a > b <"))
+	f.Fuzz(func(t *testing.T, line []byte) {
+		newSignatureFromCommitline(line)
+	})
+}
+
+func FuzzTreeScanner(f *testing.F) {
+	f.Add([]byte("100644 test.txt\x00" + string(ObjectIDHex("30d74d258442c7c65512eafab474568dd706c430"))))
+	f.Add([]byte("40000 a\x00short"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := NewTreeScanner(nil, bytes.NewReader(data))
+		for s.Scan() {
+			s.TreeEntry()
+		}
+		s.Err()
+	})
+}
+
+func FuzzApplyDelta(f *testing.F) {
+	base := []byte("0123456789abcdef0123456789abcdef")
+	target := []byte("0123456789abcdef--0123456789abcdef")
+	f.Add(base, makeDelta(newDeltaIndex(base), target, 0))
+	f.Add(base, []byte{32, 255, 0x80})
+	f.Add(base, []byte{32, 4, 0x91, 30, 4})
+	f.Fuzz(func(t *testing.T, base, delta []byte) {
+		size, rest, err := readDeltaHeader(delta, len(base))
+		if err != nil {
+			return
+		}
+		result, err := applyDelta(base, rest, size)
+		if err == nil && uint64(len(result)) != size {
+			t.Errorf("expected %d bytes, got %d", size, len(result))
+		}
+	})
+}
+
+// refDeltaPack returns a pack of REF_DELTA entries of delta against the
+// given bases, and an index listing the entries under ids.
+func refDeltaPack(t testing.TB, ids, bases []ObjectID, delta []byte) (pack, idx []byte) {
+	var packData, idxData bytes.Buffer
+	pw := &packHashWriter{w: &packData, hash: ObjectFormatSHA1.New(), crc: crc32.NewIEEE()}
+	header := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, byte(len(bases))}
+	if _, err := pw.Write(header); err != nil {
+		t.Fatal(err)
+	}
+	var entries []*packEntry
+	for i, base := range bases {
+		e := &packEntry{id: ids[i], offset: pw.offset}
+		pw.crc.Reset()
+		h := appendPackObjectHeader(nil, objectRefDelta, uint64(len(delta)))
+		if _, err := pw.Write(append(h, base...)); err != nil {
+			t.Fatal(err)
+		}
+		zw := zlib.NewWriter(pw)
+		if _, err := zw.Write(delta); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		e.crc = pw.crc.Sum32()
+		entries = append(entries, e)
+	}
+	checksum := pw.hash.Sum(nil)
+	packData.Write(checksum)
+	if err := writePackIndex(&idxData, ObjectFormatSHA1, entries, checksum); err != nil {
+		t.Fatal(err)
+	}
+	return packData.Bytes(), idxData.Bytes()
+}
+
+// fuzzPack is a pack-fuzz pack in an otherwise empty FileObjectStore.
+type fuzzPack struct {
+	dir string
+	s   *FileObjectStore
+}
+
+func newFuzzPack(t testing.TB) *fuzzPack {
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "pack"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenFileObjectStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetDeltaBaseCacheLimit(-1)
+	return &fuzzPack{dir, s}
+}
+
+// open writes the pack file, and the index unless it is empty, and returns
+// the pack.
+func (fp *fuzzPack) open(t testing.TB, data, index []byte) *pack {
+	name := filepath.Join(fp.dir, "pack", "pack-fuzz")
+	if err := ioutil.WriteFile(name+".pack", data, 0664); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(name + ".idx")
+	if len(index) > 0 {
+		if err := ioutil.WriteFile(name+".idx", index, 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &pack{store: fp.s, id: "pack-fuzz"}
+}
+
+func (fp *fuzzPack) Close() {
+	fp.s.Close()
+	os.RemoveAll(fp.dir)
+}
+
+func FuzzPackObject(f *testing.F) {
+	var packData bytes.Buffer
+	base := &packEntry{id: "base", typ: ObjectBlob, data: []byte("0123456789abcdef0123456789abcdef")}
+	delta := &packEntry{id: "delta", typ: ObjectBlob, base: base}
+	delta.delta = makeDelta(newDeltaIndex(base.data), []byte("0123456789abcdef++0123456789abcdef"), 0)
+	if _, err := writePackEntries(&packData, ObjectFormatSHA1, []*packEntry{base, delta}); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(packData.Bytes(), []byte(nil), uint64(12))
+	f.Add(packData.Bytes(), []byte(nil), delta.offset)
+	f.Add([]byte("PACK\x00\x00\x00\x02\x00\x00\x00\x01\x60\x00"), []byte(nil), uint64(12))
+
+	// A REF_DELTA against a missing base, and two against each other.
+	x, y := ObjectIDHex(strings.Repeat("1", 40)), ObjectIDHex(strings.Repeat("2", 40))
+	missing, missingIdx := refDeltaPack(f, []ObjectID{x}, []ObjectID{y}, delta.delta)
+	f.Add(missing, missingIdx, uint64(12))
+	cycle, cycleIdx := refDeltaPack(f, []ObjectID{x, y}, []ObjectID{y, x}, delta.delta)
+	f.Add(cycle, cycleIdx, uint64(12))
+
+	fp := newFuzzPack(f)
+	defer fp.Close()
+
+	f.Fuzz(func(t *testing.T, data, index []byte, offset uint64) {
+		p := fp.open(t, data, index)
+		defer p.Close()
+
+		p.objectAtOffset(offset, true)
+		p.objectAtOffset(offset, false)
+		if r, err := p.readerAtOffset(offset); err == nil {
+			ioutil.ReadAll(r)
+			r.Close()
+		}
+	})
+}
+
+func TestPackObjectRefDeltaBase(t *testing.T) {
+	fp := newFuzzPack(t)
+	defer fp.Close()
+
+	delta := makeDelta(newDeltaIndex([]byte("0123456789abcdef")), []byte("0123456789abcdef!"), 0)
+	x, y := ObjectIDHex(strings.Repeat("1", 40)), ObjectIDHex(strings.Repeat("2", 40))
+
+	data, index := refDeltaPack(t, []ObjectID{x}, []ObjectID{y}, delta)
+	for _, index := range [][]byte{index, nil} {
+		p := fp.open(t, data, index)
+		for _, metaOnly := range []bool{true, false} {
+			if _, err := p.objectAtOffset(12, metaOnly); err != ObjectNotFound(y) {
+				t.Errorf("missing base: expected ObjectNotFound, got %v", err)
+			}
+		}
+		p.Close()
+	}
+
+	data, index = refDeltaPack(t, []ObjectID{x, y}, []ObjectID{y, x}, delta)
+	p := fp.open(t, data, index)
+	defer p.Close()
+	if _, err := p.objectAtOffset(12, false); err == nil || !strings.Contains(err.Error(), ErrCorruptPack.Error()) {
+		t.Errorf("delta cycle: expected ErrCorruptPack, got %v", err)
+	}
+}
+
+func TestPackObjectRefDeltaCycleAcrossPacks(t *testing.T) {
+	fp := newFuzzPack(t)
+	defer fp.Close()
+
+	// x is a delta against y in one pack, and y against x in another.
+	delta := makeDelta(newDeltaIndex([]byte("0123456789abcdef")), []byte("0123456789abcdef!"), 0)
+	x, y := ObjectIDHex(strings.Repeat("1", 40)), ObjectIDHex(strings.Repeat("2", 40))
+	for i, ids := range [][]ObjectID{{x, y}, {y, x}} {
+		data, index := refDeltaPack(t, ids[:1], ids[1:], delta)
+		name := filepath.Join(fp.dir, "pack", fmt.Sprintf("pack-cycle%d", i))
+		if err := ioutil.WriteFile(name+".pack", data, 0664); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name+".idx", index, 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fp.s.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fp.s.Get(x, false); err == nil || !strings.Contains(err.Error(), ErrCorruptPack.Error()) {
+		t.Errorf("delta cycle: expected ErrCorruptPack, got %v", err)
+	}
+}
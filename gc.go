@@ -0,0 +1,362 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultPruneExpire is how old unreachable objects must be before GC
+// deletes them, like git's default gc.pruneExpire of two weeks.
+const DefaultPruneExpire = 14 * 24 * time.Hour
+
+// ErrGCUnsupported is returned by GC for repositories that do not keep their
+// objects in a FileObjectStore.
+var ErrGCUnsupported = errors.New("garbage collection needs a FileObjectStore")
+
+// GCOptions configures Repository.GC.
+type GCOptions struct {
+	// PruneExpire is how old unreachable objects must be to be deleted.
+	// Younger ones are kept as loose objects, since they may be about to
+	// be referenced by a ref that is being written. 0 means
+	// DefaultPruneExpire and a negative value keeps all unreachable
+	// objects.
+	PruneExpire time.Duration
+
+	// Pack configures the compression of the new pack.
+	Pack PackWriterOptions
+}
+
+// GC compacts the repository's objects like `git gc`: the objects reachable
+// from the refs, the reflogs and the index are written to a single new pack,
+// and the packs and loose objects that are now redundant are deleted.
+// Unreachable loose objects older than the prune expiry are deleted.
+// Unreachable objects in the old packs are written out as loose objects if
+// the pack is younger than that, and dropped otherwise. As with git gc
+// without --aggressive, objects that are deltas in the old packs stay deltas
+// against the same base rather than being compressed anew.
+//
+// Packs with a .keep file are left alone, as are objects of alternates, which
+// are not copied into the new pack.
+func (repo *Repository) GC(opts GCOptions) error {
+	s, ok := repo.store.(*FileObjectStore)
+	if !ok {
+		return ErrGCUnsupported
+	}
+	expire := opts.PruneExpire
+	if expire == 0 {
+		expire = DefaultPruneExpire
+	}
+	var cutoff time.Time
+	if expire > 0 {
+		cutoff = time.Now().Add(-expire)
+	}
+
+	if err := s.Rescan(); err != nil {
+		return err
+	}
+	roots, err := repo.rootObjects()
+	if err != nil {
+		return err
+	}
+	reachable, err := repo.ReachableFrom(roots...)
+	if err != nil {
+		return err
+	}
+
+	// Packs that are kept, and objects in alternates, need not be repacked.
+	ps := s.acquire()
+	defer ps.release()
+	var kept, old []*pack
+	for _, p := range ps.packs {
+		if isFile(filepath.Join(s.dir, "pack", p.id+".keep")) {
+			kept = append(kept, p)
+		} else {
+			old = append(old, p)
+		}
+	}
+	var ids []ObjectID
+	err = reachable.ForEach(func(id ObjectID, typ ObjectType) error {
+		local, err := s.hasLocal(id, kept)
+		if local {
+			ids = append(ids, id)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if len(ids) > 0 {
+		if name, err = repo.writeGCPack(s, ids, opts.Pack); err != nil {
+			return err
+		}
+	}
+
+	// Loosen the unreachable objects of recent packs, so that they are
+	// pruned later like other unreachable objects.
+	for _, p := range old {
+		if "pack-"+name == p.id {
+			continue
+		}
+		if err := s.loosenUnreachable(p, reachable, cutoff); err != nil {
+			return err
+		}
+	}
+	for _, p := range old {
+		if "pack-"+name == p.id {
+			continue
+		}
+		if err := removePack(filepath.Join(s.dir, "pack", p.id)); err != nil {
+			return err
+		}
+	}
+	if len(old) > 0 {
+		// The multi-pack-index covered the old packs, and so do its
+		// multi-pack-index-<checksum>.bitmap and .rev files.
+		if err := removeMultiPackIndex(filepath.Join(s.dir, "pack")); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Rescan(); err != nil {
+		return err
+	}
+	if err := s.pruneLoose(reachable, cutoff); err != nil {
+		return err
+	}
+	return s.Rescan()
+}
+
+// writeGCPack writes the objects to a new pack in the store and returns its
+// name.
+func (repo *Repository) writeGCPack(s *FileObjectStore, ids []ObjectID, opts PackWriterOptions) (string, error) {
+	packDir := filepath.Join(s.dir, "pack")
+	if err := os.MkdirAll(packDir, 0775); err != nil {
+		return "", err
+	}
+	pack, err := ioutil.TempFile(packDir, "tmp_pack_")
+	if err != nil {
+		return "", err
+	}
+	idx, err := ioutil.TempFile(packDir, "tmp_idx_")
+	if err != nil {
+		pack.Close()
+		os.Remove(pack.Name())
+		return "", err
+	}
+
+	name, err := NewPackWriter(repo, opts).WritePack(ids, pack, idx)
+	for _, f := range []*os.File{pack, idx} {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(pack.Name())
+		os.Remove(idx.Name())
+		return "", err
+	}
+	if err := installPack(packDir, name, pack.Name(), idx.Name()); err != nil {
+		return "", err
+	}
+	return name, s.Rescan()
+}
+
+// hasLocal reports whether the object is a loose object of the store or in
+// one of its packs, rather than in an alternate. Objects in the excluded
+// packs are not, even if they are also loose.
+func (s *FileObjectStore) hasLocal(id ObjectID, exclude []*pack) (bool, error) {
+	for _, p := range exclude {
+		if _, err := p.find(id); err == nil {
+			return false, nil
+		} else if !isPackMiss(err) {
+			return false, err
+		}
+	}
+	if isFile(s.looseObjectPath(id)) {
+		return true, nil
+	}
+	ps := s.acquire()
+	defer ps.release()
+	_, _, err := ps.find(id)
+	if isPackMiss(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// loosenUnreachable writes the objects of p that are not reachable and not
+// already loose to loose objects, if p is younger than cutoff. The loose
+// objects get the modification time of the pack, so that they expire when
+// it would have.
+func (s *FileObjectStore) loosenUnreachable(p *pack, reachable *ReachableSet, cutoff time.Time) error {
+	info, err := os.Stat(filepath.Join(s.dir, "pack", p.id+".pack"))
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().After(cutoff) {
+		return nil
+	}
+
+	return p.forEach(func(id ObjectID) error {
+		if reachable.Contains(id) || isFile(s.looseObjectPath(id)) {
+			return nil
+		}
+		offset, err := p.find(id)
+		if err != nil {
+			return err
+		}
+		o, err := p.objectAtOffset(offset, false)
+		if err != nil {
+			return err
+		}
+		if _, err := s.Put(o.Type, bytes.NewReader(o.Data)); err != nil {
+			return err
+		}
+		return os.Chtimes(s.looseObjectPath(id), info.ModTime(), info.ModTime())
+	})
+}
+
+// removePack removes the files of the pack with the given path, without
+// extension.
+func removePack(base string) error {
+	for _, ext := range []string{".idx", ".bitmap", ".rev", ".pack"} {
+		if err := os.Remove(base + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeMultiPackIndex removes the multi-pack-index of the pack directory,
+// with its bitmaps and reverse indexes.
+func removeMultiPackIndex(dir string) error {
+	paths := []string{filepath.Join(dir, "multi-pack-index")}
+	for _, ext := range []string{".bitmap", ".rev"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "multi-pack-index-*"+ext))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneLoose removes the loose objects that are now packed, and the
+// unreachable ones older than cutoff.
+func (s *FileObjectStore) pruneLoose(reachable *ReachableSet, cutoff time.Time) error {
+	ps := s.acquire()
+	defer ps.release()
+	var remove []ObjectID
+	err := s.forEachLoose(func(id ObjectID) error {
+		if !reachable.Contains(id) {
+			info, err := os.Stat(s.looseObjectPath(id))
+			if err == nil && info.ModTime().Before(cutoff) {
+				remove = append(remove, id)
+			}
+			return nil
+		}
+		// Reachable objects were packed, but make sure before
+		// removing the loose copy.
+		_, _, err := ps.find(id)
+		if err == nil {
+			remove = append(remove, id)
+		} else if !isPackMiss(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range remove {
+		path := s.looseObjectPath(id)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Remove the fan-out directory once it is empty.
+		os.Remove(filepath.Dir(path))
+	}
+	return nil
+}
+
+// rootObjects returns the objects that are in use regardless of what refers
+// to them: the targets of the refs, the objects in the index and those in
+// the reflogs. Reflog entries whose objects no longer exist are skipped.
+func (repo *Repository) rootObjects() ([]ObjectID, error) {
+	roots, err := repo.refTargets()
+	if err != nil {
+		return nil, err
+	}
+	indexed, err := repo.indexObjects()
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, indexed...)
+
+	logged, err := repo.reflogObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range logged {
+		ok, err := repo.store.Has(id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			roots = append(roots, id)
+		}
+	}
+	return roots, nil
+}
+
+// reflogObjects returns the old and new values of the entries of all
+// reflogs in the repository's logs directory.
+func (repo *Repository) reflogObjects() ([]ObjectID, error) {
+	if repo.Path == "" {
+		return nil, nil
+	}
+	var ids []ObjectID
+	err := filepath.Walk(filepath.Join(repo.Path, "logs"), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// Each entry is "<old> <new> <committer>\t<message>".
+		scan := bufio.NewScanner(f)
+		for scan.Scan() {
+			fields := strings.SplitN(scan.Text(), " ", 3)
+			if len(fields) < 3 {
+				continue
+			}
+			for _, hex := range fields[:2] {
+				if IsObjectIDHex(hex) && strings.Trim(hex, "0") != "" {
+					ids = append(ids, ObjectIDHex(hex))
+				}
+			}
+		}
+		return scan.Err()
+	})
+	return ids, err
+}
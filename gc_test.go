@@ -0,0 +1,277 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGC(t *testing.T) {
+	dir := copyTestRepo(t, "repo")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := r.ObjectStore().(*FileObjectStore)
+
+	put := func(typ ObjectType, data string, age time.Duration) ObjectID {
+		id, err := s.Put(typ, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		when := time.Now().Add(-age)
+		if err := os.Chtimes(s.looseObjectPath(id), when, when); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	const month = 30 * 24 * time.Hour
+	commit := func(msg, parent string) string {
+		return "tree 095a057d4a651ec412d06b59e32e9b02871592d5\nparent " + parent + "\n" +
+			"author A <a@example.com> 1 +0000\ncommitter C <c@example.com> 1 +0000\n\n" + msg + "\n"
+	}
+
+	// A branch with an old loose commit, and a commit only in a reflog.
+	branch := put(ObjectCommit, commit("branch", "40b7c29973f5ff265a241f29c8154fa05594454f"), month)
+	if err := r.refs.SetRef("refs/heads/branch", branch.String()+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	logged := put(ObjectCommit, commit("logged", branch.String()), month)
+	os.MkdirAll(filepath.Join(dir, "logs", "refs", "heads"), 0775)
+	reflog := strings.Repeat("0", 40) + " " + logged.String() + " C <c@example.com> 1 +0000\tcommit\n" +
+		logged.String() + " " + branch.String() + " C <c@example.com> 1 +0000\treset\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "logs", "refs", "heads", "branch"), []byte(reflog), 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unreachable objects, one of them old enough to be pruned.
+	expired := put(ObjectBlob, "expired", month)
+	recent := put(ObjectBlob, "recent", time.Hour)
+
+	var before []ObjectID
+	if err := s.ForEach(func(id ObjectID) error {
+		before = append(before, id)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.GC(GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.pack"))
+	if len(packs) != 1 {
+		t.Errorf("expected a single pack, got %v", packs)
+	}
+	var loose []ObjectID
+	if err := s.forEachLoose(func(id ObjectID) error {
+		loose = append(loose, id)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The unreachable "test unpacked" blob of the test repo is recent too.
+	unpacked := ObjectIDHex("d76bde4f5d1ed609dc82d8cd7d216d893830f1c9")
+	if len(loose) != 2 || !(loose[0] == recent && loose[1] == unpacked || loose[0] == unpacked && loose[1] == recent) {
+		t.Errorf("expected only %s and %s to stay loose, got %v", recent, unpacked, loose)
+	}
+
+	for _, id := range before {
+		ok, err := s.Has(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := id != expired; ok != want {
+			t.Errorf("%s: expected Has to be %v after GC", id, want)
+		}
+	}
+
+	findings, err := r.Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.Problem != FsckDangling {
+			t.Errorf("unexpected %v", f)
+		}
+	}
+}
+
+func TestGCLoosensUnreachable(t *testing.T) {
+	dir := copyTestRepo(t, "repo")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Without the index, the 1000 numbered blobs in the pack are
+	// unreachable.
+	if err := os.Remove(filepath.Join(dir, "index")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.GC(GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s := r.ObjectStore().(*FileObjectStore)
+	blob := ObjectIDHex("56a6051ca2b02b04ef92d5150c9ef600403cb1de") // "1"
+	if !isFile(s.looseObjectPath(blob)) {
+		t.Errorf("expected %s to be loosened", blob)
+	}
+
+	// Once they expire, they are pruned.
+	if err := r.GC(GCOptions{PruneExpire: -time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if !isFile(s.looseObjectPath(blob)) {
+		t.Errorf("expected %s to be kept", blob)
+	}
+	if err := r.GC(GCOptions{PruneExpire: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Has(blob); ok || err != nil {
+		t.Errorf("expected %s to be pruned, got %v, %v", blob, ok, err)
+	}
+	if _, err := r.GetCommit("40b7c29973f5ff265a241f29c8154fa05594454f"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGCKeptPack(t *testing.T) {
+	dir := copyTestRepo(t, "repo")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := r.ObjectStore().(*FileObjectStore)
+
+	kept, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.pack"))
+	if len(kept) != 1 {
+		t.Fatalf("expected a single pack, got %v", kept)
+	}
+	if err := ioutil.WriteFile(strings.TrimSuffix(kept[0], ".pack")+".keep", nil, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit of the kept pack that is also loose, and a new commit.
+	head := ObjectIDHex("40b7c29973f5ff265a241f29c8154fa05594454f")
+	o, err := r.Object(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(o.Type, bytes.NewReader(o.Data)); err != nil {
+		t.Fatal(err)
+	}
+	branch, err := s.Put(ObjectCommit, strings.NewReader("tree 095a057d4a651ec412d06b59e32e9b02871592d5\nparent "+head.String()+"\n"+
+		"author A <a@example.com> 1 +0000\ncommitter C <c@example.com> 1 +0000\n\nbranch\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.refs.SetRef("refs/heads/branch", branch.String()+"\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.GC(GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.pack"))
+	if len(packs) != 2 {
+		t.Fatalf("expected the kept pack and a new one, got %v", packs)
+	}
+	for _, p := range currentPacks(t, s).packs {
+		if filepath.Join(dir, "objects", "pack", p.id+".pack") == kept[0] {
+			continue
+		}
+		if _, err := p.find(branch); err != nil {
+			t.Errorf("expected %s in the new pack: %v", branch, err)
+		}
+		if _, err := p.find(head); err == nil {
+			t.Errorf("expected %s, which is in the kept pack, not to be in the new pack", head)
+		}
+	}
+}
+
+func TestGCReusesDeltas(t *testing.T) {
+	dir := copyTestRepo(t, "repo")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := r.ObjectStore().(*FileObjectStore)
+
+	// y is stored as a delta against x, and z sorts between them by size.
+	var x, z strings.Builder
+	for i := 0; i < 150; i++ {
+		fmt.Fprintf(&x, "line %03d of x\n", i)
+		fmt.Fprintf(&z, "%03d zz zz zz z\n", i*7919%1000)
+	}
+	y := strings.Replace(x.String(), "line 025", "LINE", 1)
+	ids := []ObjectID{
+		storeTestObject(t, r, ObjectBlob, x.String()),
+		storeTestObject(t, r, ObjectBlob, y),
+		storeTestObject(t, r, ObjectBlob, z.String()[:len(y)+2]),
+	}
+	writeTestPack(t, r, filepath.Join(dir, "objects"), ids...)
+	for i, id := range ids {
+		if err := r.refs.SetRef(fmt.Sprintf("refs/tags/blob%d", i), id.String()+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A window of one object is too small to find the delta again.
+	if err := r.GC(GCOptions{Pack: PackWriterOptions{Window: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	ps := currentPacks(t, s)
+	p, offset, err := ps.find(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := p.deltaAt(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil || d.base != ids[0] {
+		t.Errorf("expected %s to stay a delta against %s, got %+v", ids[1], ids[0], d)
+	}
+}
+
+func TestGCRemovesMultiPackIndex(t *testing.T) {
+	dir := copyTestRepo(t, "repo5")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The bitmap and reverse index of the multi-pack-index go with it.
+	packDir := filepath.Join(dir, "objects", "pack")
+	checksum := strings.Repeat("ab", 20)
+	for _, ext := range []string{".bitmap", ".rev"} {
+		if err := ioutil.WriteFile(filepath.Join(packDir, "multi-pack-index-"+checksum+ext), nil, 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.GC(GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	left, _ := filepath.Glob(filepath.Join(packDir, "multi-pack-index*"))
+	if len(left) != 0 {
+		t.Errorf("expected the multi-pack-index files to be removed, got %v", left)
+	}
+}
@@ -0,0 +1,143 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var ErrCorruptIndex = errors.New("corrupt index")
+
+// indexObjects returns the objects the repository's index file refers to:
+// the blobs of its entries and the trees of its cache-tree extension.
+// Submodule commits are left out. A missing index is not an error.
+func (repo *Repository) indexObjects() ([]ObjectID, error) {
+	if repo.Path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(repo.Path, "index"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids, err := parseIndexObjects(data, repo.format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(repo.Path, "index"), err)
+	}
+	return ids, nil
+}
+
+// parseIndexObjects parses a version 2, 3 or 4 index file, as documented in
+// git's Documentation/technical/index-format.txt. Object ids, and the
+// checksum, are of the given format.
+func parseIndexObjects(data []byte, format ObjectFormat) ([]ObjectID, error) {
+	idLen := format.Size()
+	if len(data) < 12+idLen || !bytes.Equal(data[:4], []byte("DIRC")) {
+		return nil, fmt.Errorf("%v: wrong signature", ErrCorruptIndex)
+	}
+	h := format.New()
+	h.Write(data[:len(data)-idLen])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-idLen:]) {
+		return nil, fmt.Errorf("%v: checksum mismatch", ErrCorruptIndex)
+	}
+	version := binary.BigEndian.Uint32(data[4:])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:])
+	end := len(data) - idLen
+
+	var ids []ObjectID
+	pos := 12
+	for i := uint32(0); i < count; i++ {
+		// ctime, mtime, dev, ino, mode, uid, gid and size, then the id
+		// and flags.
+		if pos+40+idLen+2 > end {
+			return nil, fmt.Errorf("%v: truncated entry", ErrCorruptIndex)
+		}
+		mode := binary.BigEndian.Uint32(data[pos+24:])
+		id := ObjectID(data[pos+40 : pos+40+idLen])
+		flags := binary.BigEndian.Uint16(data[pos+40+idLen:])
+		if EntryMode(mode) != ModeCommit {
+			ids = append(ids, id)
+		}
+
+		nameStart := pos + 40 + idLen + 2
+		if flags&0x4000 != 0 && version >= 3 {
+			nameStart += 2
+		}
+		if nameStart > end {
+			return nil, fmt.Errorf("%v: truncated entry", ErrCorruptIndex)
+		}
+		if version == 4 {
+			// The name is stored as the number of bytes to drop from
+			// the previous name, followed by a NUL terminated suffix.
+			r := bytes.NewReader(data[nameStart:end])
+			if _, err := readOffset(r); err != nil {
+				return nil, fmt.Errorf("%v: truncated entry", ErrCorruptIndex)
+			}
+			nameStart = end - r.Len()
+		}
+		nul := bytes.IndexByte(data[nameStart:end], 0)
+		if nul < 0 {
+			return nil, fmt.Errorf("%v: unterminated entry name", ErrCorruptIndex)
+		}
+		if version < 4 {
+			// Entries are padded with 1 to 8 NULs to a multiple of 8
+			// bytes.
+			pos += (nameStart - pos + nul + 8) &^ 7
+		} else {
+			pos = nameStart + nul + 1
+		}
+	}
+
+	for pos+8 <= end {
+		sig := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size < 0 || pos+size > end {
+			return nil, fmt.Errorf("%v: truncated extension %q", ErrCorruptIndex, sig)
+		}
+		if sig == "TREE" {
+			trees, err := parseCacheTree(data[pos:pos+size], idLen)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, trees...)
+		}
+		pos += size
+	}
+	return ids, nil
+}
+
+// parseCacheTree returns the tree ids of a cache-tree extension. Each entry
+// is a NUL terminated path, the ASCII entry and subtree counts, and the tree
+// id of idLen bytes unless the entry is invalidated with an entry count of
+// -1.
+func parseCacheTree(data []byte, idLen int) ([]ObjectID, error) {
+	var ids []ObjectID
+	for len(data) > 0 {
+		nul := bytes.IndexByte(data, 0)
+		eol := bytes.IndexByte(data, '\n')
+		if nul < 0 || eol < nul {
+			return nil, fmt.Errorf("%v: bad cache-tree entry", ErrCorruptIndex)
+		}
+		counts := data[nul+1 : eol]
+		data = data[eol+1:]
+		if bytes.HasPrefix(counts, []byte("-1 ")) {
+			continue
+		}
+		if len(data) < idLen {
+			return nil, fmt.Errorf("%v: truncated cache-tree entry", ErrCorruptIndex)
+		}
+		ids = append(ids, ObjectID(data[:idLen]))
+		data = data[idLen:]
+	}
+	return ids, nil
+}
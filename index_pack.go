@@ -0,0 +1,501 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var ErrCorruptPack = errors.New("corrupt pack")
+
+// indexPackEntry is an object of a pack being indexed.
+type indexPackEntry struct {
+	packEntry
+
+	packType   ObjectType // type in the pack, possibly a delta
+	size       uint64     // inflated size of the data in the pack
+	dataOffset int64      // offset of the compressed data
+	baseOffset uint64     // offset of the base of an OFS_DELTA
+	baseId     ObjectID   // id of the base of a REF_DELTA
+}
+
+// packStreamReader reads a pack stream, counting, hashing and copying to w
+// the bytes that are consumed. It is an io.ByteReader, so zlib never reads
+// past the end of an entry's compressed data.
+type packStreamReader struct {
+	format ObjectFormat
+	br     byteReader
+	w      io.Writer
+	err    error // first error writing to w
+	offset int64
+	hash   hash.Hash
+	crc    hash.Hash32
+	b      [1]byte
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func (r *packStreamReader) Read(p []byte) (int, error) {
+	n, err := r.br.Read(p)
+	r.consume(p[:n])
+	return n, err
+}
+
+func (r *packStreamReader) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == nil {
+		r.b[0] = b
+		r.consume(r.b[:])
+	}
+	return b, err
+}
+
+func (r *packStreamReader) consume(p []byte) {
+	if _, err := r.w.Write(p); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.hash.Write(p)
+	r.crc.Write(p)
+	r.offset += int64(len(p))
+}
+
+// IndexPack reads a pack stream, such as one received from a fetch or read
+// from a bundle, and stores it with its index in the pack directory of the
+// objects directory dir. The trailing checksum of the stream is verified and
+// every delta is resolved. Reading stops at the checksum. If r is an
+// io.ByteReader, such as a *bufio.Reader, nothing past it is consumed, so
+// what follows the pack in a stream can still be read from r.
+//
+// A thin pack, with deltas against bases that are not in the pack, is
+// completed with those bases from the repository, like
+// `git index-pack --fix-thin`. It returns the hex checksum naming the pack.
+// If dir is the repository's own objects directory, the pack can be read
+// right away.
+func (repo *Repository) IndexPack(r io.Reader, dir string) (string, error) {
+	packDir := filepath.Join(dir, "pack")
+	if err := os.MkdirAll(packDir, 0775); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(packDir, "tmp_pack_")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	entries, err := readPackStream(r, f, repo.format)
+	if err != nil {
+		return "", err
+	}
+	ix := &packIndexer{
+		repo:     repo,
+		f:        f,
+		entries:  entries,
+		byOffset: make(map[uint64]*indexPackEntry, len(entries)),
+		byId:     make(map[ObjectID]*indexPackEntry, len(entries)),
+		bases:    newLRUCache(DefaultDeltaBaseCacheLimit),
+		external: map[ObjectID]*Object{},
+	}
+	if err := ix.resolveDeltas(); err != nil {
+		return "", err
+	}
+	checksum, err := ix.fixThin()
+	if err != nil {
+		return "", err
+	}
+
+	packEntries := make([]*packEntry, len(ix.entries))
+	for i, e := range ix.entries {
+		packEntries[i] = &e.packEntry
+	}
+	idx, err := ioutil.TempFile(packDir, "tmp_idx_")
+	if err != nil {
+		return "", err
+	}
+	err = writePackIndex(idx, repo.format, packEntries, checksum)
+	if closeErr := idx.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(idx.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(idx.Name())
+		return "", err
+	}
+
+	name := hex.EncodeToString(checksum)
+	if err := installPack(packDir, name, f.Name(), idx.Name()); err != nil {
+		return "", err
+	}
+	f = nil
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := repo.store.(*FileObjectStore); ok && s.Dir() == abs {
+		if err := s.Rescan(); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// installPack moves a pack and its index from temporary files into place as
+// pack-<name>.pack and pack-<name>.idx in packDir. The index is moved first,
+// so that a pack is never seen without its index. The temporary files are
+// removed on failure.
+func installPack(packDir, name, packTmp, idxTmp string) error {
+	base := filepath.Join(packDir, "pack-"+name)
+	os.Chmod(idxTmp, 0444)
+	os.Chmod(packTmp, 0444)
+	if err := os.Rename(idxTmp, base+".idx"); err != nil {
+		os.Remove(idxTmp)
+		os.Remove(packTmp)
+		return err
+	}
+	if err := os.Rename(packTmp, base+".pack"); err != nil {
+		os.Remove(packTmp)
+		return err
+	}
+	return nil
+}
+
+// readPackStream reads the entries of a pack stream, verifying its checksum,
+// and copies the pack to w. The ids of entries that are not deltas are
+// computed as they are read, with the hash function of the given format.
+// Reading stops at the checksum, so a network stream is not waited on after
+// the pack. If r is an io.ByteReader, such as a *bufio.Reader, nothing past
+// the checksum is read from it, and it can be read on.
+func readPackStream(r io.Reader, w io.Writer, format ObjectFormat) ([]*indexPackEntry, error) {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	bw := bufio.NewWriter(w)
+	pr := &packStreamReader{
+		format: format,
+		br:     br,
+		w:      bw,
+		hash:   format.New(),
+		crc:    crc32.NewIEEE(),
+	}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(pr, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], []byte("PACK")) {
+		return nil, fmt.Errorf("%v: wrong signature", ErrCorruptPack)
+	}
+	if v := binary.BigEndian.Uint32(header[4:]); v != 2 && v != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", v)
+	}
+	count := binary.BigEndian.Uint32(header[8:])
+
+	var entries []*indexPackEntry
+	for i := uint32(0); i < count; i++ {
+		e, err := readPackStreamEntry(pr)
+		if err != nil {
+			return nil, fmt.Errorf("pack entry %d: %v", i, err)
+		}
+		entries = append(entries, e)
+	}
+
+	sum := pr.hash.Sum(nil)
+	trailer := make([]byte, format.Size())
+	if _, err := io.ReadFull(pr.br, trailer); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, trailer) {
+		return nil, fmt.Errorf("%v: checksum mismatch", ErrCorruptPack)
+	}
+	if pr.err != nil {
+		return nil, pr.err
+	}
+	bw.Write(trailer)
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func readPackStreamEntry(pr *packStreamReader) (*indexPackEntry, error) {
+	e := &indexPackEntry{}
+	e.offset = uint64(pr.offset)
+	pr.crc.Reset()
+
+	var err error
+	e.packType, e.size, err = readPackObjectHeader(pr)
+	if err != nil {
+		return nil, err
+	}
+	switch e.packType {
+	case ObjectCommit, ObjectTree, ObjectBlob, ObjectTag:
+		e.typ = e.packType
+	case objectOfsDelta:
+		rel, err := readOffset(pr)
+		if err != nil {
+			return nil, err
+		}
+		if rel == 0 || rel > e.offset {
+			return nil, fmt.Errorf("%v: delta base offset out of range", ErrCorruptPack)
+		}
+		e.baseOffset = e.offset - rel
+	case objectRefDelta:
+		id := make([]byte, pr.format.Size())
+		if _, err := io.ReadFull(pr, id); err != nil {
+			return nil, err
+		}
+		e.baseId = ObjectID(id)
+	default:
+		return nil, fmt.Errorf("%v: unknown object type %d", ErrCorruptPack, e.packType)
+	}
+	e.dataOffset = pr.offset
+
+	zr, err := zlib.NewReader(pr)
+	if err != nil {
+		return nil, err
+	}
+	w := ioutil.Discard
+	var objectHash hash.Hash
+	if e.typ != 0 {
+		objectHash = pr.format.New()
+		fmt.Fprintf(objectHash, "%s %d\x00", e.typ, e.size)
+		w = objectHash
+	}
+	n, err := io.Copy(w, zr)
+	if err != nil {
+		return nil, err
+	}
+	if err := zr.Close(); err != nil {
+		return nil, err
+	}
+	if uint64(n) != e.size {
+		return nil, fmt.Errorf("%v: expected %d bytes of data, got %d", ErrCorruptPack, e.size, n)
+	}
+	if objectHash != nil {
+		e.id = ObjectID(objectHash.Sum(nil))
+	}
+	e.crc = pr.crc.Sum32()
+	return e, nil
+}
+
+// packIndexer resolves the deltas of a pack written to f.
+type packIndexer struct {
+	repo    *Repository
+	f       *os.File
+	entries []*indexPackEntry
+
+	byOffset map[uint64]*indexPackEntry
+	byId     map[ObjectID]*indexPackEntry
+	bases    *lruCache // offset -> *Object
+
+	// external holds the bases of a thin pack that come from the
+	// repository.
+	external map[ObjectID]*Object
+}
+
+// resolveDeltas computes the ids of deltified entries. Deltas are indexed
+// by their base, and resolved when the base is, so that a REF_DELTA may
+// refer to an entry later in the pack. Bases that are not in the pack are
+// then read from the repository.
+func (ix *packIndexer) resolveDeltas() error {
+	byBaseOffset := map[uint64][]*indexPackEntry{}
+	byBaseId := map[ObjectID][]*indexPackEntry{}
+	var resolved []*indexPackEntry // entries whose deltas are to be resolved
+	pending := 0
+	for _, e := range ix.entries {
+		ix.byOffset[e.offset] = e
+		switch {
+		case e.id != "":
+			ix.byId[e.id] = e
+			resolved = append(resolved, e)
+		case e.packType == objectOfsDelta:
+			byBaseOffset[e.baseOffset] = append(byBaseOffset[e.baseOffset], e)
+			pending++
+		default:
+			byBaseId[e.baseId] = append(byBaseId[e.baseId], e)
+			pending++
+		}
+	}
+	for offset := range byBaseOffset {
+		if ix.byOffset[offset] == nil {
+			return fmt.Errorf("%v: no delta base at offset %d", ErrCorruptPack, offset)
+		}
+	}
+
+	resolve := func(deltas []*indexPackEntry) error {
+		for _, e := range deltas {
+			o, err := ix.object(e)
+			if err != nil {
+				return err
+			}
+			e.typ = o.Type
+			e.id, err = StoreObjectHash(ix.repo.format, o.Type, ioutil.Discard, bytes.NewReader(o.Data))
+			if err != nil {
+				return err
+			}
+			ix.byId[e.id] = e
+			resolved = append(resolved, e)
+			pending--
+		}
+		return nil
+	}
+	resolveAll := func() error {
+		for len(resolved) > 0 {
+			base := resolved[len(resolved)-1]
+			resolved = resolved[:len(resolved)-1]
+			deltas := append(byBaseOffset[base.offset], byBaseId[base.id]...)
+			delete(byBaseOffset, base.offset)
+			delete(byBaseId, base.id)
+			if err := resolve(deltas); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := resolveAll(); err != nil {
+		return err
+	}
+
+	if pending > 0 {
+		// A thin pack: take the missing REF_DELTA bases from the
+		// repository.
+		for id, deltas := range byBaseId {
+			o, err := ix.repo.object(id, false)
+			if err != nil {
+				if _, ok := err.(ObjectNotFound); ok {
+					continue
+				}
+				return err
+			}
+			ix.external[id] = o
+			delete(byBaseId, id)
+			if err := resolve(deltas); err != nil {
+				return err
+			}
+		}
+		if err := resolveAll(); err != nil {
+			return err
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%v: %d deltas with missing bases", ErrCorruptPack, pending)
+	}
+	return nil
+}
+
+// object returns the type and data of an entry, applying deltas.
+func (ix *packIndexer) object(e *indexPackEntry) (*Object, error) {
+	if o, ok := ix.bases.get(e.offset); ok {
+		return o.(*Object), nil
+	}
+
+	data, err := readAndDecompress(io.NewSectionReader(ix.f, e.dataOffset, 1<<62), e.size)
+	if err != nil {
+		return nil, err
+	}
+
+	var o *Object
+	switch e.packType {
+	case objectOfsDelta, objectRefDelta:
+		var base *Object
+		if e.packType == objectOfsDelta {
+			base, err = ix.object(ix.byOffset[e.baseOffset])
+		} else if b := ix.byId[e.baseId]; b != nil {
+			base, err = ix.object(b)
+		} else {
+			base = ix.external[e.baseId]
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resultSize, delta, err := readDeltaHeader(data, len(base.Data))
+		if err != nil {
+			return nil, err
+		}
+		result, err := applyDelta(base.Data, delta, resultSize)
+		if err != nil {
+			return nil, err
+		}
+		o = &Object{base.Type, resultSize, result}
+	default:
+		o = &Object{e.packType, e.size, data}
+	}
+
+	ix.bases.add(e.offset, o, int64(len(o.Data)))
+	return o, nil
+}
+
+// fixThin appends the bases taken from the repository to the pack, so that
+// it is self-contained, and returns the checksum of the final pack.
+func (ix *packIndexer) fixThin() ([]byte, error) {
+	info, err := ix.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := ix.repo.format.Size()
+	if len(ix.external) == 0 {
+		return readBytesAt(ix.f, info.Size()-int64(size), size), nil
+	}
+
+	// Replace the trailer with the bases and a new object count.
+	end := info.Size() - int64(size)
+	if err := ix.f.Truncate(end); err != nil {
+		return nil, err
+	}
+	var added []*packEntry
+	for id, o := range ix.external {
+		added = append(added, &packEntry{id: id, typ: o.Type, data: o.Data})
+	}
+	if _, err := ix.f.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	pw := &packHashWriter{w: ix.f, hash: ix.repo.format.New(), crc: crc32.NewIEEE(), offset: uint64(end)}
+	if err := writePackObjects(pw, added); err != nil {
+		return nil, err
+	}
+	for _, e := range added {
+		ix.entries = append(ix.entries, &indexPackEntry{packEntry: *e})
+	}
+
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], uint32(len(ix.entries)))
+	if _, err := ix.f.WriteAt(count[:], 8); err != nil {
+		return nil, err
+	}
+
+	if _, err := ix.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h := ix.repo.format.New()
+	if _, err := io.Copy(h, ix.f); err != nil {
+		return nil, err
+	}
+	checksum := h.Sum(nil)
+	if _, err := ix.f.Write(checksum); err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
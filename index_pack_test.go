@@ -0,0 +1,244 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexPack(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	var ids []ObjectID
+	err := r.store.ForEach(func(id ObjectID) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack, idx bytes.Buffer
+	checksum, err := NewPackWriter(r, PackWriterOptions{}).WritePack(ids, &pack, &idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	corrupt := append([]byte(nil), pack.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := r.IndexPack(bytes.NewReader(corrupt), dir); err == nil || !strings.Contains(err.Error(), ErrCorruptPack.Error()) {
+		t.Errorf("expected a corrupt pack error, got %v", err)
+	}
+
+	name, err := r.IndexPack(bytes.NewReader(pack.Bytes()), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != checksum {
+		t.Errorf("expected pack %s, got %s", checksum, name)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "pack", "pack-"+name+".idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, idx.Bytes()) {
+		t.Error("index differs from the one written with the pack")
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "pack", "tmp_*"))
+	if len(files) != 0 {
+		t.Errorf("temporary files left behind: %v", files)
+	}
+}
+
+func TestIndexPackStream(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	var ids []ObjectID
+	err := r.store.ForEach(func(id ObjectID) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack bytes.Buffer
+	checksum, err := NewPackWriter(r, PackWriterOptions{}).WritePack(ids, &pack, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// What follows the pack is left in the reader.
+	br := bufio.NewReader(io.MultiReader(bytes.NewReader(pack.Bytes()), strings.NewReader("rest")))
+	name, err := r.IndexPack(br, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != checksum {
+		t.Errorf("expected pack %s, got %s", checksum, name)
+	}
+	rest, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "rest" {
+		t.Errorf("expected %q after the pack, got %q", "rest", rest)
+	}
+
+	// The sender keeping the stream open doesn't block.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write(pack.Bytes())
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.IndexPack(pr, dir)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("IndexPack blocked after the pack checksum")
+	}
+}
+
+func TestIndexPackRefDeltaChain(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	// Each blob is a REF_DELTA against the next one in the pack, the
+	// last one is stored whole.
+	const n = 100
+	contents := make([]string, n+1)
+	ids := make([]ObjectID, n+1)
+	for i := range contents {
+		contents[i] = "x" + strings.Repeat("!", i)
+		id, err := StoreObjectSHA(ObjectBlob, ioutil.Discard, strings.NewReader(contents[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	var pack bytes.Buffer
+	pack.Write([]byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, n + 1})
+	for i := n; i > 0; i-- {
+		delta := []byte{byte(i), byte(i + 1)}
+		delta = appendDeltaCopy(delta, 0, i)
+		delta = appendDeltaInsert(delta, []byte("!"))
+		pack.Write(appendPackObjectHeader(nil, objectRefDelta, uint64(len(delta))))
+		pack.WriteString(string(ids[i-1]))
+		zw := zlib.NewWriter(&pack)
+		zw.Write(delta)
+		zw.Close()
+	}
+	pack.Write(appendPackObjectHeader(nil, ObjectBlob, uint64(len(contents[0]))))
+	zw := zlib.NewWriter(&pack)
+	zw.Write([]byte(contents[0]))
+	zw.Close()
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+
+	dir := copyTestRepo(t, "repo")
+	defer os.RemoveAll(dir)
+	if _, err := r.IndexPack(bytes.NewReader(pack.Bytes()), filepath.Join(dir, "objects")); err != nil {
+		t.Fatal(err)
+	}
+	r2, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	for i, id := range ids {
+		o, err := r2.Object(id)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(o.Data) != contents[i] {
+			t.Errorf("%d: unexpected content %q", i, o.Data)
+		}
+	}
+}
+
+func TestIndexThinPack(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// A pack with a single REF_DELTA against a blob of the repository.
+	base := ObjectIDHex("8c7e5a667f1b771847fe88c01c3de34413a1b220") // "A"
+	content := strings.Repeat("A", 100)
+	delta := []byte{1, 100}
+	delta = appendDeltaCopy(delta, 0, 1)
+	delta = appendDeltaInsert(delta, []byte(content[1:]))
+
+	var pack bytes.Buffer
+	pack.Write([]byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 1})
+	pack.Write(appendPackObjectHeader(nil, objectRefDelta, uint64(len(delta))))
+	pack.WriteString(string(base))
+	zw := zlib.NewWriter(&pack)
+	zw.Write(delta)
+	zw.Close()
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+
+	if _, err := r.IndexPack(bytes.NewReader(pack.Bytes()), filepath.Join(dir, "objects")); err != nil {
+		t.Fatal(err)
+	}
+
+	r2, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	id, err := StoreObjectSHA(ObjectBlob, ioutil.Discard, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := r2.Object(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(o.Data) != content {
+		t.Errorf("unexpected content %q", o.Data)
+	}
+
+	// The base was added to the pack.
+	n := 0
+	for _, p := range currentPacks(t, r2.store.(*FileObjectStore)).packs {
+		p.forEach(func(id ObjectID) error {
+			if id == base {
+				n++
+			}
+			return nil
+		})
+	}
+	if n != 2 {
+		t.Errorf("expected the base in 2 packs, got %d", n)
+	}
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a size-bounded least-recently-used cache. Every entry carries a
+// size, and the least recently used entries are evicted once the total size
+// exceeds maxSize. It is safe for concurrent use.
+type lruCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List
+	items   map[interface{}]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+type lruEntry struct {
+	key   interface{}
+	value interface{}
+	size  int64
+}
+
+// newLRUCache returns a cache holding at most maxSize worth of entries. A
+// cache with maxSize <= 0 holds nothing.
+func newLRUCache(maxSize int64) *lruCache {
+	return &lruCache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[interface{}]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// add inserts or replaces the entry for key. Values larger than the whole
+// cache are not stored.
+func (c *lruCache) add(key, value interface{}, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+	if size > c.maxSize {
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key, value, size})
+	c.size += size
+	for c.size > c.maxSize {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *lruCache) setMaxSize(maxSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = maxSize
+	for c.size > c.maxSize && c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *lruCache) removeElement(e *list.Element) {
+	entry := c.ll.Remove(e).(*lruEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+func (c *lruCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.ll.Len(),
+		Size:      c.size,
+	}
+}
+
+// CacheStats reports the activity of one of a Repository's caches.
+type CacheStats struct {
+	Hits      uint64 // lookups that found their entry
+	Misses    uint64 // lookups that did not
+	Evictions uint64 // entries dropped to stay within the size limit
+	Entries   int    // entries currently cached
+	Size      int64  // current size, in the unit of the cache's limit
+}
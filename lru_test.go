@@ -0,0 +1,85 @@
+package git
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(10)
+	c.add("a", 1, 4)
+	c.add("b", 2, 4)
+	if _, ok := c.get("a"); !ok { // a is now the most recently used
+		t.Fatal("expected a to be cached")
+	}
+	c.add("c", 3, 4)
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("expected a to be cached, got %v", v)
+	}
+	c.add("d", 4, 11)
+	if _, ok := c.get("d"); ok {
+		t.Error("expected oversized entry not to be cached")
+	}
+	c.setMaxSize(4)
+	if _, ok := c.get("c"); ok {
+		t.Error("expected c to be evicted after shrinking")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("expected a to survive shrinking")
+	}
+	if c.size != 4 {
+		t.Errorf("expected size 4, got %d", c.size)
+	}
+}
+
+func TestDeltaBaseCache(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+	store := r.ObjectStore().(*FileObjectStore)
+
+	// 8b61789 is stored as a delta against 40b7c29 in the test pack.
+	for i := 0; i < 2; i++ {
+		c, err := r.Object(ObjectIDHex("8b61789a76de9edaa49b2529d3aaa302ba238c0b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Type != ObjectCommit {
+			t.Errorf("wrong type %v", c.Type)
+		}
+	}
+	if store.deltaBaseCache.ll.Len() != 1 {
+		t.Errorf("expected the delta base to be cached, have %d entries", store.deltaBaseCache.ll.Len())
+	}
+
+	store.SetDeltaBaseCacheLimit(0)
+	if store.deltaBaseCache.ll.Len() != 0 {
+		t.Error("expected disabling the cache to empty it")
+	}
+}
+
+func TestRepositoryCacheStats(t *testing.T) {
+	r, err := OpenRepositoryWithOptions("testdata/repo", RepositoryOptions{CommitCacheSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, id := range []string{
+		"40b7c29973f5ff265a241f29c8154fa05594454f",
+		"40b7c29973f5ff265a241f29c8154fa05594454f",
+		"8b61789a76de9edaa49b2529d3aaa302ba238c0b",
+		"40b7c29973f5ff265a241f29c8154fa05594454f",
+	} {
+		if _, err := r.GetCommit(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := r.CacheStats().Commits
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 2 || stats.Entries != 1 {
+		t.Errorf("unexpected commit cache stats %+v", stats)
+	}
+	if r.CacheStats().DeltaBases.Entries != 1 {
+		t.Errorf("expected one cached delta base, got %+v", r.CacheStats().DeltaBases)
+	}
+}
@@ -0,0 +1,335 @@
+package git
+
+import "container/heap"
+
+const (
+	paintParent1 = 1 << iota
+	paintParent2
+	paintStale
+	paintResult
+)
+
+// MergeBase returns the best common ancestors of a and any of b, like
+// git merge-base --all: the common ancestors that are not ancestors of
+// another one. With several commits in b, it is as if a were merged with a
+// merge of all of them. Commits without common ancestors have none.
+func (repo *Repository) MergeBase(a ObjectID, b ...ObjectID) ([]ObjectID, error) {
+	one, err := repo.peelTo(a, ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	twos := make([]ObjectID, len(b))
+	for i, id := range b {
+		if twos[i], err = repo.peelTo(id, ObjectCommit); err != nil {
+			return nil, err
+		}
+	}
+	if len(twos) == 0 {
+		return []ObjectID{one}, nil
+	}
+	return repo.mergeBases(one, twos)
+}
+
+// MergeBaseOctopus returns the best common ancestors of all the commits,
+// like git merge-base --octopus --all, as for a merge of all of them.
+func (repo *Repository) MergeBaseOctopus(ids ...ObjectID) ([]ObjectID, error) {
+	switch len(ids) {
+	case 0:
+		return nil, nil
+	case 1:
+		return repo.MergeBase(ids[0])
+	}
+	bases := ids[:1]
+	for _, id := range ids[1:] {
+		var next []ObjectID
+		for _, base := range bases {
+			found, err := repo.MergeBase(id, base)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range found {
+				if !containsObjectID(next, f) {
+					next = append(next, f)
+				}
+			}
+		}
+		bases = next
+	}
+	return repo.removeRedundant(bases)
+}
+
+// IsAncestor reports whether ancestor is reachable from descendant, that is
+// whether it is their merge base, like git merge-base --is-ancestor. A
+// commit is its own ancestor, unlike with Commit.IsAncestor.
+func (repo *Repository) IsAncestor(ancestor, descendant ObjectID) (bool, error) {
+	a, err := repo.peelTo(ancestor, ObjectCommit)
+	if err != nil {
+		return false, err
+	}
+	d, err := repo.peelTo(descendant, ObjectCommit)
+	if err != nil {
+		return false, err
+	}
+	if a == d {
+		return true, nil
+	}
+
+	// Like git's in_merge_bases: paint down from both, no further than the
+	// generation of a, and see whether a is painted from d.
+	ac, err := repo.commitNode(a)
+	if err != nil {
+		return false, err
+	}
+	dc, err := repo.commitNode(d)
+	if err != nil {
+		return false, err
+	}
+	if ac.Generation > dc.Generation {
+		return false, nil
+	}
+	_, flags, err := repo.paintDownToCommon(a, []ObjectID{d}, ac.Generation)
+	if err != nil {
+		return false, err
+	}
+	return flags[a]&paintParent2 != 0, nil
+}
+
+// countCommits counts the commits reachable from id but not from any of
+// hidden, like git rev-list --count id ^hidden. The walk goes down by
+// generation until only commits reachable from hidden are left.
+func (repo *Repository) countCommits(id ObjectID, hidden []ObjectID) (int, error) {
+	// Commits reachable from id are painted paintParent1, those reachable
+	// from hidden paintStale.
+	flags := map[ObjectID]int{}
+	queue := &commitNodeQueue{}
+	push := func(id ObjectID, f int) error {
+		c, err := repo.commitNode(id)
+		if err != nil {
+			return err
+		}
+		flags[id] |= f
+		heap.Push(queue, c)
+		return nil
+	}
+	if err := push(id, paintParent1); err != nil {
+		return 0, err
+	}
+	for _, h := range hidden {
+		if err := push(h, paintStale); err != nil {
+			return 0, err
+		}
+	}
+
+	for queue.nonStale(flags) {
+		c := heap.Pop(queue).(*CommitGraphCommit)
+		f := flags[c.Id] & (paintParent1 | paintStale)
+		for _, parent := range c.ParentIds {
+			if flags[parent]&f == f {
+				continue
+			}
+			if err := push(parent, f); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n := 0
+	for _, f := range flags {
+		if f == paintParent1 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func containsObjectID(ids []ObjectID, id ObjectID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeBases returns the best common ancestors of one and any of twos: the
+// common ancestors that are not ancestors of another common ancestor, as
+// found by git's merge_bases_many.
+func (repo *Repository) mergeBases(one ObjectID, twos []ObjectID) ([]ObjectID, error) {
+	for _, two := range twos {
+		if two == one {
+			return []ObjectID{one}, nil
+		}
+	}
+
+	results, flags, err := repo.paintDownToCommon(one, twos, 0)
+	if err != nil {
+		return nil, err
+	}
+	var bases []ObjectID
+	for _, id := range results {
+		if flags[id]&paintStale == 0 {
+			bases = append(bases, id)
+		}
+	}
+	return repo.removeRedundant(bases)
+}
+
+// paintDownToCommon paints the ancestors of one with paintParent1 and those
+// of twos with paintParent2, like git's paint_down_to_common. The commits
+// painted with both are common ancestors and their ancestors are painted
+// paintStale. Commits are walked by generation, then commit time, and the
+// walk stops at the first commit with a generation below minGeneration. It
+// returns the common ancestors found and the paint of the commits walked.
+func (repo *Repository) paintDownToCommon(one ObjectID, twos []ObjectID, minGeneration uint64) ([]ObjectID, map[ObjectID]int, error) {
+	flags := map[ObjectID]int{}
+	queue := &commitNodeQueue{}
+	push := func(id ObjectID, f int) error {
+		c, err := repo.commitNode(id)
+		if err != nil {
+			return err
+		}
+		flags[id] |= f
+		heap.Push(queue, c)
+		return nil
+	}
+	if err := push(one, paintParent1); err != nil {
+		return nil, nil, err
+	}
+	for _, two := range twos {
+		if err := push(two, paintParent2); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var results []ObjectID
+	for queue.nonStale(flags) {
+		c := heap.Pop(queue).(*CommitGraphCommit)
+		if c.Generation < minGeneration {
+			break
+		}
+		f := flags[c.Id] & (paintParent1 | paintParent2 | paintStale)
+		if f == paintParent1|paintParent2 {
+			if flags[c.Id]&paintResult == 0 {
+				flags[c.Id] |= paintResult
+				results = append(results, c.Id)
+			}
+			// Ancestors of a common ancestor are not best ones.
+			f |= paintStale
+		}
+		for _, parent := range c.ParentIds {
+			if flags[parent]&f == f {
+				continue
+			}
+			if err := push(parent, f); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return results, flags, nil
+}
+
+// removeRedundant drops the commits that are ancestors of another commit in
+// ids, like git's remove_redundant: each commit is painted down to the
+// others, no further than the lowest generation among them.
+func (repo *Repository) removeRedundant(ids []ObjectID) ([]ObjectID, error) {
+	if len(ids) < 2 {
+		return ids, nil
+	}
+	redundant := make([]bool, len(ids))
+	for i, id := range ids {
+		if redundant[i] {
+			continue
+		}
+		var others []ObjectID
+		var positions []int
+		minGeneration := uint64(GenerationNumberInfinity)
+		for j, other := range ids {
+			if i == j || redundant[j] || other == id {
+				continue
+			}
+			c, err := repo.commitNode(other)
+			if err != nil {
+				return nil, err
+			}
+			if c.Generation < minGeneration {
+				minGeneration = c.Generation
+			}
+			others = append(others, other)
+			positions = append(positions, j)
+		}
+		if len(others) == 0 {
+			continue
+		}
+
+		_, flags, err := repo.paintDownToCommon(id, others, minGeneration)
+		if err != nil {
+			return nil, err
+		}
+		if flags[id]&paintParent2 != 0 {
+			redundant[i] = true
+		}
+		for k, other := range others {
+			if flags[other]&paintParent1 != 0 {
+				redundant[positions[k]] = true
+			}
+		}
+	}
+
+	var kept []ObjectID
+	for i, id := range ids {
+		if !redundant[i] {
+			kept = append(kept, id)
+		}
+	}
+	return kept, nil
+}
+
+// commitNodeQueue is a heap of commits, greatest generation first, then
+// youngest commit time first and in insertion order among equals, like
+// git's compare_commits_by_gen_then_commit_date.
+type commitNodeQueue struct {
+	nodes []*CommitGraphCommit
+	seq   []int
+	next  int
+}
+
+func (q *commitNodeQueue) Len() int { return len(q.nodes) }
+
+func (q *commitNodeQueue) Less(i, j int) bool {
+	a, b := q.nodes[i], q.nodes[j]
+	if a.Generation != b.Generation {
+		return a.Generation > b.Generation
+	}
+	if ta, tb := a.CommitTime.Unix(), b.CommitTime.Unix(); ta != tb {
+		return ta > tb
+	}
+	return q.seq[i] < q.seq[j]
+}
+
+func (q *commitNodeQueue) Swap(i, j int) {
+	q.nodes[i], q.nodes[j] = q.nodes[j], q.nodes[i]
+	q.seq[i], q.seq[j] = q.seq[j], q.seq[i]
+}
+
+func (q *commitNodeQueue) Push(x interface{}) {
+	q.nodes = append(q.nodes, x.(*CommitGraphCommit))
+	q.seq = append(q.seq, q.next)
+	q.next++
+}
+
+func (q *commitNodeQueue) Pop() interface{} {
+	n := len(q.nodes) - 1
+	c := q.nodes[n]
+	q.nodes, q.seq = q.nodes[:n], q.seq[:n]
+	return c
+}
+
+// nonStale reports whether a commit in the queue is not painted paintStale.
+func (q *commitNodeQueue) nonStale(flags map[ObjectID]int) bool {
+	for _, c := range q.nodes {
+		if flags[c.Id]&paintStale == 0 {
+			return true
+		}
+	}
+	return false
+}
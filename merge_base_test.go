@@ -0,0 +1,215 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// repo7Names maps the commits of repo7 to their names.
+var repo7Names = map[ObjectID]string{}
+
+func init() {
+	for id, name := range map[string]string{
+		repo7A: "A", repo7B: "B", repo7C: "C", repo7D: "D", repo7X1: "X1",
+		repo7X2: "X2", repo7E: "E", repo7F: "F", repo7G: "G", repo7H: "H",
+		repo7I: "I", repo7K: "K", repo7J: "J", repo7L: "L", repo7T1: "T1",
+		repo7T2: "T2", repo7T: "T",
+	} {
+		repo7Names[ObjectIDHex(id)] = name
+	}
+}
+
+func repo7IDs(names string) []ObjectID {
+	var ids []ObjectID
+	for _, name := range strings.Fields(names) {
+		for id, n := range repo7Names {
+			if n == name {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func TestMergeBase(t *testing.T) {
+	r := openTestRepo(t, "repo7")
+	defer r.Close()
+
+	// Expected results are from git merge-base --all [--octopus].
+	for _, test := range []struct {
+		commits string
+		octopus bool
+		want    string
+	}{
+		{"E F", false, "C D"},
+		{"X1 X2", false, "C D"},
+		{"L T", false, "L"},
+		{"J F", false, "F"},
+		{"F G", false, "X2"},
+		{"H I", false, "H"},
+		{"L A", false, "A"},
+		{"E F G", false, "C D"},
+		{"G X1 X2", false, "X2"},
+		{"G X1 X2", true, "C D"},
+		{"E F G", true, "C D"},
+		{"B G C", true, "B"},
+		{"L T F", true, "F"},
+		{"T1 K", true, "K"},
+		{"E", true, "E"},
+	} {
+		ids := repo7IDs(test.commits)
+		var bases []ObjectID
+		var err error
+		if test.octopus {
+			bases, err = r.MergeBaseOctopus(ids...)
+		} else {
+			bases, err = r.MergeBase(ids[0], ids[1:]...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, id := range bases {
+			names = append(names, repo7Names[id])
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != test.want {
+			t.Errorf("%s octopus=%v: expected %s, got %s", test.commits, test.octopus, test.want, got)
+		}
+	}
+
+	// The same commit is its own ancestor for the repository, not for the
+	// commit.
+	l := repo7IDs("L")[0]
+	if ok, err := r.IsAncestor(l, l); !ok || err != nil {
+		t.Errorf("expected L to be its own ancestor, got %v, %v", ok, err)
+	}
+	c, err := r.GetCommit(l.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.IsAncestor(l.String()) {
+		t.Error("expected Commit.IsAncestor to be false for the same commit")
+	}
+
+	// Tags are peeled to the commits they point to.
+	tag, err := r.ResolveRevision("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.MergeBase(tag, ObjectIDHex(repo7L)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIsAncestorMergeBase(t *testing.T) {
+	r := openTestRepo(t, "repo7")
+	defer r.Close()
+
+	for _, test := range []struct {
+		ancestor, descendant string
+		want                 bool
+	}{
+		{"A", "L", true},
+		{"L", "L", true},
+		{"X2", "G", true},
+		{"G", "F", false},
+		{"E", "F", false},
+		{"L", "A", false},
+		{"T1", "T", true},
+	} {
+		ids := repo7IDs(test.ancestor + " " + test.descendant)
+		if test.ancestor == test.descendant {
+			ids = append(ids, ids[0])
+		}
+		got, err := r.IsAncestor(ids[0], ids[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s %s: expected %v, got %v", test.ancestor, test.descendant, test.want, got)
+		}
+	}
+
+	// The same commit is its own ancestor for the repository, not for the
+	// commit.
+	l := repo7IDs("L")[0]
+	if ok, err := r.IsAncestor(l, l); !ok || err != nil {
+		t.Errorf("expected L to be its own ancestor, got %v, %v", ok, err)
+	}
+	c, err := r.GetCommit(l.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.IsAncestor(l.String()) {
+		t.Error("expected Commit.IsAncestor to be false for the same commit")
+	}
+
+	// Tags are peeled to the commits they point to.
+	tag, err := r.ResolveRevision("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.IsAncestor(ObjectIDHex(repo7C), tag); !ok || err != nil {
+		t.Errorf("expected C to be an ancestor of v1, got %v, %v", ok, err)
+	}
+}
+
+func TestBehindAheadMerges(t *testing.T) {
+	// Remove the reachability bitmap, so that the merge bases are used.
+	dir := copyTestRepo(t, "repo7")
+	defer os.RemoveAll(dir)
+	bitmaps, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.bitmap"))
+	for _, b := range bitmaps {
+		if err := os.Remove(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Counts from git rev-list --left-right --count <target>...<commit>,
+	// walking the commits, then the commit-graph.
+	for _, graph := range []bool{false, true} {
+		if graph {
+			if err := r.WriteCommitGraph(CommitGraphWriteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if g, err := r.CommitGraph(); g == nil || err != nil {
+				t.Fatalf("expected a commit-graph, got %v", err)
+			}
+		}
+		for _, test := range []struct {
+			commit, target string
+			behind, ahead  int
+		}{
+			{"E", "F", 2, 2},
+			{"X2", "X1", 1, 1},
+			{"G", "C", 0, 3},
+			{"L", "F", 0, 8},
+			{"F", "T", 11, 0},
+		} {
+			ids := repo7IDs(test.commit + " " + test.target)
+			c, err := r.getCommit(ids[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			behind, ahead, err := c.BehindAhead(ids[1].String())
+			if err != nil || behind != test.behind || ahead != test.ahead {
+				t.Errorf("%s vs %s, graph=%v: expected %d behind, %d ahead, got %d, %d, %v",
+					test.commit, test.target, graph, test.behind, test.ahead, behind, ahead, err)
+			}
+		}
+
+		bases, err := r.MergeBase(repo7IDs("G")[0], repo7IDs("X1 X2")...)
+		if err != nil || len(bases) != 1 || repo7Names[bases[0]] != "X2" {
+			t.Errorf("graph=%v: expected X2 as merge base of G, X1 and X2, got %v, %v", graph, bases, err)
+		}
+	}
+}
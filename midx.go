@@ -0,0 +1,185 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrCorruptMultiPackIndex = errors.New("corrupt multi-pack-index")
+
+const midxLargeOffsetNeeded = 0x80000000
+
+// multiPackIndex is an objects/pack/multi-pack-index file, as written by
+// `git multi-pack-index write`. It indexes the objects of many packs at once,
+// so a lookup needs a single binary search instead of one per pack.
+type multiPackIndex struct {
+	f          *os.File
+	info       os.FileInfo
+	format     ObjectFormat
+	numObjects uint32
+	packNames  []string // pack ids, without extension
+
+	oidFanout    int64
+	oidLookup    int64
+	objectOffset int64
+	largeOffsets int64
+	largeEnd     int64
+
+	refs int32 // pack sets holding the file, see pack.refs
+}
+
+// openMultiPackIndex opens the multi-pack-index at path, which must use the
+// given object format.
+func openMultiPackIndex(path string, format ObjectFormat) (*multiPackIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := readMultiPackIndex(f, format)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+func readMultiPackIndex(f *os.File, format ObjectFormat) (*multiPackIndex, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+
+	header := readBytesAt(f, 0, 12)
+	if !bytes.Equal(header[:4], []byte("MIDX")) {
+		return nil, fmt.Errorf("%v: wrong signature", ErrCorruptMultiPackIndex)
+	}
+	if header[4] != 1 {
+		return nil, fmt.Errorf("unsupported multi-pack-index version %d", header[4])
+	}
+	if header[5] != format.hashVersion() {
+		return nil, fmt.Errorf("unsupported multi-pack-index hash version %d", header[5])
+	}
+	numChunks := int64(header[6])
+	if header[7] != 0 {
+		return nil, fmt.Errorf("unsupported multi-pack-index with %d base files", header[7])
+	}
+	numPacks := binary.BigEndian.Uint32(header[8:])
+
+	m := &multiPackIndex{f: f, info: fi, format: format}
+
+	// The chunk table has one extra entry marking the end of the last chunk.
+	idLen := int64(format.Size())
+	chunkEnd := size - idLen
+	var packNames, packNamesEnd int64
+	var prev int64
+	var prevChunk string
+	for i := int64(0); i <= numChunks; i++ {
+		entry := readBytesAt(f, 12+12*i, 12)
+		offset := int64(binary.BigEndian.Uint64(entry[4:]))
+		if offset < 12+12*(numChunks+1) || offset > chunkEnd {
+			return nil, fmt.Errorf("%v: chunk offset out of bounds", ErrCorruptMultiPackIndex)
+		}
+		if offset < prev {
+			return nil, fmt.Errorf("%v: chunk offsets out of order", ErrCorruptMultiPackIndex)
+		}
+		// Each chunk ends where the next one starts.
+		switch prevChunk {
+		case "PNAM":
+			packNamesEnd = offset
+		case "LOFF":
+			m.largeEnd = offset
+		}
+		prev, prevChunk = offset, string(entry[:4])
+		switch prevChunk {
+		case "PNAM":
+			packNames = offset
+		case "OIDF":
+			m.oidFanout = offset
+		case "OIDL":
+			m.oidLookup = offset
+		case "OOFF":
+			m.objectOffset = offset
+		case "LOFF":
+			m.largeOffsets = offset
+		}
+	}
+	if packNames == 0 || m.oidFanout == 0 || m.oidLookup == 0 || m.objectOffset == 0 {
+		return nil, fmt.Errorf("%v: missing required chunk", ErrCorruptMultiPackIndex)
+	}
+
+	m.numObjects = binary.BigEndian.Uint32(readBytesAt(f, m.oidFanout+4*255, 4))
+	if m.oidLookup+int64(m.numObjects)*idLen > chunkEnd || m.objectOffset+int64(m.numObjects)*8 > chunkEnd {
+		return nil, fmt.Errorf("%v: too many objects for file size", ErrCorruptMultiPackIndex)
+	}
+
+	// Pack names are NUL terminated, in order of the pack ids used by OOFF.
+	if packNamesEnd <= packNames {
+		return nil, fmt.Errorf("%v: bad pack names", ErrCorruptMultiPackIndex)
+	}
+	names := readBytesAt(f, packNames, int(packNamesEnd-packNames))
+	for i := uint32(0); i < numPacks; i++ {
+		end := bytes.IndexByte(names, 0)
+		if end <= 0 {
+			return nil, fmt.Errorf("%v: bad pack names", ErrCorruptMultiPackIndex)
+		}
+		name := string(names[:end])
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".idx"), ".pack")
+		m.packNames = append(m.packNames, name)
+		names = names[end+1:]
+	}
+
+	return m, nil
+}
+
+func (m *multiPackIndex) Close() error {
+	return m.f.Close()
+}
+
+// unchanged reports whether the file at path is still the one m was read
+// from.
+func (m *multiPackIndex) unchanged(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, m.info) && fi.Size() == m.info.Size() && fi.ModTime().Equal(m.info.ModTime())
+}
+
+// find returns the position in packNames of the pack holding the object and
+// the object's offset in that pack.
+func (m *multiPackIndex) find(id ObjectID) (uint32, uint64, error) {
+	if len(id) != m.format.Size() {
+		return 0, 0, ObjectNotFound(id)
+	}
+	firstByte := id[0]
+	min := uint32(0)
+	if firstByte > 0 {
+		min = binary.BigEndian.Uint32(readBytesAt(m.f, m.oidFanout+4*int64(firstByte-1), 4))
+	}
+	max := binary.BigEndian.Uint32(readBytesAt(m.f, m.oidFanout+4*int64(firstByte), 4))
+	if min >= max || max > m.numObjects {
+		return 0, 0, ObjectNotFound(id)
+	}
+
+	pos, err := binarySearch(m.f, m.oidLookup, min, max-1, id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	entry := readBytesAt(m.f, m.objectOffset+8*int64(pos), 8)
+	packId := binary.BigEndian.Uint32(entry)
+	offset := uint64(binary.BigEndian.Uint32(entry[4:]))
+	if packId >= uint32(len(m.packNames)) {
+		return 0, 0, fmt.Errorf("%v: pack id %d out of range", ErrCorruptMultiPackIndex, packId)
+	}
+	if offset&midxLargeOffsetNeeded != 0 && m.largeOffsets != 0 {
+		large := m.largeOffsets + 8*int64(offset&^midxLargeOffsetNeeded)
+		if large+8 > m.largeEnd {
+			return 0, 0, fmt.Errorf("%v: large offset out of range", ErrCorruptMultiPackIndex)
+		}
+		offset = binary.BigEndian.Uint64(readBytesAt(m.f, large, 8))
+	}
+	return packId, offset, nil
+}
@@ -0,0 +1,173 @@
+package git
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Commits of testdata/repo5, see testdata/prepare_repo5.sh.
+const (
+	repo5A = "83d0f7870a75a28fa0fb4b0d5e190e451cbd97ab"
+	repo5B = "817d5c49b120028f87827f9c08c16ec197608b19"
+	repo5C = "3daa4461796af116024d7289456c0b1d7f6991aa"
+	repo5D = "8bc916c91e725d5a3ea323a3744d7003711b4478"
+)
+
+func TestMultiPackIndex(t *testing.T) {
+	s, err := OpenFileObjectStore("testdata/repo5/objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ps := currentPacks(t, s)
+	if ps.midx == nil {
+		t.Fatal("expected a multi-pack-index")
+	}
+	if len(ps.packs) != 3 || len(ps.midxPacks) != 2 || len(ps.uncovered) != 1 {
+		t.Fatalf("expected 2 of 3 packs covered, got %d of %d", len(ps.midxPacks), len(ps.packs))
+	}
+
+	for _, id := range []string{repo5A, repo5B} {
+		p, _, err := ps.find(ObjectIDHex(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != ps.midxPacks[0] && p != ps.midxPacks[1] {
+			t.Errorf("expected %s in a pack of the multi-pack-index", id)
+		}
+	}
+	p, _, err := ps.find(ObjectIDHex(repo5C))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != ps.uncovered[0] {
+		t.Errorf("expected %s in the pack not covered by the multi-pack-index", repo5C)
+	}
+
+	r, err := OpenRepositoryWithOptions("testdata/repo5", RepositoryOptions{ObjectStore: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.GetCommit(repo5D)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	err = c.Walk(func(path []*Commit, c *Commit, err error) error {
+		if err != nil {
+			return err
+		}
+		entries, err := c.Tree.ListEntries()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if _, err := e.Blob().Data(); err != nil {
+				return err
+			}
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 commits, got %d", n)
+	}
+	if ok, err := s.Has(ObjectIDHex("0000000000000000000000000000000000000000")); ok || err != nil {
+		t.Errorf("expected missing object, got %v, %v", ok, err)
+	}
+}
+
+func TestMultiPackIndexMissingPack(t *testing.T) {
+	dir := copyTestRepo(t, "repo5")
+	defer os.RemoveAll(dir)
+
+	// Remove the pack holding A, which the multi-pack-index still lists.
+	s, err := OpenFileObjectStore(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _, err := currentPacks(t, s).find(ObjectIDHex(repo5A))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := p.id
+	s.Close()
+	for _, ext := range []string{".idx", ".pack"} {
+		if err := os.Remove(filepath.Join(dir, "objects", "pack", id+ext)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err = OpenFileObjectStore(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if ps := currentPacks(t, s); ps.midx != nil || len(ps.uncovered) != 2 {
+		t.Error("expected the multi-pack-index to be ignored")
+	}
+	if ok, err := s.Has(ObjectIDHex(repo5B)); !ok || err != nil {
+		t.Errorf("expected B to be found, got %v, %v", ok, err)
+	}
+	if ok, err := s.Has(ObjectIDHex(repo5A)); ok || err != nil {
+		t.Errorf("expected A to be missing, got %v, %v", ok, err)
+	}
+}
+
+func TestMultiPackIndexCorrupt(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/repo5/objects/pack/multi-pack-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The chunk table follows the 12 byte header, 12 bytes per chunk.
+	pnam := -1
+	for i := 0; i < int(data[6]); i++ {
+		if string(data[12+12*i:16+12*i]) == "PNAM" {
+			pnam = i
+		}
+	}
+	if pnam < 0 {
+		t.Fatal("expected a PNAM chunk")
+	}
+	offset := func(b []byte, i int) []byte { return b[16+12*i : 24+12*i] }
+
+	for name, corrupt := range map[string]func(b []byte){
+		// The chunk after PNAM starts before it.
+		"out of order": func(b []byte) {
+			start := binary.BigEndian.Uint64(offset(b, pnam))
+			binary.BigEndian.PutUint64(offset(b, pnam+1), start-1)
+		},
+		// The PNAM chunk is empty.
+		"empty pack names": func(b []byte) {
+			copy(offset(b, pnam+1), offset(b, pnam))
+		},
+	} {
+		b := append([]byte(nil), data...)
+		corrupt(b)
+		path := filepath.Join(dir, "multi-pack-index")
+		if err := ioutil.WriteFile(path, b, 0664); err != nil {
+			t.Fatal(err)
+		}
+		m, err := openMultiPackIndex(path, ObjectFormatSHA1)
+		if err == nil {
+			m.Close()
+			t.Errorf("%s: expected an error", name)
+		} else if !strings.Contains(err.Error(), ErrCorruptMultiPackIndex.Error()) {
+			t.Errorf("%s: expected %v, got %v", name, ErrCorruptMultiPackIndex, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Commits of testdata/repo6, see testdata/prepare_repo6.sh.
+const (
+	repo6A = "064b34403356ff48d296ce0f5867fb50ef3c793fbd094b9a1aa8ec8731ffabf3"
+	repo6B = "022895365aa537509b7dfa8c73b61ffef1cf2622ffb62984270ff84c4bb48286"
+	repo6C = "053af844e72f5f421cebf380882c8faff3d2e9c78b45677c2df376d9f36b0ec6"
+	repo6D = "1c057ea9d3e99780d72e395facd9f5d68f6e9909f3e46c5dde3030c59bd8bbec"
+)
+
+func TestReadObjectFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for config, want := range map[string]ObjectFormat{
+		"[core]\n\tbare = true\n": ObjectFormatSHA1,
+		"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = sha256\n":        ObjectFormatSHA256,
+		"[Extensions]\n\tobjectFormat = \"SHA256\" ; comment\n":                                 ObjectFormatSHA256,
+		"[core]\n\tobjectformat = sha256\n":                                                     ObjectFormatSHA1,
+		"\xef\xbb\xbf[extensions]\n\tobjectformat = sha256\n":                                   ObjectFormatSHA256,
+		"[alias]\n\tx = \"unterminated\n[bad\n\ty = 1\n[extensions]\n\tobjectformat = sha256\n": ObjectFormatSHA256,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0664); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readObjectFormat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%q: expected %v, got %v", config, want, got)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "config"), []byte("[extensions]\nobjectformat = md5\n"), 0664)
+	if _, err := readObjectFormat(dir); err == nil {
+		t.Error("expected an error for an unknown object format")
+	}
+	for _, config := range []string{
+		"[extensions]\nobjectformat = \"sha256\n",
+		"[extensions\nobjectformat = sha256\n",
+		"[\nobjectformat = sha256\n",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0664)
+		if _, err := readObjectFormat(dir); err == nil {
+			t.Errorf("%q: expected an error for a broken extensions section", config)
+		}
+	}
+}
+
+func TestSHA256Repository(t *testing.T) {
+	r := openTestRepo(t, "repo6")
+	defer r.Close()
+
+	if f := r.ObjectFormat(); f != ObjectFormatSHA256 {
+		t.Fatalf("expected sha256, got %v", f)
+	}
+
+	head, err := r.GetCommitIdOfRef("HEAD")
+	if err != nil || head != repo6D {
+		t.Errorf("expected HEAD to be %s, got %q, %v", repo6D, head, err)
+	}
+
+	// D is loose, C in a pack of its own, and A and B in a pack covered by
+	// the multi-pack-index and the commit-graph.
+	c, err := r.GetCommit(repo6D)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for {
+		ids = append(ids, c.Id.String())
+		if c.ParentCount() == 0 {
+			break
+		}
+		if c, err = c.Parent(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{repo6D, repo6C, repo6B, repo6A}; strings.Join(ids, " ") != strings.Join(want, " ") {
+		t.Errorf("expected history %v, got %v", want, ids)
+	}
+
+	g, err := r.CommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g == nil {
+		t.Fatal("expected a commit-graph")
+	}
+	gc, err := g.Lookup(ObjectIDHex(repo6B))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gc.ParentIds) != 1 || gc.ParentIds[0].String() != repo6A || len(gc.TreeId) != 32 {
+		t.Errorf("unexpected commit-graph entry %+v", gc)
+	}
+
+	// Four commits, each with a tree and a new blob.
+	reachable, err := r.ReachableFrom(ObjectIDHex(repo6D))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := reachable.Count(); n != 12 {
+		t.Errorf("expected 12 reachable objects, got %d", n)
+	}
+
+	tree, err := r.getTree(c.TreeId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := tree.ListEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name() != "A.txt" {
+		t.Fatalf("expected A.txt, got %v", list)
+	}
+	data, err := list[0].Blob().Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "A" {
+		t.Errorf("expected %q, got %q", "A", data)
+	}
+
+	findings, err := r.Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("expected no findings, got %v", findings)
+	}
+}
+
+func TestSHA256Write(t *testing.T) {
+	dir := copyTestRepo(t, "repo6")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepositoryWithOptions(dir, RepositoryOptions{VerifyObjects: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Same as `echo -n A | git hash-object --stdin` in the repository.
+	id, err := r.StoreObjectLoose(ObjectBlob, strings.NewReader("A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.GetCommit(repo6A)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := r.getTree(c.TreeId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := tree.ListEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != list[0].Id {
+		t.Errorf("expected %s, got %s", list[0].Id, id)
+	}
+
+	// A pack written and indexed again reads back the same objects.
+	var pack, idx bytes.Buffer
+	reachable, err := r.ReachableFrom(ObjectIDHex(repo6D))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []ObjectID
+	reachable.ForEach(func(id ObjectID, typ ObjectType) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if _, err := NewPackWriter(r, PackWriterOptions{}).WritePack(ids, &pack, &idx); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(objects)
+	if _, err := r.IndexPack(&pack, objects); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenFileObjectStoreFormat(objects, ObjectFormatSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, id := range ids {
+		o, err := s.Get(id, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyObject(id, o); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := r.GC(GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteCommitGraph(CommitGraphWriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	findings, err := r.Fsck(FsckOptions{NoDangling: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("expected no findings after GC, got %v", findings)
+	}
+}
@@ -11,8 +11,10 @@ func (id ObjectID) String() string {
 	return hex.EncodeToString([]byte(id))
 }
 
+// IsObjectIDHex reports whether s is the hex form of a SHA-1 or SHA-256
+// object ID.
 func IsObjectIDHex(s string) bool {
-	if len(s) != 40 {
+	if len(s) != 2*ObjectFormatSHA1.Size() && len(s) != 2*ObjectFormatSHA256.Size() {
 		return false
 	}
 	_, err := hex.DecodeString(s)
@@ -20,9 +22,19 @@ func IsObjectIDHex(s string) bool {
 }
 
 func ObjectIDHex(s string) ObjectID {
-	d, err := hex.DecodeString(s)
-	if err != nil || len(d) != 20 {
+	id, err := parseObjectIDHex(s)
+	if err != nil {
 		panic(fmt.Sprintf("invalid input to ObjectIdHex: %q", s))
 	}
-	return ObjectID(d)
+	return id
+}
+
+// parseObjectIDHex is like ObjectIDHex, but returns an error for invalid
+// input, for parsing object data that may be corrupt.
+func parseObjectIDHex(s string) (ObjectID, error) {
+	d, err := hex.DecodeString(s)
+	if err != nil || len(d) != ObjectFormatSHA1.Size() && len(d) != ObjectFormatSHA256.Size() {
+		return "", fmt.Errorf("invalid object id %q", s)
+	}
+	return ObjectID(d), nil
 }
@@ -0,0 +1,323 @@
+package git
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// minPrefixLen is the shortest abbreviated object id that is resolved, as in
+// git.
+const minPrefixLen = 4
+
+// PrefixNotFound is returned by ResolvePrefix when no object id starts with
+// the given prefix.
+type PrefixNotFound string
+
+func (p PrefixNotFound) Error() string {
+	return fmt.Sprintf("no object id starts with %s", string(p))
+}
+
+// AmbiguousPrefix is returned by ResolvePrefix when several object ids start
+// with the given prefix.
+type AmbiguousPrefix struct {
+	Prefix     string
+	Candidates []ObjectID // sorted
+}
+
+func (e *AmbiguousPrefix) Error() string {
+	hexes := make([]string, len(e.Candidates))
+	for i, id := range e.Candidates {
+		hexes[i] = id.String()
+	}
+	return fmt.Sprintf("short object id %s is ambiguous, candidates are %s", e.Prefix, strings.Join(hexes, ", "))
+}
+
+// idPrefix is an abbreviated object id of at least minPrefixLen hex digits.
+type idPrefix struct {
+	hex string // lower case
+	key string // the prefix padded with zero bits to whole bytes
+}
+
+func parseIDPrefix(s string, format ObjectFormat) (idPrefix, error) {
+	s = strings.ToLower(s)
+	padded := s
+	if len(padded)%2 != 0 {
+		padded += "0"
+	}
+	key, err := hex.DecodeString(padded)
+	if err != nil || len(s) < minPrefixLen || len(s) > 2*format.Size() {
+		return idPrefix{}, fmt.Errorf("invalid object id prefix %q", s)
+	}
+	return idPrefix{hex: s, key: string(key)}, nil
+}
+
+func (p idPrefix) matches(id ObjectID) bool {
+	return strings.HasPrefix(id.String(), p.hex)
+}
+
+// prefixMatcher is implemented by object stores that can find the objects
+// with an abbreviated id without visiting every object.
+type prefixMatcher interface {
+	// matchPrefix calls fn for the id of every object that starts with p,
+	// possibly more than once for the same object.
+	matchPrefix(p idPrefix, fn func(id ObjectID) error) error
+}
+
+// ResolvePrefix returns the id of the object whose hex id starts with
+// prefix, which must be at least 4 hex digits long. It returns
+// PrefixNotFound if there is no such object, and an *AmbiguousPrefix listing
+// the candidates if there are several.
+func (repo *Repository) ResolvePrefix(prefix string) (ObjectID, error) {
+	p, err := parseIDPrefix(prefix, repo.format)
+	if err != nil {
+		return "", err
+	}
+	if len(p.hex) == 2*repo.format.Size() {
+		id := ObjectID(p.key)
+		ok, err := repo.store.Has(id)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", PrefixNotFound(p.hex)
+		}
+		return id, nil
+	}
+
+	ids, err := repo.prefixMatches(p)
+	if err != nil {
+		return "", err
+	}
+	switch len(ids) {
+	case 0:
+		return "", PrefixNotFound(p.hex)
+	case 1:
+		return ids[0], nil
+	}
+	return "", &AmbiguousPrefix{Prefix: p.hex, Candidates: ids}
+}
+
+// resolveID returns the id of the object named by s, a full or abbreviated
+// hex object id. Abbreviated ids are resolved with ResolvePrefix; full ones
+// are only parsed, the object need not exist.
+func (repo *Repository) resolveID(s string) (ObjectID, error) {
+	if len(s) == 2*repo.format.Size() {
+		return parseObjectIDHex(s)
+	}
+	return repo.ResolvePrefix(s)
+}
+
+// ShortID returns the shortest abbreviation of id, of at least minLen hex
+// digits, that no other object in the repository starts with. minLen is
+// raised to 4 if it is lower. The object itself need not exist.
+func (repo *Repository) ShortID(id ObjectID, minLen int) (string, error) {
+	full := id.String()
+	if minLen < minPrefixLen {
+		minLen = minPrefixLen
+	}
+	if minLen >= len(full) {
+		return full, nil
+	}
+	p, err := parseIDPrefix(full[:minLen], repo.format)
+	if err != nil {
+		return "", err
+	}
+	ids, err := repo.prefixMatches(p)
+	if err != nil {
+		return "", err
+	}
+
+	// One digit more than the longest prefix shared with another object
+	// tells them apart.
+	n := minLen
+	for _, other := range ids {
+		if other == id {
+			continue
+		}
+		other := other.String()
+		common := 0
+		for common < len(full) && common < len(other) && full[common] == other[common] {
+			common++
+		}
+		if common+1 > n {
+			n = common + 1
+		}
+	}
+	if n > len(full) {
+		n = len(full)
+	}
+	return full[:n], nil
+}
+
+// prefixMatches returns the sorted, distinct ids of the objects that start
+// with p.
+func (repo *Repository) prefixMatches(p idPrefix) ([]ObjectID, error) {
+	seen := map[ObjectID]bool{}
+	var ids []ObjectID
+	add := func(id ObjectID) error {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+		return nil
+	}
+
+	var err error
+	if m, ok := repo.store.(prefixMatcher); ok {
+		err = m.matchPrefix(p, add)
+	} else {
+		err = repo.store.ForEach(func(id ObjectID) error {
+			if p.matches(id) {
+				return add(id)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// forEachPrefixMatch calls fn for the ids starting with p in a sorted table
+// of idLen byte ids at tableStart, with a 256 entry fan-out table of 4 byte
+// cumulative counts at fanoutStart, as in pack indexes.
+func forEachPrefixMatch(r io.ReaderAt, fanoutStart, tableStart int64, idLen int, p idPrefix, fn func(id ObjectID) error) error {
+	if len(p.key) > idLen {
+		return nil
+	}
+	firstByte := p.key[0]
+	min := uint32(0)
+	if firstByte > 0 {
+		min = binary.BigEndian.Uint32(readBytesAt(r, fanoutStart+4*int64(firstByte-1), 4))
+	}
+	max := binary.BigEndian.Uint32(readBytesAt(r, fanoutStart+4*int64(firstByte), 4))
+
+	// Find the first id not less than the prefix, then visit the ids
+	// from there on for as long as they match.
+	id := func(i uint32) ObjectID {
+		return ObjectID(readBytesAt(r, tableStart+int64(idLen)*int64(i), idLen))
+	}
+	lo, hi := min, max
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if string(id(mid)) < p.key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	for i := lo; i < max; i++ {
+		found := id(i)
+		if !p.matches(found) {
+			break
+		}
+		if err := fn(found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pack) matchPrefix(prefix idPrefix, fn func(id ObjectID) error) error {
+	r, err := p.indexFileReader()
+	if err != nil {
+		return err
+	}
+	l := readIndexLayout(r, p.store.format)
+	return forEachPrefixMatch(r, l.fanoutTableStart, l.nameTableStart, int(l.idLen), prefix, fn)
+}
+
+func (m *multiPackIndex) matchPrefix(p idPrefix, fn func(id ObjectID) error) error {
+	return forEachPrefixMatch(m.f, m.oidFanout, m.oidLookup, m.format.Size(), p, fn)
+}
+
+// matchPrefix looks in the fan-out directory of the loose objects, the
+// packs and the alternates. Like Get and Has, it rescans the packs and looks
+// in them again if nothing was found, as the objects may have been repacked.
+func (s *FileObjectStore) matchPrefix(p idPrefix, fn func(id ObjectID) error) error {
+	found := false
+	match := func(id ObjectID) error {
+		found = true
+		return fn(id)
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, p.hex[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, info := range infos {
+		name := p.hex[:2] + info.Name()
+		if len(name) != 2*s.format.Size() || !strings.HasPrefix(name, p.hex) || !IsObjectIDHex(name) {
+			continue
+		}
+		if err := match(ObjectIDHex(name)); err != nil {
+			return err
+		}
+	}
+
+	ps := s.acquire()
+	err = ps.matchPrefix(p, match)
+	ps.release()
+	if err != nil {
+		return err
+	}
+	for _, a := range s.alternates {
+		if err := a.matchPrefix(p, match); err != nil {
+			return err
+		}
+	}
+	if found {
+		return nil
+	}
+
+	retry, err := s.rescanAfterMiss(ps)
+	if err != nil {
+		return err
+	}
+	defer retry.release()
+	if retry == ps {
+		return nil
+	}
+	return retry.matchPrefix(p, fn)
+}
+
+func (ps *packSet) matchPrefix(p idPrefix, fn func(id ObjectID) error) error {
+	if ps.midx != nil {
+		if err := ps.midx.matchPrefix(p, fn); err != nil {
+			return err
+		}
+	}
+	for _, pack := range ps.uncovered {
+		if err := pack.matchPrefix(p, fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryObjectStore) matchPrefix(p idPrefix, fn func(id ObjectID) error) error {
+	s.mu.RLock()
+	var ids []ObjectID
+	for id := range s.objects {
+		if p.matches(id) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
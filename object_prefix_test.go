@@ -0,0 +1,138 @@
+package git
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolvePrefix(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	for prefix, want := range map[string]string{
+		"8b61789":  "8b61789a76de9edaa49b2529d3aaa302ba238c0b", // packed commit
+		"8B61789A": "8b61789a76de9edaa49b2529d3aaa302ba238c0b",
+		"d76b":     "d76bde4f5d1ed609dc82d8cd7d216d893830f1c9", // loose blob
+		"e7fd4":    "e7fd4bfbe1ea64c596fb7c45397fd6d57f73ec9c",
+		"d76bde4f5d1ed609dc82d8cd7d216d893830f1c9": "d76bde4f5d1ed609dc82d8cd7d216d893830f1c9",
+	} {
+		id, err := r.ResolvePrefix(prefix)
+		if err != nil {
+			t.Errorf("%s: %v", prefix, err)
+			continue
+		}
+		if id.String() != want {
+			t.Errorf("%s: expected %s, got %s", prefix, want, id)
+		}
+	}
+
+	_, err := r.ResolvePrefix("e7fd")
+	amb, ok := err.(*AmbiguousPrefix)
+	if !ok {
+		t.Fatalf("expected an ambiguity error, got %v", err)
+	}
+	if len(amb.Candidates) != 2 || amb.Candidates[0].String() != "e7fd4bfbe1ea64c596fb7c45397fd6d57f73ec9c" ||
+		amb.Candidates[1].String() != "e7fdd11f9ab344eb3006695ea0759e8ce895a721" {
+		t.Errorf("unexpected candidates %v", amb.Candidates)
+	}
+
+	if _, err := r.ResolvePrefix("0000"); err != PrefixNotFound("0000") {
+		t.Errorf("expected PrefixNotFound, got %v", err)
+	}
+	for _, prefix := range []string{"", "8b6", "8b6z", strings.Repeat("0", 41)} {
+		if _, err := r.ResolvePrefix(prefix); err == nil {
+			t.Errorf("%q: expected an error", prefix)
+		}
+	}
+}
+
+func TestResolvePrefixMultiPackIndex(t *testing.T) {
+	r := openTestRepo(t, "repo5")
+	defer r.Close()
+
+	for _, full := range []string{repo5A, repo5B, repo5C, repo5D} {
+		id, err := r.ResolvePrefix(full[:7])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.String() != full {
+			t.Errorf("expected %s, got %s", full, id)
+		}
+	}
+}
+
+func TestResolvePrefixRescan(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := r.ObjectStore().(*FileObjectStore)
+
+	// Another process adds a pack.
+	src := openTestRepo(t, "repo")
+	defer src.Close()
+	id := ObjectIDHex("8b61789a76de9edaa49b2529d3aaa302ba238c0b")
+	writeTestPack(t, src, s.Dir(), id)
+
+	s.SetPackRescanInterval(time.Hour)
+	if _, err := r.ResolvePrefix("8b61789"); err != PrefixNotFound("8b61789") {
+		t.Errorf("expected no rescan within the interval, got %v", err)
+	}
+	s.SetPackRescanInterval(0)
+	if got, err := r.ResolvePrefix("8b61789"); got != id || err != nil {
+		t.Errorf("expected %s from the new pack, got %s, %v", id, got, err)
+	}
+}
+
+func TestShortID(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	for _, test := range []struct {
+		id     string
+		minLen int
+		want   string
+	}{
+		{"e7fd4bfbe1ea64c596fb7c45397fd6d57f73ec9c", 0, "e7fd4"},
+		{"e7fdd11f9ab344eb3006695ea0759e8ce895a721", 4, "e7fdd"},
+		{"8b61789a76de9edaa49b2529d3aaa302ba238c0b", 7, "8b61789"},
+		{"8b61789a76de9edaa49b2529d3aaa302ba238c0b", 50, "8b61789a76de9edaa49b2529d3aaa302ba238c0b"},
+	} {
+		got, err := r.ShortID(ObjectIDHex(test.id), test.minLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("ShortID(%s, %d) = %s, expected %s", test.id, test.minLen, got, test.want)
+		}
+	}
+}
+
+func TestResolvePrefixMemory(t *testing.T) {
+	r := OpenMemoryRepository()
+	defer r.Close()
+
+	id, err := r.StoreObjectLoose(ObjectBlob, strings.NewReader("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ResolvePrefix(id.String()[:6])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("expected %s, got %s", id, got)
+	}
+	short, err := r.ShortID(id, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if short != id.String()[:4] {
+		t.Errorf("expected %s, got %s", id.String()[:4], short)
+	}
+}
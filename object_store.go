@@ -0,0 +1,37 @@
+package git
+
+import "io"
+
+// ObjectStore is the storage backend a Repository reads objects from and
+// writes objects to. The default implementation, FileObjectStore, serves the
+// loose and packed objects of a repository's objects directory.
+type ObjectStore interface {
+	// Get returns the object with the given id. If metaOnly is true, only
+	// Type and Size need to be filled in. ObjectNotFound is returned if the
+	// store does not contain the object.
+	Get(id ObjectID, metaOnly bool) (*Object, error)
+
+	// Has reports whether the store contains the object with the given id.
+	Has(id ObjectID) (bool, error)
+
+	// Put stores the contents of r as an object of the given type and
+	// returns its id. Storing an object that already exists is not an error.
+	Put(objectType ObjectType, r io.ReadSeeker) (ObjectID, error)
+
+	// ForEach calls fn for every object id in the store. An object may be
+	// reported more than once if the store holds several copies of it.
+	// Iteration stops at the first error returned by fn, which ForEach
+	// returns.
+	ForEach(fn func(id ObjectID) error) error
+
+	io.Closer
+}
+
+// ObjectStreamer is implemented by ObjectStores that can stream the contents
+// of an object without holding all of it in memory. Repository.ObjectReader
+// falls back to ObjectStore.Get for stores that do not implement it.
+type ObjectStreamer interface {
+	// Reader returns a reader for the contents of the object with the given
+	// id, or ObjectNotFound.
+	Reader(id ObjectID) (*ObjectReader, error)
+}
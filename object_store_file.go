@@ -0,0 +1,647 @@
+package git
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPackRescanInterval is the minimum time between two rescans of the
+// pack directory triggered by objects that are not found.
+const DefaultPackRescanInterval = time.Second
+
+// FileObjectStore is an ObjectStore backed by a git objects directory,
+// holding loose objects in objects/xx/ and packs in objects/pack/. Packed
+// objects are looked up in objects/pack/multi-pack-index if there is one, and
+// in the .idx file of every pack it does not cover.
+//
+// When an object is not found, the pack directory is scanned again, so that
+// packs written by other processes, such as `git fetch` or `git gc`, are
+// picked up. Packs that are removed keep being read through their open files
+// until the reads that started before the rescan are done, and are closed
+// then.
+//
+// Objects missing from the directory are looked up in its alternates, see
+// Alternates.
+type FileObjectStore struct {
+	dir    string
+	format ObjectFormat
+
+	alternates []*FileObjectStore
+	objectDirs map[string]bool // real paths of dir and the alternates
+
+	mu             sync.RWMutex
+	packSet        *packSet
+	lastScan       time.Time
+	rescanInterval time.Duration
+
+	deltaBaseCache *lruCache
+}
+
+// packSet is the packs of a FileObjectStore as of a scan of the pack
+// directory. It is reference counted, see acquire, and holds a reference to
+// each of its packs and its multi-pack-index.
+type packSet struct {
+	refs int32 // the store while the set is current, and readers
+
+	packs     []*pack
+	midx      *multiPackIndex
+	midxPacks []*pack // by pack id in the multi-pack-index
+	uncovered []*pack // packs not covered by the multi-pack-index
+}
+
+// OpenFileObjectStore opens the objects directory at dir, usually the
+// "objects" directory of a repository, and the alternates it lists. The
+// objects are named by their SHA-1 hash.
+func OpenFileObjectStore(dir string) (*FileObjectStore, error) {
+	return OpenFileObjectStoreFormat(dir, ObjectFormatSHA1)
+}
+
+// OpenFileObjectStoreFormat is like OpenFileObjectStore, for an objects
+// directory whose objects are named by hashes of the given format.
+func OpenFileObjectStoreFormat(dir string, format ObjectFormat) (*FileObjectStore, error) {
+	s, err := openFileObjectStore(dir, format)
+	if err != nil {
+		return nil, err
+	}
+
+	real, err := filepath.EvalSymlinks(s.dir)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.objectDirs = map[string]bool{real: true}
+	paths, err := readAlternates(s.dir)
+	if err == nil {
+		err = s.addAlternates(paths, s.dir, 1)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openFileObjectStore opens the objects directory at dir without its
+// alternates.
+func openFileObjectStore(dir string, format ObjectFormat) (*FileObjectStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileObjectStore{
+		dir:            dir,
+		format:         format,
+		rescanInterval: DefaultPackRescanInterval,
+		deltaBaseCache: newLRUCache(DefaultDeltaBaseCacheLimit),
+	}
+	if err := s.rescan(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dir returns the objects directory of the store.
+func (s *FileObjectStore) Dir() string {
+	return s.dir
+}
+
+// ObjectFormat returns the hash function the store's objects are named by.
+func (s *FileObjectStore) ObjectFormat() ObjectFormat {
+	return s.format
+}
+
+// SetDeltaBaseCacheLimit sets the maximum number of bytes of inflated delta
+// bases kept in memory to speed up reading deltified packed objects. A limit
+// of zero or less disables the cache. The cache is shared with the
+// alternates.
+func (s *FileObjectStore) SetDeltaBaseCacheLimit(limit int64) {
+	s.deltaBaseCache.setMaxSize(limit)
+}
+
+// SetPackRescanInterval sets the minimum time between two rescans of the pack
+// directory triggered by objects that are not found, for the store and its
+// alternates. A negative interval disables these rescans.
+func (s *FileObjectStore) SetPackRescanInterval(d time.Duration) {
+	s.mu.Lock()
+	s.rescanInterval = d
+	s.mu.Unlock()
+	for _, a := range s.alternates {
+		a.SetPackRescanInterval(d)
+	}
+}
+
+// Rescan scans the pack directory again, picking up new packs and dropping
+// removed ones.
+func (s *FileObjectStore) Rescan() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rescan()
+}
+
+// rescan replaces the pack set. Packs that are still present are kept, so
+// their open files and cached delta bases are reused. s.mu must be held.
+func (s *FileObjectStore) rescan() error {
+	prev := s.packSet
+	old := prev
+	if old == nil {
+		old = &packSet{}
+	}
+	byId := make(map[string]*pack, len(old.packs))
+	for _, p := range old.packs {
+		byId[p.id] = p
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, "pack"))
+	if err != nil {
+		return err
+	}
+	ps := &packSet{}
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".pack") {
+			continue
+		}
+
+		id := info.Name()[:len(info.Name())-5]
+		p := byId[id]
+		if p == nil || p.missing() {
+			p = &pack{store: s, id: id}
+		}
+		ps.packs = append(ps.packs, p)
+	}
+
+	if err := ps.openMultiPackIndex(s.dir, s.format, old.midx); err != nil {
+		return err
+	}
+
+	// Other goroutines may still be reading from the packs that were
+	// dropped, so they are closed once the old set is released by them.
+	ps.refs = 1
+	for _, p := range ps.packs {
+		atomic.AddInt32(&p.refs, 1)
+	}
+	if ps.midx != nil {
+		atomic.AddInt32(&ps.midx.refs, 1)
+	}
+	s.packSet = ps
+	s.lastScan = time.Now()
+	if prev != nil {
+		prev.release()
+	}
+	return nil
+}
+
+// openMultiPackIndex opens the multi-pack-index, if any, reusing old if the
+// file did not change. Like git, a multi-pack-index naming a pack that does
+// not exist is ignored.
+func (ps *packSet) openMultiPackIndex(dir string, format ObjectFormat, old *multiPackIndex) error {
+	ps.uncovered = ps.packs
+
+	path := filepath.Join(dir, "pack", "multi-pack-index")
+	m := old
+	if m == nil || !m.unchanged(path) {
+		var err error
+		m, err = openMultiPackIndex(path, format)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	byId := make(map[string]*pack, len(ps.packs))
+	for _, p := range ps.packs {
+		byId[p.id] = p
+	}
+	covered := make(map[*pack]bool, len(m.packNames))
+	var midxPacks []*pack
+	for _, name := range m.packNames {
+		p, ok := byId[name]
+		if !ok {
+			if m != old {
+				m.Close()
+			}
+			return nil
+		}
+		covered[p] = true
+		midxPacks = append(midxPacks, p)
+	}
+
+	ps.midx, ps.midxPacks, ps.uncovered = m, midxPacks, nil
+	for _, p := range ps.packs {
+		if !covered[p] {
+			ps.uncovered = append(ps.uncovered, p)
+		}
+	}
+	return nil
+}
+
+// acquire returns the packs as of the last scan. They stay open until the
+// set is released, even if a rescan drops them meanwhile.
+func (s *FileObjectStore) acquire() *packSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	atomic.AddInt32(&s.packSet.refs, 1)
+	return s.packSet
+}
+
+// release lets go of the set, closing the packs and multi-pack-index that
+// no other set holds once the set is no longer used.
+func (ps *packSet) release() (err error) {
+	if atomic.AddInt32(&ps.refs, -1) != 0 {
+		return nil
+	}
+	for _, p := range ps.packs {
+		if atomic.AddInt32(&p.refs, -1) != 0 {
+			continue
+		}
+		if thisErr := p.Close(); thisErr != nil && err == nil {
+			err = thisErr
+		}
+	}
+	if ps.midx != nil && atomic.AddInt32(&ps.midx.refs, -1) == 0 {
+		if thisErr := ps.midx.Close(); thisErr != nil && err == nil {
+			err = thisErr
+		}
+	}
+	return
+}
+
+// withRescan calls fn with the current packs. If fn does not find the object,
+// alt is called with each alternate in turn. If none has the object either,
+// fn is called again after rescanning the pack directory, unless the last
+// rescan is too recent. A pack whose files were removed before they were
+// opened counts as not having the object.
+func (s *FileObjectStore) withRescan(id ObjectID, fn func(ps *packSet) error, alt func(a *FileObjectStore) error) error {
+	ps := s.acquire()
+	err := fn(ps)
+	ps.release()
+	if !isPackMiss(err) {
+		return err
+	}
+	for _, a := range s.alternates {
+		if altErr := alt(a); !isPackMiss(altErr) {
+			return altErr
+		}
+	}
+
+	retry, rescanErr := s.rescanAfterMiss(ps)
+	if rescanErr != nil {
+		return rescanErr
+	}
+	if retry != ps {
+		err = fn(retry)
+	}
+	retry.release()
+	if os.IsNotExist(err) {
+		return ObjectNotFound(id)
+	}
+	return err
+}
+
+// rescanAfterMiss rescans the packs after a lookup in ps found nothing,
+// unless another goroutine rescanned meanwhile or the last rescan is more
+// recent than the rescan interval. It returns the current pack set, which
+// the caller must release.
+func (s *FileObjectStore) rescanAfterMiss(ps *packSet) (*packSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.packSet != ps:
+		// Another goroutine rescanned meanwhile.
+	case s.rescanInterval >= 0 && time.Since(s.lastScan) >= s.rescanInterval:
+		if err := s.rescan(); err != nil {
+			return nil, err
+		}
+	}
+	atomic.AddInt32(&s.packSet.refs, 1)
+	return s.packSet, nil
+}
+
+func isPackMiss(err error) bool {
+	if _, ok := err.(ObjectNotFound); ok {
+		return true
+	}
+	return os.IsNotExist(err)
+}
+
+func (s *FileObjectStore) looseObjectPath(id ObjectID) string {
+	hex := id.String()
+	return filepath.Join(s.dir, hex[:2], hex[2:])
+}
+
+func (s *FileObjectStore) Get(id ObjectID, metaOnly bool) (*Object, error) {
+	return s.get(id, metaOnly, 0)
+}
+
+// get is Get for the base of a delta chain that is depth deltas long so far,
+// so that maxDeltaDepth also bounds chains of REF_DELTA objects spanning
+// several packs.
+func (s *FileObjectStore) get(id ObjectID, metaOnly bool, depth int) (*Object, error) {
+	o, err := readLooseObject(s.looseObjectPath(id), metaOnly)
+	if err == nil {
+		return o, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = s.withRescan(id, func(ps *packSet) error {
+		p, offset, err := ps.find(id)
+		if err != nil {
+			return err
+		}
+		o, err = p.objectAtDepth(offset, metaOnly, depth)
+		return err
+	}, func(a *FileObjectStore) error {
+		o, err = a.get(id, metaOnly, depth)
+		return err
+	})
+	return o, err
+}
+
+// find returns the pack holding the object and the object's offset in it.
+func (ps *packSet) find(id ObjectID) (*pack, uint64, error) {
+	if ps.midx != nil {
+		i, offset, err := ps.midx.find(id)
+		if err == nil {
+			return ps.midxPacks[i], offset, nil
+		}
+		if _, ok := err.(ObjectNotFound); !ok {
+			return nil, 0, err
+		}
+	}
+
+	for _, p := range ps.uncovered {
+		offset, err := p.find(id)
+		if err != nil {
+			if isPackMiss(err) {
+				continue
+			}
+			return nil, 0, err
+		}
+		return p, offset, nil
+	}
+
+	return nil, 0, ObjectNotFound(id)
+}
+
+func (s *FileObjectStore) Has(id ObjectID) (bool, error) {
+	if isFile(s.looseObjectPath(id)) {
+		return true, nil
+	}
+
+	err := s.withRescan(id, func(ps *packSet) error {
+		_, _, err := ps.find(id)
+		return err
+	}, func(a *FileObjectStore) error {
+		ok, err := a.Has(id)
+		if err == nil && !ok {
+			err = ObjectNotFound(id)
+		}
+		return err
+	})
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := err.(ObjectNotFound); !ok {
+		return false, err
+	}
+	return false, nil
+}
+
+// Put writes the object into the loose object database.
+// If the object already exists in the database, it is not overwritten, though
+// the compression is still performed.
+func (s *FileObjectStore) Put(objectType ObjectType, r io.ReadSeeker) (ObjectID, error) {
+	fd, err := ioutil.TempFile(s.dir, ".gogit_")
+	if err != nil {
+		return "", fmt.Errorf("failed to make tmpfile: %v", err)
+	}
+
+	id, err := StoreObjectHash(s.format, objectType, fd, r)
+	if err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return "", err
+	}
+	fd.Close() // Not deferred, intentionally.
+
+	objectPath := s.looseObjectPath(id)
+	if _, err = os.Stat(objectPath); err == nil {
+		// Object already exists. Delete the temporary file.
+		err = os.Remove(fd.Name())
+		if err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	err = os.Mkdir(filepath.Dir(objectPath), 0775)
+	if err != nil && !os.IsExist(err) {
+		// Failed to create the directory, and not because it already exists.
+		return "", err
+	}
+
+	err = os.Rename(fd.Name(), objectPath)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ForEach visits the loose objects first, then the objects of every pack.
+// Objects of alternates are not visited.
+func (s *FileObjectStore) ForEach(fn func(id ObjectID) error) error {
+	if err := s.forEachLoose(fn); err != nil {
+		return err
+	}
+	ps := s.acquire()
+	defer ps.release()
+	for _, p := range ps.packs {
+		if err := p.forEach(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachLoose calls fn for every loose object in the store.
+func (s *FileObjectStore) forEachLoose(fn func(id ObjectID) error) error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.IsDir() || len(info.Name()) != 2 {
+			continue
+		}
+		if _, err := hex.DecodeString(info.Name()); err != nil {
+			continue
+		}
+
+		names, err := ioutil.ReadDir(filepath.Join(s.dir, info.Name()))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			hex := info.Name() + name.Name()
+			if len(hex) != 2*s.format.Size() || !IsObjectIDHex(hex) {
+				continue
+			}
+			if err := fn(ObjectIDHex(hex)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reachabilityBitmap returns the reachability bitmap of the first pack that
+// has one, or nil. Like git, only a single bitmap is used. The bitmap can be
+// used until release is called.
+func (s *FileObjectStore) reachabilityBitmap() (b *packBitmap, release func(), err error) {
+	ps := s.acquire()
+	for _, p := range ps.packs {
+		if b, err = p.reachabilityBitmap(); err != nil || b != nil {
+			break
+		}
+	}
+	if b == nil {
+		ps.release()
+		return nil, func() {}, err
+	}
+	var once sync.Once
+	return b, func() { once.Do(func() { ps.release() }) }, nil
+}
+
+func (s *FileObjectStore) Close() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Packs still being read are closed when the reads are done. The
+	// closed store finds no packed objects.
+	err = s.packSet.release()
+	s.packSet = &packSet{refs: 1}
+	s.rescanInterval = -1
+	for _, a := range s.alternates {
+		if thisErr := a.Close(); thisErr != nil && err == nil {
+			err = thisErr
+		}
+	}
+	return
+}
+
+// Reader streams loose objects and undeltified packed objects straight from
+// zlib. Deltified packed objects are reconstructed in memory.
+func (s *FileObjectStore) Reader(id ObjectID) (*ObjectReader, error) {
+	r, err := openLooseObject(s.looseObjectPath(id))
+	if err == nil {
+		return r, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = s.withRescan(id, func(ps *packSet) error {
+		p, offset, err := ps.find(id)
+		if err != nil {
+			return err
+		}
+		r, err = p.readerAtOffset(offset)
+		return err
+	}, func(a *FileObjectStore) error {
+		r, err = a.Reader(id)
+		return err
+	})
+	return r, err
+}
+
+func readLooseObject(path string, metaOnly bool) (*Object, error) {
+	r, err := openLooseObject(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if metaOnly {
+		return &Object{r.Type, r.Size, nil}, nil
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{r.Type, r.Size, data}, nil
+}
+
+func openLooseObject(path string) (*ObjectReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(zr)
+	typ, size, err := readLooseObjectHeader(br)
+	if err != nil {
+		zr.Close()
+		f.Close()
+		return nil, err
+	}
+
+	return &ObjectReader{typ, size, &sizedReader{
+		r:       br,
+		remain:  size,
+		closers: []io.Closer{zr, f},
+	}}, nil
+}
+
+// readLooseObjectHeader reads the '<type> <size>\x00' header of an inflated
+// loose object.
+func readLooseObjectHeader(br *bufio.Reader) (ObjectType, uint64, error) {
+	typStr, err := br.ReadString(' ')
+	if err != nil {
+		return 0, 0, err
+	}
+	var typ ObjectType
+	switch typStr[:len(typStr)-1] {
+	case "blob":
+		typ = ObjectBlob
+	case "tree":
+		typ = ObjectTree
+	case "commit":
+		typ = ObjectCommit
+	case "tag":
+		typ = ObjectTag
+	}
+
+	sizeStr, err := br.ReadString(0)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := strconv.ParseUint(sizeStr[:len(sizeStr)-1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return typ, size, nil
+}
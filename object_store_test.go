@@ -0,0 +1,397 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileObjectStore(t *testing.T) {
+	s, err := OpenFileObjectStore("testdata/repo/objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, id := range []string{
+		"30d74d258442c7c65512eafab474568dd706c430", // packed
+		"d76bde4f5d1ed609dc82d8cd7d216d893830f1c9", // loose
+	} {
+		ok, err := s.Has(ObjectIDHex(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("expected store to have %s", id)
+		}
+	}
+	if ok, _ := s.Has(ObjectIDHex("0000000000000000000000000000000000000000")); ok {
+		t.Error("expected store not to have the null id")
+	}
+
+	seen := map[ObjectID]bool{}
+	err = s.ForEach(func(id ObjectID) error {
+		seen[id] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seen[ObjectIDHex("d76bde4f5d1ed609dc82d8cd7d216d893830f1c9")] || !seen[ObjectIDHex("8b61789a76de9edaa49b2529d3aaa302ba238c0b")] {
+		t.Error("ForEach missed loose or packed objects")
+	}
+}
+
+func TestFileObjectStorePut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "pack"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFileObjectStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.Put(ObjectBlob, bytes.NewReader([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != ObjectIDHex("30d74d258442c7c65512eafab474568dd706c430") {
+		t.Errorf("wrong id %s", id)
+	}
+	o, err := s.Get(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Type != ObjectBlob || string(o.Data) != "test" {
+		t.Errorf("wrong object %v", o)
+	}
+}
+
+type countingStore struct {
+	ObjectStore
+	gets int
+}
+
+func (s *countingStore) Get(id ObjectID, metaOnly bool) (*Object, error) {
+	s.gets++
+	return s.ObjectStore.Get(id, metaOnly)
+}
+
+func TestRepositoryObjectStore(t *testing.T) {
+	fs, err := OpenFileObjectStore("testdata/repo/objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countingStore{ObjectStore: fs}
+	r, err := OpenRepositoryWithOptions("testdata/repo", RepositoryOptions{ObjectStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	testObject(t, r, "30d74d258442c7c65512eafab474568dd706c430", "test")
+	if store.gets != 1 {
+		t.Errorf("expected 1 Get, got %d", store.gets)
+	}
+}
+
+func TestObjectReader(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	for id, content := range map[string]string{
+		"30d74d258442c7c65512eafab474568dd706c430": "test",          // packed
+		"d76bde4f5d1ed609dc82d8cd7d216d893830f1c9": "test unpacked", // loose
+	} {
+		or, err := r.ObjectReader(ObjectIDHex(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(or)
+		or.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if or.Type != ObjectBlob || or.Size != uint64(len(content)) || string(data) != content {
+			t.Errorf("%s: got %v %d %q", id, or.Type, or.Size, data)
+		}
+	}
+
+	c, err := r.GetCommitOfBranch("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.GetBlobByPath("test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, err := b.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close()
+	data, err := ioutil.ReadAll(br)
+	if err != nil || string(data) != "test changed" {
+		t.Errorf("wrong blob contents %q, %v", data, err)
+	}
+}
+
+// writeTestPack writes a pack of the objects with the given ids of r into
+// the objects directory dir, returning the pack's name.
+func writeTestPack(t *testing.T, r *Repository, dir string, ids ...ObjectID) string {
+	var pack, idx bytes.Buffer
+	name, err := NewPackWriter(r, PackWriterOptions{}).WritePack(ids, &pack, &idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, "pack", "pack-"+name)
+	if err := ioutil.WriteFile(base+".idx", idx.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(base+".pack", pack.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+	return "pack-" + name
+}
+
+func TestFileObjectStoreRescan(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+	s, err := OpenFileObjectStore(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Another process adds a pack.
+	src := openTestRepo(t, "repo")
+	defer src.Close()
+	id := ObjectIDHex("8b61789a76de9edaa49b2529d3aaa302ba238c0b")
+	writeTestPack(t, src, s.Dir(), id)
+
+	s.SetPackRescanInterval(time.Hour)
+	if _, err := s.Get(id, false); err != ObjectNotFound(id) {
+		t.Errorf("expected no rescan within the interval, got %v", err)
+	}
+	s.SetPackRescanInterval(0)
+	if _, err := s.Get(id, false); err != nil {
+		t.Errorf("expected the new pack to be found, got %v", err)
+	}
+	if n := len(currentPacks(t, s).packs); n != 2 {
+		t.Errorf("expected 2 packs, got %d", n)
+	}
+}
+
+func TestFileObjectStoreRepacked(t *testing.T) {
+	dir := copyTestRepo(t, "repo5")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := r.ObjectStore().(*FileObjectStore)
+	s.SetPackRescanInterval(0)
+
+	var ids []ObjectID
+	if err := s.ForEach(func(id ObjectID) error {
+		ids = append(ids, id)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read an object, so that the pack of A is open while it is removed,
+	// and hold on to the packs like a reader that is not done yet.
+	if _, err := s.Get(ObjectIDHex(repo5A), false); err != nil {
+		t.Fatal(err)
+	}
+	held := s.acquire()
+	var open []*pack
+	for _, p := range held.packs {
+		if p.packFile != nil {
+			open = append(open, p)
+		}
+	}
+	if len(open) == 0 {
+		t.Fatal("expected an open pack")
+	}
+
+	// Like `git gc`, write a single pack and remove the others, the
+	// multi-pack-index and the loose objects.
+	name := writeTestPack(t, r, filepath.Join(dir, "objects"), ids...)
+	old, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*"))
+	for _, path := range old {
+		if !strings.Contains(path, name) {
+			os.Remove(path)
+		}
+	}
+	os.RemoveAll(filepath.Join(dir, "objects", "8b"))
+
+	for _, id := range ids {
+		if _, err := s.Get(id, false); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+	if n := len(currentPacks(t, s).packs); n != 1 {
+		t.Errorf("expected 1 pack, got %d", n)
+	}
+
+	// The removed packs are closed once the last reader is done, rather
+	// than with the store.
+	for _, p := range open {
+		if _, err := p.packFile.Stat(); err != nil {
+			t.Errorf("expected %s to be open while it is read, got %v", p.id, err)
+		}
+	}
+	held.release()
+	for _, p := range open {
+		if _, err := p.packFile.Stat(); err == nil {
+			t.Errorf("expected %s to be closed", p.id)
+		}
+	}
+}
+
+func TestFileObjectStoreAlternates(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+
+	// a borrows from b through a relative path, b from the repository, and
+	// the repository from a, which must not loop.
+	objects := filepath.Join(dir, "objects")
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	for path, alternates := range map[string]string{
+		a:       "# comment\n\n../b\n",
+		b:       "\"" + objects + "\"\n",
+		objects: a + "\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(path, "info"), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(path, "pack"), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, "info", "alternates"), []byte(alternates), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := OpenFileObjectStore(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if got := s.Alternates(); len(got) != 2 || got[0] != b || got[1] != objects {
+		t.Errorf("expected alternates %s and %s, got %v", b, objects, got)
+	}
+	id := ObjectIDHex(repo4F)
+	if ok, err := s.Has(id); !ok || err != nil {
+		t.Errorf("expected %s in an alternate, got %v, %v", id, ok, err)
+	}
+	o, err := s.Get(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Type != ObjectCommit {
+		t.Errorf("expected a commit, got %v", o.Type)
+	}
+	missing := ObjectIDHex("0000000000000000000000000000000000000001")
+	if _, err := s.Get(missing, false); err != ObjectNotFound(missing) {
+		t.Errorf("expected ObjectNotFound, got %v", err)
+	}
+
+	// The alternates share the delta base cache and rescan interval.
+	s.SetPackRescanInterval(time.Hour)
+	for _, alt := range s.alternates {
+		if alt.deltaBaseCache != s.deltaBaseCache {
+			t.Errorf("%s: expected the delta base cache of the store", alt.dir)
+		}
+		if alt.rescanInterval != time.Hour {
+			t.Errorf("%s: expected a rescan interval of an hour, got %v", alt.dir, alt.rescanInterval)
+		}
+	}
+}
+
+func TestAlternateObjectDirectoriesEnv(t *testing.T) {
+	objects, err := filepath.Abs("testdata/repo4/objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("GIT_ALTERNATE_OBJECT_DIRECTORIES", os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"))
+	os.Setenv("GIT_ALTERNATE_OBJECT_DIRECTORIES", objects)
+
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+	if _, err := r.GetCommit(repo4F); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyObjects(t *testing.T) {
+	dir := copyTestRepo(t, "repo")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepositoryWithOptions(dir, RepositoryOptions{VerifyObjects: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Replace an object's file with the file of another object.
+	s := r.ObjectStore().(*FileObjectStore)
+	good, err := s.Put(ObjectBlob, strings.NewReader("good\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := s.Put(ObjectBlob, strings.NewReader("bad\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(s.looseObjectPath(bad), s.looseObjectPath(good)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ObjectCorrupt{Id: good, Actual: bad}
+	if _, err := r.Object(good); err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+	or, err := r.ObjectReader(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer or.Close()
+	if _, err := ioutil.ReadAll(or); err != want {
+		t.Errorf("expected %v from the reader, got %v", want, err)
+	}
+
+	// Intact objects, packed and loose, read as usual.
+	if _, err := r.GetCommit("40b7c29973f5ff265a241f29c8154fa05594454f"); err != nil {
+		t.Error(err)
+	}
+	or, err = r.ObjectReader(ObjectIDHex("d76bde4f5d1ed609dc82d8cd7d216d893830f1c9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer or.Close()
+	if data, err := ioutil.ReadAll(or); err != nil || string(data) != "test unpacked" {
+		t.Errorf("expected %q, got %q, %v", "test unpacked", data, err)
+	}
+}
+
+// currentPacks returns the packs of the last scan of s, which are released
+// when the test ends.
+func currentPacks(t *testing.T, s *FileObjectStore) *packSet {
+	ps := s.acquire()
+	t.Cleanup(func() { ps.release() })
+	return ps
+}
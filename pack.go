@@ -6,15 +6,20 @@ import (
 	"compress/zlib"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 type pack struct {
-	repo              *Repository
+	store             *FileObjectStore
 	id                string
 	indexFile         *os.File
 	packFile          *os.File
@@ -22,12 +27,31 @@ type pack struct {
 	packFileErr       error
 	openIndexFileOnce sync.Once
 	openPackFileOnce  sync.Once
+
+	bitmap         *packBitmap
+	bitmapErr      error
+	openBitmapOnce sync.Once
+
+	order     *packOrder
+	orderErr  error
+	orderOnce sync.Once
+
+	// vanished is set to 1 if the pack's files were removed before they
+	// could be opened.
+	vanished int32
+
+	// refs counts the pack sets of the store that hold the pack. Its files
+	// are closed when the last one lets go of it.
+	refs int32
 }
 
 func (p *pack) indexFileReader() (io.ReaderAt, error) {
 	p.openIndexFileOnce.Do(func() {
-		f, err := os.Open(filepath.Join(p.repo.Path, "objects", "pack", p.id+".idx"))
+		f, err := os.Open(filepath.Join(p.store.dir, "pack", p.id+".idx"))
 		if err != nil {
+			if os.IsNotExist(err) {
+				atomic.StoreInt32(&p.vanished, 1)
+			}
 			p.indexFileErr = err
 			return
 		}
@@ -46,8 +70,11 @@ func (p *pack) indexFileReader() (io.ReaderAt, error) {
 
 func (p *pack) packFileReader() (io.ReaderAt, error) {
 	p.openPackFileOnce.Do(func() {
-		f, err := os.Open(filepath.Join(p.repo.Path, "objects", "pack", p.id+".pack"))
+		f, err := os.Open(filepath.Join(p.store.dir, "pack", p.id+".pack"))
 		if err != nil {
+			if os.IsNotExist(err) {
+				atomic.StoreInt32(&p.vanished, 1)
+			}
 			p.packFileErr = err
 			return
 		}
@@ -75,52 +102,146 @@ func (p *pack) Close() (err error) {
 			err = thisErr
 		}
 	}
+	if p.bitmap != nil {
+		if thisErr := p.bitmap.Close(); thisErr != nil && err == nil {
+			err = thisErr
+		}
+	}
 	return
 }
 
-func (p *pack) object(id ObjectID, metaOnly bool) (*Object, error) {
-	r, err := p.indexFileReader()
+// missing reports whether the pack's files were found to be removed before
+// they could be opened.
+func (p *pack) missing() bool {
+	return atomic.LoadInt32(&p.vanished) != 0
+}
+
+// reachabilityBitmap returns the pack's reachability bitmap, or nil if the
+// pack has none.
+func (p *pack) reachabilityBitmap() (*packBitmap, error) {
+	p.openBitmapOnce.Do(func() {
+		p.bitmap, p.bitmapErr = openPackBitmap(p)
+		if os.IsNotExist(p.bitmapErr) {
+			p.bitmapErr = nil
+		}
+	})
+	return p.bitmap, p.bitmapErr
+}
+
+// checksum returns the checksum of the pack file, as recorded in the trailer
+// of its index.
+func (p *pack) checksum() ([]byte, error) {
+	if _, err := p.indexFileReader(); err != nil {
+		return nil, err
+	}
+	info, err := p.indexFile.Stat()
 	if err != nil {
 		return nil, err
 	}
+	size := p.store.format.Size()
+	return readBytesAt(p.indexFile, info.Size()-2*int64(size), size), nil
+}
 
-	fanoutTableStart := int64(8)
-	nameTableStart := fanoutTableStart + 4*256
+// indexLayout holds the offsets of the tables in a version 2 index file.
+// SHA-256 repositories use the same layout, with longer object IDs.
+type indexLayout struct {
+	idLen                int64
+	numObjects           uint32
+	fanoutTableStart     int64
+	nameTableStart       int64
+	offsetTableStart     int64
+	highOffsetTableStart int64
+}
 
-	numObjects := binary.BigEndian.Uint32(readBytesAt(r, fanoutTableStart+4*255, 4))
+func readIndexLayout(r io.ReaderAt, format ObjectFormat) indexLayout {
+	var l indexLayout
+	l.idLen = int64(format.Size())
+	l.fanoutTableStart = 8
+	l.nameTableStart = l.fanoutTableStart + 4*256
+	l.numObjects = binary.BigEndian.Uint32(readBytesAt(r, l.fanoutTableStart+4*255, 4))
+
+	checksumTableStart := l.nameTableStart + l.idLen*int64(l.numObjects)
+	l.offsetTableStart = checksumTableStart + 4*int64(l.numObjects)
+	l.highOffsetTableStart = l.offsetTableStart + 4*int64(l.numObjects)
+	return l
+}
 
-	checksumTableStart := nameTableStart + 20*int64(numObjects)
-	offsetTableStart := checksumTableStart + 4*int64(numObjects)
-	highOffsetTableStart := offsetTableStart + 4*int64(numObjects)
-	_ = highOffsetTableStart
+// id returns the object ID at position i of the name table.
+func (l indexLayout) id(r io.ReaderAt, i uint32) ObjectID {
+	return ObjectID(readBytesAt(r, l.nameTableStart+l.idLen*int64(i), int(l.idLen)))
+}
+
+// find returns the offset of the object in the pack file.
+func (p *pack) find(id ObjectID) (uint64, error) {
+	r, err := p.indexFileReader()
+	if err != nil {
+		return 0, err
+	}
+
+	l := readIndexLayout(r, p.store.format)
+	index, err := p.findIndex(r, l, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return p.offsetOf(r, l, index), nil
+}
+
+// findIndex returns the position of the object in the index's sorted name
+// table.
+func (p *pack) findIndex(r io.ReaderAt, l indexLayout, id ObjectID) (uint32, error) {
+	if l.numObjects == 0 || int64(len(id)) != l.idLen {
+		return 0, ObjectNotFound(id)
+	}
 
 	firstByte := id[0]
 	min := uint32(0)
 	if firstByte > 0 {
-		min = binary.BigEndian.Uint32(readBytesAt(r, fanoutTableStart+4*int64(firstByte-1), 4))
+		min = binary.BigEndian.Uint32(readBytesAt(r, l.fanoutTableStart+4*int64(firstByte-1), 4))
 	}
-	max := binary.BigEndian.Uint32(readBytesAt(r, fanoutTableStart+4*int64(firstByte), 4)) - 1
-
-	index, err := binarySearch(r, nameTableStart, min, max, id)
-	if err != nil {
-		return nil, err
+	max := binary.BigEndian.Uint32(readBytesAt(r, l.fanoutTableStart+4*int64(firstByte), 4))
+	if min == max {
+		return 0, ObjectNotFound(id)
 	}
 
-	offset := uint64(binary.BigEndian.Uint32(readBytesAt(r, offsetTableStart+4*int64(index), 4)))
+	return binarySearch(r, l.nameTableStart, min, max-1, id)
+}
+
+func (p *pack) offsetOf(r io.ReaderAt, l indexLayout, index uint32) uint64 {
+	offset := uint64(binary.BigEndian.Uint32(readBytesAt(r, l.offsetTableStart+4*int64(index), 4)))
 	if offset&(1<<31) != 0 {
 		highOffsetIndex := int64(offset &^ (1 << 31))
-		offset = binary.BigEndian.Uint64(readBytesAt(r, highOffsetTableStart+8*highOffsetIndex, 8))
+		offset = binary.BigEndian.Uint64(readBytesAt(r, l.highOffsetTableStart+8*highOffsetIndex, 8))
 	}
+	return offset
+}
 
-	o, err := p.objectAtOffset(offset, metaOnly)
+// forEach calls fn for every object in the index, in id order.
+func (p *pack) forEach(fn func(id ObjectID) error) error {
+	r, err := p.indexFileReader()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return o, nil
+	l := readIndexLayout(r, p.store.format)
+	for i := uint32(0); i < l.numObjects; i++ {
+		if err := fn(l.id(r, i)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (p *pack) objectAtOffset(offset uint64, metaOnly bool) (*Object, error) {
+	return p.objectAtDepth(offset, metaOnly, 0)
+}
+
+// objectAtDepth reads the object at offset, which is the base of a delta
+// chain of the given length.
+func (p *pack) objectAtDepth(offset uint64, metaOnly bool, depth int) (*Object, error) {
+	if depth > maxDeltaDepth {
+		return nil, fmt.Errorf("%v: delta chain too long", ErrCorruptPack)
+	}
 	r, err := p.packFileReader()
 	if err != nil {
 		return nil, err
@@ -128,12 +249,10 @@ func (p *pack) objectAtOffset(offset uint64, metaOnly bool) (*Object, error) {
 
 	br := bufio.NewReader(io.NewSectionReader(r, int64(offset), math.MaxInt64-int64(offset))) // avoid overflow
 
-	x, err := binary.ReadUvarint(br)
+	typ, size, err := readPackObjectHeader(br)
 	if err != nil {
 		return nil, err
 	}
-	typ := ObjectType(x & 0x70)
-	size := x&^0x7f>>3 + x&0xf
 
 	switch typ {
 	case ObjectCommit, ObjectTree, ObjectBlob, ObjectTag:
@@ -155,17 +274,25 @@ func (p *pack) objectAtOffset(offset uint64, metaOnly bool) (*Object, error) {
 			if err != nil {
 				return nil, err
 			}
-			base, err = p.objectAtOffset(offset-relOffset, false)
+			if relOffset == 0 || relOffset > offset {
+				return nil, fmt.Errorf("%v: delta base offset out of range", ErrCorruptPack)
+			}
+			base, err = p.deltaBase(offset-relOffset, depth+1)
 			if err != nil {
 				return nil, err
 			}
 
 		case objectRefDelta:
-			id := make([]byte, 20)
+			id := make([]byte, p.store.format.Size())
 			if _, err := io.ReadFull(br, id); err != nil {
 				return nil, err
 			}
-			base, err = p.repo.object(ObjectID(id), false)
+			var baseOffset uint64
+			if baseOffset, err = p.find(ObjectID(id)); err == nil {
+				base, err = p.deltaBase(baseOffset, depth+1)
+			} else {
+				base, err = p.store.get(ObjectID(id), false, depth+1)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -176,10 +303,10 @@ func (p *pack) objectAtOffset(offset uint64, metaOnly bool) (*Object, error) {
 			return nil, err
 		}
 
-		_, n := binary.Uvarint(d) // length of base object
-		d = d[n:]
-		resultObjectLength, n := binary.Uvarint(d)
-		d = d[n:]
+		resultObjectLength, d, err := readDeltaHeader(d, len(base.Data))
+		if err != nil {
+			return nil, err
+		}
 		if metaOnly {
 			return &Object{base.Type, resultObjectLength, nil}, nil
 		}
@@ -195,6 +322,239 @@ func (p *pack) objectAtOffset(offset uint64, metaOnly bool) (*Object, error) {
 	}
 }
 
+// packOrder lists the objects of a pack in the order of the pack file, like
+// git's reverse index, so that the extent of an object in the file and the
+// id of the object at an offset can be found.
+type packOrder struct {
+	offsets   []uint64 // ascending
+	positions []uint32 // index positions, by offset
+}
+
+// packOrder returns the order of the pack's objects, read from the index
+// the first time it is needed.
+func (p *pack) packOrder() (*packOrder, error) {
+	p.orderOnce.Do(func() {
+		r, err := p.indexFileReader()
+		if err != nil {
+			p.orderErr = err
+			return
+		}
+		l := readIndexLayout(r, p.store.format)
+		o := &packOrder{
+			offsets:   make([]uint64, l.numObjects),
+			positions: make([]uint32, l.numObjects),
+		}
+		for i := range o.positions {
+			o.positions[i] = uint32(i)
+		}
+		sort.Slice(o.positions, func(i, j int) bool {
+			return p.offsetOf(r, l, o.positions[i]) < p.offsetOf(r, l, o.positions[j])
+		})
+		for i, pos := range o.positions {
+			o.offsets[i] = p.offsetOf(r, l, pos)
+		}
+		p.order = o
+	})
+	return p.order, p.orderErr
+}
+
+// packedDelta is the compressed data of a deltified object in a pack, which
+// can be copied to another pack as is.
+type packedDelta struct {
+	p          *pack
+	base       ObjectID
+	size       uint64 // of the inflated delta
+	start, end int64  // the whole entry in the pack file
+	data       int64  // start of the compressed delta
+	crc        uint32 // of the whole entry, from the index
+}
+
+// deltaAt returns the delta stored at offset, or nil if the object there is
+// not deltified.
+func (p *pack) deltaAt(offset uint64) (*packedDelta, error) {
+	r, err := p.packFileReader()
+	if err != nil {
+		return nil, err
+	}
+	order, err := p.packOrder()
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(order.offsets), func(i int) bool { return order.offsets[i] >= offset })
+	if i == len(order.offsets) || order.offsets[i] != offset {
+		return nil, fmt.Errorf("%v: no object at offset %d", ErrCorruptPack, offset)
+	}
+	end := int64(0)
+	if i+1 < len(order.offsets) {
+		end = int64(order.offsets[i+1])
+	} else {
+		info, err := p.packFile.Stat()
+		if err != nil {
+			return nil, err
+		}
+		end = info.Size() - int64(p.store.format.Size())
+	}
+
+	br := &countingByteReader{r: bufio.NewReader(io.NewSectionReader(r, int64(offset), end-int64(offset)))}
+	typ, size, err := readPackObjectHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	d := &packedDelta{p: p, size: size, start: int64(offset), end: end}
+	switch typ {
+	case objectOfsDelta:
+		relOffset, err := readOffset(br)
+		if err != nil {
+			return nil, err
+		}
+		if relOffset == 0 || relOffset > offset {
+			return nil, fmt.Errorf("%v: delta base offset out of range", ErrCorruptPack)
+		}
+		j := sort.Search(i, func(j int) bool { return order.offsets[j] >= offset-relOffset })
+		if j == i || order.offsets[j] != offset-relOffset {
+			return nil, fmt.Errorf("%v: no delta base at offset %d", ErrCorruptPack, offset-relOffset)
+		}
+		idx, _ := p.indexFileReader()
+		d.base = readIndexLayout(idx, p.store.format).id(idx, order.positions[j])
+	case objectRefDelta:
+		id := make([]byte, p.store.format.Size())
+		if _, err := io.ReadFull(br, id); err != nil {
+			return nil, err
+		}
+		d.base = ObjectID(id)
+	default:
+		return nil, nil
+	}
+	d.data = int64(offset) + br.n
+
+	idx, _ := p.indexFileReader()
+	l := readIndexLayout(idx, p.store.format)
+	crcTableStart := l.nameTableStart + l.idLen*int64(l.numObjects)
+	d.crc = binary.BigEndian.Uint32(readBytesAt(idx, crcTableStart+4*int64(order.positions[i]), 4))
+	return d, nil
+}
+
+// verify checks the entry against the CRC-32 recorded in the index, so that
+// a corrupt delta is not copied.
+func (d *packedDelta) verify() error {
+	r, err := d.p.packFileReader()
+	if err != nil {
+		return err
+	}
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, io.NewSectionReader(r, d.start, d.end-d.start)); err != nil {
+		return err
+	}
+	if crc.Sum32() != d.crc {
+		return fmt.Errorf("%v: CRC mismatch at offset %d", ErrCorruptPack, d.start)
+	}
+	return nil
+}
+
+// compressed returns a reader of the compressed delta.
+func (d *packedDelta) compressed() (io.Reader, error) {
+	r, err := d.p.packFileReader()
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(r, d.data, d.end-d.data), nil
+}
+
+// countingByteReader counts the bytes read through it.
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *countingByteReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingByteReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+// DefaultDeltaBaseCacheLimit is the default number of bytes of inflated delta
+// bases a FileObjectStore keeps cached, matching git's default
+// core.deltaBaseCacheLimit.
+const DefaultDeltaBaseCacheLimit = 96 << 20
+
+type deltaBaseKey struct {
+	p      *pack
+	offset uint64
+}
+
+// deltaBase returns the object at offset for use as a delta base of a chain
+// of the given length, consulting the store's delta base cache first. The
+// returned object must not be modified.
+func (p *pack) deltaBase(offset uint64, depth int) (*Object, error) {
+	key := deltaBaseKey{p, offset}
+	if o, ok := p.store.deltaBaseCache.get(key); ok {
+		return o.(*Object), nil
+	}
+
+	o, err := p.objectAtDepth(offset, false, depth)
+	if err != nil {
+		return nil, err
+	}
+	p.store.deltaBaseCache.add(key, o, int64(len(o.Data)))
+	return o, nil
+}
+
+// readerAtOffset streams the object at offset in the pack file. Only
+// undeltified objects are streamed; deltas are applied in memory.
+func (p *pack) readerAtOffset(offset uint64) (*ObjectReader, error) {
+	r, err := p.packFileReader()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(r, int64(offset), math.MaxInt64-int64(offset))) // avoid overflow
+
+	typ, size, err := readPackObjectHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case ObjectCommit, ObjectTree, ObjectBlob, ObjectTag:
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectReader{typ, size, &sizedReader{
+			r:       zr,
+			remain:  size,
+			closers: []io.Closer{zr},
+		}}, nil
+
+	default:
+		o, err := p.objectAtOffset(offset, false)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectReader{o.Type, o.Size, ioutil.NopCloser(bytes.NewReader(o.Data))}, nil
+	}
+}
+
+// readPackObjectHeader reads the type and inflated size that precede every
+// object in a pack file.
+func readPackObjectHeader(br io.ByteReader) (ObjectType, uint64, error) {
+	x, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, 0, err
+	}
+	typ := ObjectType(x & 0x70)
+	size := x&^0x7f>>3 + x&0xf
+	return typ, size, nil
+}
+
 func readOffset(r io.ByteReader) (uint64, error) {
 	var offset uint64
 	for {
@@ -216,10 +576,12 @@ func readBytesAt(r io.ReaderAt, offset int64, len int) []byte {
 	return buf
 }
 
+// binarySearch looks up id in the sorted table of IDs at tableStart, whose
+// entries are as long as id.
 func binarySearch(r io.ReaderAt, tableStart int64, min, max uint32, id ObjectID) (uint32, error) {
 	for min <= max {
 		mid := min + ((max - min) / 2) // avoid overflow
-		midID := ObjectID(readBytesAt(r, tableStart+20*int64(mid), 20))
+		midID := ObjectID(readBytesAt(r, tableStart+int64(len(id))*int64(mid), len(id)))
 		if midID == id {
 			return mid, nil
 		}
@@ -245,36 +607,91 @@ func readAndDecompress(r io.Reader, inflatedSize uint64) ([]byte, error) {
 	}
 	defer zr.Close()
 
-	buf := make([]byte, inflatedSize)
-	if _, err := io.ReadFull(zr, buf); err != nil {
+	// Don't trust the size of a corrupt pack with a huge allocation up
+	// front. The extra MinRead bytes let ReadFrom see the end of the data
+	// without growing the buffer.
+	var buf bytes.Buffer
+	if inflatedSize < maxInflateGuess {
+		buf.Grow(int(inflatedSize) + bytes.MinRead)
+	} else {
+		buf.Grow(maxInflateGuess)
+	}
+	if _, err := buf.ReadFrom(io.LimitReader(zr, int64(inflatedSize))); err != nil {
 		return nil, err
 	}
-	return buf, nil
+	if uint64(buf.Len()) != inflatedSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
 }
 
+// maxInflateGuess is the most readAndDecompress allocates before seeing
+// the data.
+const maxInflateGuess = 64 << 20
+
+// maxDeltaDepth bounds the length of the delta chains objectAtOffset
+// follows, so that corrupt packs whose REF_DELTA objects refer to each
+// other in a cycle, within a pack or across packs, fail instead of
+// recursing forever.
+const maxDeltaDepth = 10000
+
+// readDeltaHeader reads the base and result sizes at the start of a delta,
+// returning the size of the result and the instructions that follow.
+func readDeltaHeader(delta []byte, baseSize int) (uint64, []byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("%v: bad delta header", ErrCorruptPack)
+	}
+	if size != uint64(baseSize) {
+		return 0, nil, fmt.Errorf("%v: delta base size mismatch", ErrCorruptPack)
+	}
+	delta = delta[n:]
+	resultSize, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("%v: bad delta header", ErrCorruptPack)
+	}
+	return resultSize, delta[n:], nil
+}
+
+// applyDelta applies the delta instructions to base, returning a result of
+// resultLen bytes. Instructions that read past the end of the delta or the
+// base, or a result of the wrong size, are reported as ErrCorruptPack.
 func applyDelta(base, delta []byte, resultLen uint64) ([]byte, error) {
-	res := make([]byte, resultLen)
-	insertPoint := res
-	for {
-		if len(delta) == 0 {
-			return res, nil
-		}
+	// Don't trust resultLen with a huge allocation up front. Deltas mostly
+	// copy each part of the base once, and the result grows if not.
+	capacity := resultLen
+	if max := uint64(len(base) + len(delta)); capacity > max {
+		capacity = max
+	}
+	res := make([]byte, 0, capacity)
+	for len(delta) > 0 {
 		opcode := delta[0]
 		delta = delta[1:]
 
 		if opcode&0x80 == 0 {
 			// copy from delta
-			copy(insertPoint, delta[:opcode])
-			insertPoint = insertPoint[opcode:]
-			delta = delta[opcode:]
+			n := int(opcode)
+			if n == 0 {
+				return nil, fmt.Errorf("%v: reserved delta opcode 0", ErrCorruptPack)
+			}
+			if n > len(delta) || uint64(len(res)+n) > resultLen {
+				return nil, fmt.Errorf("%v: delta insert out of bounds", ErrCorruptPack)
+			}
+			res = append(res, delta[:n]...)
+			delta = delta[n:]
 			continue
 		}
 
 		// copy from base
-		readNum := func(len uint) uint64 {
+		var truncated bool
+		readNum := func(size uint) uint64 {
 			var x uint64
-			for i := uint(0); i < len; i++ {
+			for i := uint(0); i < size; i++ {
 				if opcode&1 != 0 {
+					if len(delta) == 0 {
+						truncated = true
+						return 0
+					}
 					x |= uint64(delta[0]) << (i * 8)
 					delta = delta[1:]
 				}
@@ -282,13 +699,21 @@ func applyDelta(base, delta []byte, resultLen uint64) ([]byte, error) {
 			}
 			return x
 		}
-		_ = readNum
 		copyOffset := readNum(4)
 		copyLength := readNum(3)
+		if truncated {
+			return nil, fmt.Errorf("%v: truncated delta copy", ErrCorruptPack)
+		}
 		if copyLength == 0 {
 			copyLength = 1 << 16
 		}
-		copy(insertPoint, base[copyOffset:copyOffset+copyLength])
-		insertPoint = insertPoint[copyLength:]
+		if copyOffset+copyLength > uint64(len(base)) || uint64(len(res))+copyLength > resultLen {
+			return nil, fmt.Errorf("%v: delta copy out of bounds", ErrCorruptPack)
+		}
+		res = append(res, base[copyOffset:copyOffset+copyLength]...)
+	}
+	if uint64(len(res)) != resultLen {
+		return nil, fmt.Errorf("%v: delta result too short", ErrCorruptPack)
 	}
+	return res, nil
 }
@@ -0,0 +1,221 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var ErrCorruptBitmap = errors.New("corrupt pack bitmap")
+
+const bitmapOptFullDAG = 0x1
+
+// packBitmap is a pack's .bitmap file. Bit i of its bitmaps stands for the
+// i-th object of the pack in pack file order.
+type packBitmap struct {
+	p *pack
+	f *os.File
+
+	numObjects uint32
+	idxToBit   []uint32 // index position -> bit
+	bitToIdx   []uint32 // bit -> index position
+
+	commits, trees, blobs, tags bitset
+
+	entries       []bitmapEntry
+	entryByCommit map[ObjectID]int
+
+	cacheMu sync.Mutex
+	cache   map[int]bitset // decoded entry bitmaps
+}
+
+type bitmapEntry struct {
+	commit     ObjectID
+	xorOffset  int
+	ewahOffset int64
+}
+
+// openPackBitmap opens the .bitmap file of the pack, returning
+// os.ErrNotExist if there is none.
+func openPackBitmap(p *pack) (*packBitmap, error) {
+	f, err := os.Open(filepath.Join(p.store.dir, "pack", p.id+".bitmap"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := readPackBitmap(p, f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s.bitmap: %v", p.id, err)
+	}
+	return b, nil
+}
+
+func readPackBitmap(p *pack, f *os.File) (*packBitmap, error) {
+	idx, err := p.indexFileReader()
+	if err != nil {
+		return nil, err
+	}
+	l := readIndexLayout(idx, p.store.format)
+
+	// The header ends with the checksum of the pack.
+	headerLen := 12 + p.store.format.Size()
+	header := readBytesAt(f, 0, headerLen)
+	if !bytes.Equal(header[:4], []byte("BITM")) {
+		return nil, fmt.Errorf("%v: wrong signature", ErrCorruptBitmap)
+	}
+	if v := binary.BigEndian.Uint16(header[4:]); v != 1 {
+		return nil, fmt.Errorf("unsupported bitmap version %d", v)
+	}
+	options := binary.BigEndian.Uint16(header[6:])
+	if options&bitmapOptFullDAG == 0 {
+		return nil, fmt.Errorf("%v: bitmap does not cover the full DAG", ErrCorruptBitmap)
+	}
+	numEntries := binary.BigEndian.Uint32(header[8:])
+	packChecksum, err := p.checksum()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[12:], packChecksum) {
+		return nil, fmt.Errorf("%v: checksum does not match pack", ErrCorruptBitmap)
+	}
+
+	b := &packBitmap{
+		p:             p,
+		f:             f,
+		numObjects:    l.numObjects,
+		entryByCommit: make(map[ObjectID]int, numEntries),
+		cache:         make(map[int]bitset),
+	}
+	b.buildPackOrder(idx, l)
+
+	offset := int64(headerLen)
+	for _, typ := range []*bitset{&b.commits, &b.trees, &b.blobs, &b.tags} {
+		*typ, err = readEWAH(f, offset, int(b.numObjects))
+		if err != nil {
+			return nil, err
+		}
+		offset += ewahSize(f, offset)
+	}
+
+	for i := 0; i < int(numEntries); i++ {
+		entry := readBytesAt(f, offset, 6)
+		pos := binary.BigEndian.Uint32(entry)
+		xorOffset := int(entry[4])
+		if pos >= b.numObjects || xorOffset > i {
+			return nil, fmt.Errorf("%v: bad entry %d", ErrCorruptBitmap, i)
+		}
+		commit := l.id(idx, pos)
+		b.entries = append(b.entries, bitmapEntry{
+			commit:     commit,
+			xorOffset:  xorOffset,
+			ewahOffset: offset + 6,
+		})
+		b.entryByCommit[commit] = i
+		offset += 6 + ewahSize(f, offset+6)
+	}
+
+	return b, nil
+}
+
+// buildPackOrder maps between index positions and pack file order by sorting
+// the objects by offset.
+func (b *packBitmap) buildPackOrder(idx io.ReaderAt, l indexLayout) {
+	offsets := make([]uint64, l.numObjects)
+	b.bitToIdx = make([]uint32, l.numObjects)
+	for i := range offsets {
+		offsets[i] = b.p.offsetOf(idx, l, uint32(i))
+		b.bitToIdx[i] = uint32(i)
+	}
+	sort.Slice(b.bitToIdx, func(i, j int) bool {
+		return offsets[b.bitToIdx[i]] < offsets[b.bitToIdx[j]]
+	})
+
+	b.idxToBit = make([]uint32, l.numObjects)
+	for bit, pos := range b.bitToIdx {
+		b.idxToBit[pos] = uint32(bit)
+	}
+}
+
+func (b *packBitmap) Close() error {
+	return b.f.Close()
+}
+
+// bit returns the bitmap position of the object, if it is in the pack.
+func (b *packBitmap) bit(id ObjectID) (uint32, bool) {
+	idx, err := b.p.indexFileReader()
+	if err != nil {
+		return 0, false
+	}
+	pos, err := b.p.findIndex(idx, readIndexLayout(idx, b.p.store.format), id)
+	if err != nil {
+		return 0, false
+	}
+	return b.idxToBit[pos], true
+}
+
+// objectAt returns the id of the object at a bitmap position.
+func (b *packBitmap) objectAt(bit uint32) ObjectID {
+	idx, _ := b.p.indexFileReader()
+	return readIndexLayout(idx, b.p.store.format).id(idx, b.bitToIdx[bit])
+}
+
+// typeAt returns the type of the object at a bitmap position.
+func (b *packBitmap) typeAt(bit uint32) ObjectType {
+	switch {
+	case b.commits.test(bit):
+		return ObjectCommit
+	case b.trees.test(bit):
+		return ObjectTree
+	case b.blobs.test(bit):
+		return ObjectBlob
+	case b.tags.test(bit):
+		return ObjectTag
+	}
+	return 0
+}
+
+// commitBitmap returns the objects reachable from the commit, if the commit
+// has a bitmap. The result must not be modified.
+func (b *packBitmap) commitBitmap(id ObjectID) (bitset, bool, error) {
+	i, ok := b.entryByCommit[id]
+	if !ok {
+		return nil, false, nil
+	}
+	bits, err := b.entryBitmap(i)
+	return bits, err == nil, err
+}
+
+// entryBitmap decodes the bitmap of entry i, which may be stored XORed with
+// the bitmap of an earlier entry.
+func (b *packBitmap) entryBitmap(i int) (bitset, error) {
+	b.cacheMu.Lock()
+	bits, ok := b.cache[i]
+	b.cacheMu.Unlock()
+	if ok {
+		return bits, nil
+	}
+
+	e := b.entries[i]
+	bits, err := readEWAH(b.f, e.ewahOffset, int(b.numObjects))
+	if err != nil {
+		return nil, err
+	}
+	if e.xorOffset > 0 {
+		base, err := b.entryBitmap(i - e.xorOffset)
+		if err != nil {
+			return nil, err
+		}
+		bits.xor(base)
+	}
+
+	b.cacheMu.Lock()
+	b.cache[i] = bits
+	b.cacheMu.Unlock()
+	return bits, nil
+}
@@ -0,0 +1,493 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+const (
+	DefaultPackWindow = 10
+	DefaultPackDepth  = 50
+
+	packIndexLargeOffset = 0x80000000
+)
+
+// PackWriterOptions configures a PackWriter.
+type PackWriterOptions struct {
+	// Window is the number of objects before each object that are tried as
+	// its delta base, like `git pack-objects --window`. 0 means
+	// DefaultPackWindow, a negative value disables delta compression.
+	Window int
+
+	// Depth is the maximum length of delta chains. 0 means
+	// DefaultPackDepth.
+	Depth int
+}
+
+// PackWriter writes objects of a repository to a version 2 pack file and its
+// index. Objects are stored as OFS_DELTA against a similar object of the same
+// type where that is smaller, so the pack is self-contained.
+//
+// Objects are read and written one at a time, so that only the objects in
+// the delta window are held in memory. Deltas that are already in a pack of
+// the repository are copied as they are if their base is written too.
+type PackWriter struct {
+	repo   *Repository
+	window int
+	depth  int
+}
+
+// NewPackWriter returns a PackWriter reading objects from repo.
+func NewPackWriter(repo *Repository, opts PackWriterOptions) *PackWriter {
+	w := &PackWriter{repo: repo, window: opts.Window, depth: opts.Depth}
+	if w.window == 0 {
+		w.window = DefaultPackWindow
+	}
+	if w.depth <= 0 {
+		w.depth = DefaultPackDepth
+	}
+	return w
+}
+
+// PackObject is an object for PackWriter.WritePackObjects. Path is where the
+// object was found in a tree, if anywhere. Objects with similar paths are
+// tried as delta bases for each other first.
+type PackObject struct {
+	Id   ObjectID
+	Path string
+}
+
+// packEntry is an object being written to a pack. Its data, or delta against
+// base, is only set while it is written.
+type packEntry struct {
+	id   ObjectID
+	typ  ObjectType
+	data []byte
+
+	base  *packEntry // delta base, if deltified
+	delta []byte
+	depth int
+
+	offset uint64
+	crc    uint32
+}
+
+// packWriterEntry is an object to be written by a PackWriter, with what is
+// needed to order the objects.
+type packWriterEntry struct {
+	packEntry
+	size     uint64
+	nameHash uint32
+	state    int
+}
+
+const (
+	packEntryPending = iota
+	packEntryWriting
+	packEntryWritten
+)
+
+// WritePack writes the objects with the given ids to pack, and the index of
+// the pack to idx. Duplicate ids are written once. It returns the hex pack
+// checksum, which git uses to name the files pack-<checksum>.pack and
+// pack-<checksum>.idx.
+func (w *PackWriter) WritePack(ids []ObjectID, pack, idx io.Writer) (string, error) {
+	objects := make([]PackObject, len(ids))
+	for i, id := range ids {
+		objects[i].Id = id
+	}
+	return w.WritePackObjects(objects, pack, idx)
+}
+
+// WritePackObjects is like WritePack, with the paths of the objects as hints
+// for delta compression.
+func (w *PackWriter) WritePackObjects(objects []PackObject, pack, idx io.Writer) (string, error) {
+	entries, err := w.readEntries(objects)
+	if err != nil {
+		return "", err
+	}
+
+	pw := &packHashWriter{w: pack, hash: w.repo.format.New(), crc: crc32.NewIEEE()}
+	ws := &packWriteState{
+		w:    w,
+		pw:   pw,
+		zw:   zlib.NewWriter(nil),
+		byId: make(map[ObjectID]*packWriterEntry, len(entries)),
+	}
+	if s, ok := w.repo.store.(*FileObjectStore); ok && w.window >= 0 {
+		ws.packs = s.acquire()
+		defer ws.packs.release()
+	}
+	for _, e := range entries {
+		ws.byId[e.id] = e
+	}
+
+	if err := writePackHeader(pw, len(entries)); err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if err := ws.write(e); err != nil {
+			return "", err
+		}
+	}
+	written := make([]*packEntry, 0, len(entries))
+	for _, e := range entries {
+		written = append(written, &e.packEntry)
+	}
+	checksum := pw.hash.Sum(nil)
+	if _, err := pack.Write(checksum); err != nil {
+		return "", err
+	}
+
+	if err := writePackIndex(idx, w.repo.format, written, checksum); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(checksum), nil
+}
+
+// readEntries reads the type and size of the objects and sorts them for
+// delta compression: by type, by the hash of their path, then from largest
+// to smallest, so that objects are deltified against larger ones written
+// before them.
+func (w *PackWriter) readEntries(objects []PackObject) ([]*packWriterEntry, error) {
+	seen := make(map[ObjectID]bool, len(objects))
+	var entries []*packWriterEntry
+	for _, obj := range objects {
+		if seen[obj.Id] {
+			continue
+		}
+		seen[obj.Id] = true
+
+		o, err := w.repo.object(obj.Id, true)
+		if err != nil {
+			return nil, err
+		}
+		e := &packWriterEntry{size: o.Size, nameHash: packNameHash(obj.Path)}
+		e.id, e.typ = obj.Id, o.Type
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		if a.nameHash != b.nameHash {
+			return a.nameHash > b.nameHash
+		}
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		return a.id < b.id
+	})
+	return entries, nil
+}
+
+// packNameHash hashes a path like git's pack_name_hash, mostly from its last
+// characters, so that files with the same name or extension sort together.
+func packNameHash(path string) uint32 {
+	var hash uint32
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v' {
+			continue
+		}
+		hash = hash>>2 + uint32(c)<<24
+	}
+	return hash
+}
+
+// packWriteState is a pack being written by a PackWriter.
+type packWriteState struct {
+	w     *PackWriter
+	pw    *packHashWriter
+	zw    *zlib.Writer
+	packs *packSet // of the repository, for reusing deltas; nil if none
+	byId  map[ObjectID]*packWriterEntry
+
+	window []*packWindowEntry // the last objects written, newest last
+}
+
+// packWindowEntry is an object in the delta window, with the index of it as
+// a delta base once it is needed.
+type packWindowEntry struct {
+	e     *packWriterEntry
+	data  []byte
+	index *deltaIndex
+}
+
+// write writes the entry, after its delta base if that is an existing delta
+// against an object not written yet.
+func (ws *packWriteState) write(e *packWriterEntry) error {
+	if e.state != packEntryPending {
+		return nil
+	}
+	e.state = packEntryWriting
+	defer func() { e.state = packEntryWritten }()
+
+	reused, err := ws.reuseDelta(e)
+	if err != nil || reused {
+		return err
+	}
+
+	o, err := ws.w.repo.object(e.id, false)
+	if err != nil {
+		return err
+	}
+	ws.findDelta(e, o.Data)
+	e.data = o.Data
+	err = writePackObject(ws.pw, ws.zw, &e.packEntry)
+	e.data, e.delta = nil, nil
+	if err != nil {
+		return err
+	}
+
+	if ws.w.window > 0 {
+		ws.window = append(ws.window, &packWindowEntry{e: e, data: o.Data})
+		if len(ws.window) > ws.w.window {
+			ws.window[0] = nil
+			ws.window = ws.window[1:]
+		}
+	}
+	return nil
+}
+
+// reuseDelta copies the entry's delta from a pack of the repository, if it
+// is stored as a delta against an object that is written too, reports
+// whether it did.
+func (ws *packWriteState) reuseDelta(e *packWriterEntry) (bool, error) {
+	if ws.packs == nil {
+		return false, nil
+	}
+	p, offset, err := ws.packs.find(e.id)
+	if isPackMiss(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	d, err := p.deltaAt(offset)
+	if err != nil || d == nil {
+		return false, err
+	}
+	base := ws.byId[d.base]
+	if base == nil || base.state == packEntryWriting {
+		return false, nil
+	}
+	if err := ws.write(base); err != nil {
+		return false, err
+	}
+	if base.depth >= ws.w.depth || d.verify() != nil {
+		return false, nil
+	}
+	data, err := d.compressed()
+	if err != nil {
+		return false, err
+	}
+
+	e.base, e.depth = &base.packEntry, base.depth+1
+	e.offset = ws.pw.offset
+	ws.pw.crc.Reset()
+	h := appendPackObjectHeader(nil, objectOfsDelta, d.size)
+	h = appendPackOffset(h, e.offset-base.offset)
+	if _, err := ws.pw.Write(h); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(ws.pw, data); err != nil {
+		return false, err
+	}
+	e.crc = ws.pw.crc.Sum32()
+	return true, nil
+}
+
+// findDelta tries the objects in the window as the entry's delta base,
+// keeping the smallest delta. Like git, a delta must save at least half of
+// the object's size to be used.
+func (ws *packWriteState) findDelta(e *packWriterEntry, data []byte) {
+	maxSize := len(data)/2 - ws.w.repo.format.Size()
+	for i := len(ws.window) - 1; i >= 0; i-- {
+		base := ws.window[i]
+		if base.e.typ != e.typ {
+			continue
+		}
+		if base.e.depth >= ws.w.depth || maxSize <= 0 || len(base.data) < len(data)/32 {
+			continue
+		}
+
+		if base.index == nil {
+			base.index = newDeltaIndex(base.data)
+		}
+		if delta := makeDelta(base.index, data, maxSize); delta != nil {
+			e.base, e.delta, e.depth = &base.e.packEntry, delta, base.e.depth+1
+			maxSize = len(delta) - 1
+		}
+	}
+}
+
+// packHashWriter counts the bytes written to a pack and hashes them, keeping a
+// separate CRC-32 of each entry for the index.
+type packHashWriter struct {
+	w      io.Writer
+	hash   hash.Hash
+	crc    hash.Hash32
+	offset uint64
+}
+
+func (pw *packHashWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.hash.Write(p[:n])
+	pw.crc.Write(p[:n])
+	pw.offset += uint64(n)
+	return n, err
+}
+
+// writePackEntries writes a pack of the entries in their order, returning the
+// pack checksum, a hash of the given format. Delta bases must come before the
+// entries deltified against them.
+func writePackEntries(w io.Writer, format ObjectFormat, entries []*packEntry) ([]byte, error) {
+	pw := &packHashWriter{w: w, hash: format.New(), crc: crc32.NewIEEE()}
+	if err := writePackHeader(pw, len(entries)); err != nil {
+		return nil, err
+	}
+	if err := writePackObjects(pw, entries); err != nil {
+		return nil, err
+	}
+
+	checksum := pw.hash.Sum(nil)
+	if _, err := w.Write(checksum); err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// writePackHeader writes the header of a pack of n objects.
+func writePackHeader(pw *packHashWriter, n int) error {
+	header := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[8:], uint32(n))
+	_, err := pw.Write(header)
+	return err
+}
+
+// writePackObjects writes the entries, recording their offsets and CRC-32s.
+func writePackObjects(pw *packHashWriter, entries []*packEntry) error {
+	zw := zlib.NewWriter(nil)
+	for _, e := range entries {
+		if err := writePackObject(pw, zw, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePackObject writes the entry's data, or its delta if it has a base,
+// recording its offset and CRC-32.
+func writePackObject(pw *packHashWriter, zw *zlib.Writer, e *packEntry) error {
+	e.offset = pw.offset
+	pw.crc.Reset()
+
+	data := e.data
+	var h []byte
+	if e.base != nil {
+		data = e.delta
+		h = appendPackObjectHeader(h, objectOfsDelta, uint64(len(data)))
+		h = appendPackOffset(h, e.offset-e.base.offset)
+	} else {
+		h = appendPackObjectHeader(h, e.typ, uint64(len(data)))
+	}
+	if _, err := pw.Write(h); err != nil {
+		return err
+	}
+
+	zw.Reset(pw)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	e.crc = pw.crc.Sum32()
+	return nil
+}
+
+// appendPackObjectHeader appends the type and size of a pack entry, as read
+// by readPackObjectHeader.
+func appendPackObjectHeader(b []byte, typ ObjectType, size uint64) []byte {
+	c := byte(typ) | byte(size&0x0f)
+	size >>= 4
+	for size != 0 {
+		b = append(b, c|0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(b, c)
+}
+
+// appendPackOffset appends the distance to an OFS_DELTA base, as read by
+// readOffset.
+func appendPackOffset(b []byte, offset uint64) []byte {
+	var buf [10]byte
+	i := len(buf) - 1
+	buf[i] = byte(offset & 0x7f)
+	for offset >>= 7; offset != 0; offset >>= 7 {
+		offset--
+		i--
+		buf[i] = 0x80 | byte(offset&0x7f)
+	}
+	return append(b, buf[i:]...)
+}
+
+// writePackIndex writes a version 2 index of the entries of a pack with the
+// given checksum. Offsets of 2 GiB and more go to the 64-bit offset table.
+// The entries' ids, and the checksums, are of the given format.
+func writePackIndex(w io.Writer, format ObjectFormat, entries []*packEntry, packChecksum []byte) error {
+	sorted := append([]*packEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].id < sorted[j].id })
+
+	var buf bytes.Buffer
+	be := binary.BigEndian
+	var b [8]byte
+
+	buf.Write([]byte{0xff, 't', 'O', 'c', 0, 0, 0, 2})
+	var count uint32
+	for first := 0; first < 256; first++ {
+		for int(count) < len(sorted) && int(sorted[count].id[0]) <= first {
+			count++
+		}
+		be.PutUint32(b[:4], count)
+		buf.Write(b[:4])
+	}
+	for _, e := range sorted {
+		buf.WriteString(string(e.id))
+	}
+	for _, e := range sorted {
+		be.PutUint32(b[:4], e.crc)
+		buf.Write(b[:4])
+	}
+	var large []uint64
+	for _, e := range sorted {
+		offset := uint32(e.offset)
+		if e.offset >= packIndexLargeOffset {
+			offset = packIndexLargeOffset | uint32(len(large))
+			large = append(large, e.offset)
+		}
+		be.PutUint32(b[:4], offset)
+		buf.Write(b[:4])
+	}
+	for _, offset := range large {
+		be.PutUint64(b[:], offset)
+		buf.Write(b[:])
+	}
+	buf.Write(packChecksum)
+
+	checksum := format.New()
+	checksum.Write(buf.Bytes())
+	buf.Write(checksum.Sum(nil))
+	_, err := buf.WriteTo(w)
+	return err
+}
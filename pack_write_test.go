@@ -0,0 +1,193 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMakeDelta(t *testing.T) {
+	base := []byte(strings.Repeat("0123456789abcdef", 10000) + "tail")
+	target := append([]byte("head"), base[100:90000]...)
+	target = append(target, bytes.Repeat([]byte{'x'}, 300)...)
+	target = append(target, base[5:77]...)
+
+	delta := makeDelta(newDeltaIndex(base), target, 0)
+	if len(delta) >= len(target)/100 {
+		t.Errorf("delta of %d bytes is too large", len(delta))
+	}
+	if makeDelta(newDeltaIndex(base), target, 10) != nil {
+		t.Error("expected no delta within 10 bytes")
+	}
+
+	// Skip the base and result sizes, like objectAtOffset.
+	sizes := delta
+	for i := 0; i < 2; i++ {
+		for sizes[0]&0x80 != 0 {
+			sizes = sizes[1:]
+		}
+		sizes = sizes[1:]
+	}
+	got, err := applyDelta(base, sizes, uint64(len(target)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Error("delta does not reproduce the target")
+	}
+}
+
+func TestPackWriter(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	var ids []ObjectID
+	err := r.store.ForEach(func(id ObjectID) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "pack"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	var pack, idx bytes.Buffer
+	checksum, err := NewPackWriter(r, PackWriterOptions{}).WritePack(ids, &pack, &idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "pack", "pack-"+checksum)
+	if err := ioutil.WriteFile(name+".pack", pack.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name+".idx", idx.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFileObjectStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	deltas := 0
+	for _, id := range ids {
+		want, err := r.Object(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Get(id, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != want.Type || !bytes.Equal(got.Data, want.Data) {
+			t.Fatalf("object %s differs after repacking", id)
+		}
+
+		offset, err := currentPacks(t, s).packs[0].find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ, _, _ := readPackObjectHeader(bytes.NewReader(pack.Bytes()[offset:])); typ == objectOfsDelta {
+			deltas++
+		}
+	}
+	if deltas == 0 {
+		t.Error("expected some objects to be deltified")
+	}
+}
+
+func TestWritePackIndexLargeOffsets(t *testing.T) {
+	entries := []*packEntry{
+		{id: ObjectIDHex("30d74d258442c7c65512eafab474568dd706c430"), offset: 12},
+		{id: ObjectIDHex("0000000000000000000000000000000000000001"), offset: 1 << 31},
+		{id: ObjectIDHex("ffffffffffffffffffffffffffffffffffffffff"), offset: 1 << 33},
+	}
+	var idx bytes.Buffer
+	if err := writePackIndex(&idx, ObjectFormatSHA1, entries, make([]byte, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(idx.Bytes())
+	l := readIndexLayout(r, ObjectFormatSHA1)
+	if l.numObjects != 3 {
+		t.Fatalf("expected 3 objects, got %d", l.numObjects)
+	}
+	p := &pack{}
+	for _, e := range entries {
+		i, err := p.findIndex(r, l, e.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset := p.offsetOf(r, l, i); offset != e.offset {
+			t.Errorf("%s: expected offset %d, got %d", e.id, e.offset, offset)
+		}
+	}
+}
+
+func TestPackWriterReusesDeltas(t *testing.T) {
+	dir := copyTestRepo(t, "repo")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// y is similar to x, z is not, and z sorts between them by size.
+	var x, z strings.Builder
+	for i := 0; i < 150; i++ {
+		fmt.Fprintf(&x, "line %03d of x\n", i)
+		fmt.Fprintf(&z, "%03d zz zz zz z\n", i*7919%1000)
+	}
+	y := strings.Replace(x.String(), "line 025", "LINE", 1)
+	ids := []ObjectID{
+		storeTestObject(t, r, ObjectBlob, x.String()),
+		storeTestObject(t, r, ObjectBlob, y),
+		storeTestObject(t, r, ObjectBlob, z.String()[:len(y)+2]),
+	}
+	writeTestPack(t, r, filepath.Join(dir, "objects"), ids...)
+	if err := r.store.(*FileObjectStore).Rescan(); err != nil {
+		t.Fatal(err)
+	}
+
+	// countDeltas writes a pack of the objects and counts its deltas.
+	countDeltas := func(opts PackWriterOptions) int {
+		var pack, idx bytes.Buffer
+		if _, err := NewPackWriter(r, opts).WritePack(ids, &pack, &idx); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := readPackStream(&pack, ioutil.Discard, ObjectFormatSHA1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, e := range entries {
+			if e.packType == objectOfsDelta {
+				n++
+			}
+		}
+		return n
+	}
+
+	// With a window of one object, y is only tried against z, but its
+	// delta against x in the existing pack is copied.
+	if got := countDeltas(PackWriterOptions{Window: 1}); got != 1 {
+		t.Errorf("expected 1 delta, got %d", got)
+	}
+	if got := countDeltas(PackWriterOptions{Window: -1}); got != 0 {
+		t.Errorf("expected no deltas without delta compression, got %d", got)
+	}
+}
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReachabilityBitmap(t *testing.T) {
+	r := openTestRepo(t, "repo4")
+	defer r.Close()
+
+	bitmap, release, err := r.reachabilityBitmap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if bitmap == nil {
+		t.Fatal("expected a reachability bitmap")
+	}
+	if bitmap.numObjects != 21 {
+		t.Errorf("expected 21 objects, got %d", bitmap.numObjects)
+	}
+
+	// Counts from `git rev-list --objects`.
+	f, err := r.ReachableFrom(ObjectIDHex(repo4F))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.Count(); n != 21 {
+		t.Errorf("expected 21 objects reachable from F, got %d", n)
+	}
+	for _, typ := range []ObjectType{ObjectCommit, ObjectTree, ObjectBlob} {
+		if n := f.CountType(typ); n != 7 {
+			t.Errorf("expected 7 objects of type %s, got %d", typ, n)
+		}
+	}
+	d, err := r.ReachableFrom(ObjectIDHex(repo4D))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := d.Count(); n != 10 {
+		t.Errorf("expected 10 objects reachable from D, got %d", n)
+	}
+	if n := f.AndNot(d).Count(); n != 11 {
+		t.Errorf("expected 11 objects reachable from F but not D, got %d", n)
+	}
+	if !f.Contains(ObjectIDHex(repo4D)) || d.Contains(ObjectIDHex(repo4C)) {
+		t.Error("wrong set membership")
+	}
+
+	fc, err := r.GetCommit(repo4F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, err := r.GetCommit(repo4D)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fc.IsAncestor(repo4D) || dc.IsAncestor(repo4C) {
+		t.Error("wrong ancestry")
+	}
+
+	// Counts from `git rev-list --left-right --count`.
+	behind, ahead, err := fc.BehindAhead(repo4D)
+	if err != nil || behind != 0 || ahead != 4 {
+		t.Errorf("F vs D: expected 0 behind, 4 ahead, got %d, %d, %v", behind, ahead, err)
+	}
+	behind, ahead, err = dc.BehindAhead(repo4C)
+	if err != nil || behind != 1 || ahead != 1 {
+		t.Errorf("D vs C: expected 1 behind, 1 ahead, got %d, %d, %v", behind, ahead, err)
+	}
+}
+
+func TestReachableFromLooseObjects(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	blob := storeTestObject(t, r, ObjectBlob, "G")
+	tree := storeTestObject(t, r, ObjectTree, "100644 G.txt\x00"+string(blob))
+	g := storeTestObject(t, r, ObjectCommit, "tree "+tree.String()+"\n"+
+		"parent "+repo4F+"\n"+
+		"author Test Author <author@example.com> 1112905113 +0200\n"+
+		"committer Test Committer <committer@example.com> 1112905114 +0200\n"+
+		"\nG\n")
+
+	reach, err := r.ReachableFrom(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := reach.Count(); n != 24 {
+		t.Errorf("expected 24 reachable objects, got %d", n)
+	}
+	f, err := r.ReachableFrom(ObjectIDHex(repo4F))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[ObjectID]ObjectType{}
+	err = reach.AndNot(f).ForEach(func(id ObjectID, typ ObjectType) error {
+		got[id] = typ
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[g] != ObjectCommit || got[tree] != ObjectTree || got[blob] != ObjectBlob {
+		t.Errorf("unexpected objects %v", got)
+	}
+
+	c, err := r.getCommit(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	behind, ahead, err := c.BehindAhead(repo4D)
+	if err != nil || behind != 0 || ahead != 5 {
+		t.Errorf("G vs D: expected 0 behind, 5 ahead, got %d, %d, %v", behind, ahead, err)
+	}
+}
@@ -0,0 +1,19 @@
+package git
+
+// RefStore is the storage backend a Repository reads and writes refs with.
+// The default implementation, FileRefStore, serves the loose and packed refs
+// of a repository directory.
+type RefStore interface {
+	// Ref returns the raw value of the ref with the given full name, such
+	// as "HEAD" or "refs/heads/master": either a hex object id or
+	// "ref: <name>\n" for a symbolic ref. RefNotFound is returned if the ref
+	// does not exist.
+	Ref(name string) (string, error)
+
+	// Refs returns the names of all refs under prefix, such as
+	// "refs/heads", relative to prefix.
+	Refs(prefix string) ([]string, error)
+
+	// SetRef creates or overwrites the ref with the given full name.
+	SetRef(name, value string) error
+}
@@ -0,0 +1,193 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNoPackedRefs = errors.New("no packed-refs")
+
+// FileRefStore is a RefStore backed by the loose refs and the packed-refs
+// file of a repository directory.
+type FileRefStore struct {
+	path string
+}
+
+// NewFileRefStore returns a RefStore for the repository directory at path.
+func NewFileRefStore(path string) *FileRefStore {
+	return &FileRefStore{path: path}
+}
+
+func (s *FileRefStore) Ref(name string) (string, error) {
+	path := filepath.Join(s.path, name)
+	f, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || err != nil && !isFile(path) {
+		// Check for packed ref; a directory of refs is not a ref either
+		var packedErr error
+		f, packedErr = s.getCommitIdOfPackedRef(name)
+		if packedErr == ErrNoPackedRefs {
+			return "", RefNotFound(name)
+		} else if packedErr != nil {
+			return "", packedErr
+		}
+	} else if err != nil {
+		return "", err
+	}
+	return string(f), nil
+}
+
+// Refs returns the loose refs under prefix, followed by the packed refs
+// under prefix that have no loose counterpart.
+func (s *FileRefStore) Refs(prefix string) ([]string, error) {
+	names, err := s.readRefDir(prefix, "")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	packed, err := s.packedRefs()
+	if err != nil {
+		if err == ErrNoPackedRefs {
+			return names, nil
+		}
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	for _, ref := range packed {
+		if !strings.HasPrefix(ref.refpath, prefix) {
+			continue
+		}
+		name := ref.refpath[len(prefix):]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *FileRefStore) SetRef(name, value string) error {
+	refPath := filepath.Join(s.path, name)
+	if err := os.MkdirAll(filepath.Dir(refPath), 0775); err != nil {
+		return err
+	}
+
+	f, err := os.Create(refPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(value)
+	return err
+}
+
+func (s *FileRefStore) readRefDir(prefix, relPath string) ([]string, error) {
+	dirPath := filepath.Join(s.path, prefix, relPath)
+	f, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fis, err := f.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if strings.Contains(fi.Name(), ".DS_Store") {
+			continue
+		}
+
+		relFileName := filepath.Join(relPath, fi.Name())
+		if fi.IsDir() {
+			subnames, err := s.readRefDir(prefix, relFileName)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, subnames...)
+			continue
+		}
+
+		names = append(names, relFileName)
+	}
+
+	return names, nil
+}
+
+func (s *FileRefStore) getCommitIdOfPackedRef(refpath string) ([]byte, error) {
+	refs, err := s.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		if ref.refpath == refpath {
+			return []byte(ref.commit), nil
+		}
+	}
+
+	return nil, RefNotFound(refpath)
+}
+
+func (s *FileRefStore) packedRefs() ([]packedRef, error) {
+	path := filepath.Join(s.path, "packed-refs")
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoPackedRefs
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var refs []packedRef
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		if refLine, err := parseRefLine(scan.Text()); err == nil {
+			refs = append(refs, refLine)
+		}
+	}
+
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+type packedRef struct {
+	commit  string
+	refpath string
+}
+
+// parseRefLine parses a line in the packed-refs file. This file
+// contains lines of the form `${commit-id} ${ref-name}`,
+// `^${commit-id}, and comment lines beginning with "#". This function
+// returns the parsed ref in the first case and an error in all other
+// cases.
+func parseRefLine(line string) (packedRef, error) {
+	errParse := fmt.Errorf("could not parse ref from line %q", line)
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return packedRef{}, errParse
+	}
+	if !IsObjectIDHex(fields[0]) {
+		return packedRef{}, errParse
+	}
+	if !strings.HasPrefix(fields[1], "refs/") {
+		return packedRef{}, errParse
+	}
+	return packedRef{commit: fields[0], refpath: fields[1]}, nil
+}
@@ -0,0 +1,54 @@
+package git
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryRefStore is a RefStore that keeps all refs in memory.
+// It is safe for concurrent use.
+type MemoryRefStore struct {
+	mu   sync.RWMutex
+	refs map[string]string
+}
+
+func NewMemoryRefStore() *MemoryRefStore {
+	return &MemoryRefStore{
+		refs: make(map[string]string),
+	}
+}
+
+func (s *MemoryRefStore) Ref(name string) (string, error) {
+	s.mu.RLock()
+	value, ok := s.refs[name]
+	s.mu.RUnlock()
+	if !ok {
+		return "", RefNotFound(name)
+	}
+	return value, nil
+}
+
+// Refs returns the matching names in sorted order.
+func (s *MemoryRefStore) Refs(prefix string) ([]string, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+
+	s.mu.RLock()
+	var names []string
+	for name := range s.refs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name[len(prefix):])
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemoryRefStore) SetRef(name, value string) error {
+	s.mu.Lock()
+	s.refs[name] = value
+	s.mu.Unlock()
+	return nil
+}
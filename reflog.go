@@ -0,0 +1,67 @@
+package git
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// reflogEntry is a line of a reflog: the old and new value of a ref, who
+// changed it and why.
+type reflogEntry struct {
+	Old, New  ObjectID
+	Committer *Signature
+	Message   string
+}
+
+// reflog returns the entries of the reflog of the ref with the given full
+// name, oldest first. A ref without a reflog, or a repository without a
+// directory, has none. Malformed lines are skipped.
+func (repo *Repository) reflog(refpath string) ([]reflogEntry, error) {
+	if repo.Path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(repo.Path, "logs", filepath.FromSlash(refpath)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Each entry is "<old> <new> <committer>\t<message>".
+	var entries []reflogEntry
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		var message string
+		if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+			line, message = line[:tab], line[tab+1:]
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		old, err := parseObjectIDHex(fields[0])
+		if err != nil {
+			continue
+		}
+		new, err := parseObjectIDHex(fields[1])
+		if err != nil {
+			continue
+		}
+		committer, err := newSignatureFromCommitline([]byte(fields[2]))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, reflogEntry{old, new, committer, message})
+	}
+	return entries, scan.Err()
+}
+
+// hasReflog reports whether the ref with the given full name has a reflog.
+func (repo *Repository) hasReflog(refpath string) bool {
+	return repo.Path != "" && isFile(filepath.Join(repo.Path, "logs", filepath.FromSlash(refpath)))
+}
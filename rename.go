@@ -0,0 +1,164 @@
+package git
+
+import (
+	"bytes"
+	"path"
+)
+
+// Rename detection scores, as in git: a score is the share of the larger
+// file that is found in the other, in units of maxRenameScore, and files
+// with a score of at least minRenameScore, 50%, are renames or copies.
+const (
+	maxRenameScore = 60000
+	minRenameScore = 30000
+)
+
+// findRenameSource returns the path of the file in the tree from that the
+// file at dst in the tree to was renamed or copied from, or "" if there is
+// none. Like git log --follow, the candidates are the files that are
+// deleted or modified between the trees: one with the same content wins,
+// preferring one with the same base name, and otherwise the most similar
+// regular file.
+func (repo *Repository) findRenameSource(from, to ObjectID, dst string) (string, error) {
+	name := path.Base(dst)
+	target, err := repo.treeEntryAtPath(to, dst)
+	if err != nil || target == nil || target.Type != ObjectBlob {
+		return "", err
+	}
+
+	var sources []treeChange
+	err = repo.diffTree(from, to, func(c treeChange) error {
+		if c.From != nil && c.From.Type == ObjectBlob {
+			sources = append(sources, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	exact := ""
+	for _, s := range sources {
+		if s.From.Id != target.Id || s.From.mode&0170000 != target.mode&0170000 {
+			continue
+		}
+		if path.Base(s.Path) == name {
+			return s.Path, nil
+		}
+		if exact == "" {
+			exact = s.Path
+		}
+	}
+	if exact != "" || target.mode&0170000 != ModeBlob&0170000 {
+		return exact, nil
+	}
+
+	o, err := repo.object(target.Id, false)
+	if err != nil {
+		return "", err
+	}
+	dstData := o.Data
+	var dstSpans spanHashes
+
+	best, bestScore, bestNameScore := "", minRenameScore-1, 0
+	for _, s := range sources {
+		if s.From.mode&0170000 != ModeBlob&0170000 {
+			continue
+		}
+		// Leave out sources whose size alone rules them out before
+		// reading them.
+		meta, err := repo.object(s.From.Id, true)
+		if err != nil {
+			return "", err
+		}
+		if !renameSizesMatch(meta.Size, uint64(len(dstData))) {
+			continue
+		}
+		o, err := repo.object(s.From.Id, false)
+		if err != nil {
+			return "", err
+		}
+		if dstSpans == nil {
+			dstSpans = newSpanHashes(dstData)
+		}
+		score := renameScore(o.Data, newSpanHashes(o.Data), dstData, dstSpans)
+		nameScore := 0
+		if path.Base(s.Path) == name {
+			nameScore = 1
+		}
+		if score > bestScore || score == bestScore && nameScore > bestNameScore {
+			best, bestScore, bestNameScore = s.Path, score, nameScore
+		}
+	}
+	return best, nil
+}
+
+// renameSizesMatch reports whether files of the given sizes differ in size
+// little enough to possibly reach minRenameScore.
+func renameSizesMatch(a, b uint64) bool {
+	max, min := a, b
+	if max < min {
+		max, min = min, max
+	}
+	return max*(maxRenameScore-minRenameScore) >= (max-min)*maxRenameScore
+}
+
+// renameScore estimates how much of dst was copied from src, as git's
+// estimate_similarity does.
+func renameScore(src []byte, srcSpans spanHashes, dst []byte, dstSpans spanHashes) int {
+	max := len(src)
+	if len(dst) > max {
+		max = len(dst)
+	}
+	if len(dst) == 0 || !renameSizesMatch(uint64(len(src)), uint64(len(dst))) {
+		return 0
+	}
+	var copied uint64
+	for h, n := range srcSpans {
+		if d := dstSpans[h]; d < n {
+			copied += d
+		} else {
+			copied += n
+		}
+	}
+	return int(copied * maxRenameScore / uint64(max))
+}
+
+// spanHashes counts the bytes of a file by the hash of the chunks they are
+// in. Chunks end at a newline or after 64 bytes, and the CR of a CRLF is
+// left out in text files. Like git's diffcore-delta, bytes after the last
+// chunk are not counted.
+type spanHashes map[uint32]uint64
+
+func newSpanHashes(data []byte) spanHashes {
+	const hashBase = 107927
+	text := !isBinary(data)
+	spans := spanHashes{}
+	var accum1, accum2 uint32
+	n := uint64(0)
+	for i := 0; i < len(data); i++ {
+		c := uint32(data[i])
+		if text && c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+		old1 := accum1
+		accum1 = accum1<<7 ^ accum2>>25
+		accum2 = accum2<<7 ^ old1>>25
+		accum1 += c
+		if n++; n < 64 && c != '\n' {
+			continue
+		}
+		spans[(accum1+accum2*0x61)%hashBase] += n
+		n, accum1, accum2 = 0, 0, 0
+	}
+	return spans
+}
+
+// isBinary reports whether data looks binary to git: it has a NUL byte in
+// its first 8000 bytes.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
@@ -2,24 +2,103 @@ package git
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 )
 
+// Repository is a git repository. All methods that read from the repository,
+// and the commits, trees, entries and blobs they return, are safe for
+// concurrent use by multiple goroutines.
 type Repository struct {
-	Path  string
-	packs []*pack
+	Path   string
+	store  ObjectStore
+	refs   RefStore
+	format ObjectFormat
 
-	commitCache map[ObjectID]*Commit
-	tagCache    map[ObjectID]*Tag
+	verifyObjects bool
+
+	commitCache *lruCache
+	tagCache    *lruCache
+	treeCache   *lruCache
+
+	graphMu     sync.Mutex
+	graphLoaded bool
+	graph       *CommitGraph
+	graphErr    error
+	staleGraphs []*CommitGraph // replaced graphs, kept open for readers
 }
 
-func OpenRepository(path string) (*Repository, error) {
-	repo := &Repository{
-		Path: path,
+// Default sizes of a Repository's caches, in number of objects.
+const (
+	DefaultCommitCacheSize = 10000
+	DefaultTagCacheSize    = 1000
+	DefaultTreeCacheSize   = 1000
+)
+
+// RepositoryOptions configures a Repository opened with
+// OpenRepositoryWithOptions. The zero value gives the same Repository as
+// OpenRepository.
+type RepositoryOptions struct {
+	// ObjectStore, if set, is used to read and write objects instead of a
+	// FileObjectStore on the repository's objects directory. The Repository
+	// takes ownership of the store and closes it in Close.
+	ObjectStore ObjectStore
+
+	// RefStore, if set, is used to read and write refs instead of a
+	// FileRefStore on the repository directory.
+	RefStore RefStore
+
+	// DeltaBaseCacheLimit is the number of bytes of inflated delta bases the
+	// default FileObjectStore keeps cached, like git's
+	// core.deltaBaseCacheLimit. Zero means DefaultDeltaBaseCacheLimit and a
+	// negative value disables the cache. It is ignored if ObjectStore is set.
+	DeltaBaseCacheLimit int64
+
+	// VerifyObjects makes the repository check that the content of every
+	// object it reads hashes to the object's ID, returning ObjectCorrupt if
+	// not. This guards against silent disk corruption at the cost of
+	// hashing everything that is read.
+	VerifyObjects bool
+
+	// CommitCacheSize, TagCacheSize and TreeCacheSize are the maximum
+	// number of parsed commits, tags and trees the repository keeps cached.
+	// Zero means the corresponding Default*CacheSize and a negative value
+	// disables the cache.
+	CommitCacheSize int
+	TagCacheSize    int
+	TreeCacheSize   int
+}
+
+func newRepository(path string, opts RepositoryOptions) *Repository {
+	return &Repository{
+		Path:          path,
+		store:         opts.ObjectStore,
+		refs:          opts.RefStore,
+		verifyObjects: opts.VerifyObjects,
+		commitCache:   newLRUCache(cacheSize(opts.CommitCacheSize, DefaultCommitCacheSize)),
+		tagCache:      newLRUCache(cacheSize(opts.TagCacheSize, DefaultTagCacheSize)),
+		treeCache:     newLRUCache(cacheSize(opts.TreeCacheSize, DefaultTreeCacheSize)),
 	}
+}
+
+func cacheSize(size, defaultSize int) int64 {
+	if size == 0 {
+		return int64(defaultSize)
+	}
+	return int64(size)
+}
+
+func OpenRepository(path string) (*Repository, error) {
+	return OpenRepositoryWithOptions(path, RepositoryOptions{})
+}
+
+// OpenRepositoryWithOptions opens the repository at path. Like git, the
+// default object store also reads objects from the directories listed in
+// GIT_ALTERNATE_OBJECT_DIRECTORIES. If opts.ObjectStore is a FileObjectStore
+// or a MemoryObjectStore, it must use the repository's object format.
+func OpenRepositoryWithOptions(path string, opts RepositoryOptions) (*Repository, error) {
+	repo := newRepository(path, opts)
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
@@ -32,30 +111,104 @@ func OpenRepository(path string) (*Repository, error) {
 		return nil, fmt.Errorf("%q is not a directory.", fm.Name())
 	}
 
-	packDir := filepath.Join(path, "objects", "pack")
-	infos, err := ioutil.ReadDir(packDir)
+	repo.format, err = readObjectFormat(path)
 	if err != nil {
 		return nil, err
 	}
-	for _, info := range infos {
-		if !strings.HasSuffix(info.Name(), ".pack") {
-			continue
+	if s, ok := repo.store.(interface{ ObjectFormat() ObjectFormat }); ok && s.ObjectFormat() != repo.format {
+		return nil, fmt.Errorf("object store uses %v, but the repository uses %v", s.ObjectFormat(), repo.format)
+	}
+
+	if repo.store == nil {
+		store, err := OpenFileObjectStoreFormat(filepath.Join(path, "objects"), repo.format)
+		if err != nil {
+			return nil, err
 		}
+		if env := os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"); env != "" {
+			if err := store.addAlternates(filepath.SplitList(env), "", 1); err != nil {
+				store.Close()
+				return nil, err
+			}
+		}
+		if opts.DeltaBaseCacheLimit != 0 {
+			store.SetDeltaBaseCacheLimit(opts.DeltaBaseCacheLimit)
+		}
+		repo.store = store
+	}
 
-		repo.packs = append(repo.packs, &pack{
-			repo: repo,
-			id:   info.Name()[:len(info.Name())-5],
-		})
+	if repo.refs == nil {
+		repo.refs = NewFileRefStore(path)
 	}
 
 	return repo, nil
 }
 
-func (r *Repository) Close() (err error) {
-	for _, p := range r.packs {
-		if thisErr := p.Close(); thisErr != nil && err == nil {
-			err = thisErr
+// OpenMemoryRepository returns an empty repository whose objects and refs
+// live entirely in memory. Like a freshly initialized repository, its HEAD
+// points at refs/heads/master.
+func OpenMemoryRepository() *Repository {
+	refs := NewMemoryRefStore()
+	refs.SetRef("HEAD", "ref: refs/heads/master\n")
+	return newRepository("", RepositoryOptions{
+		ObjectStore: NewMemoryObjectStore(),
+		RefStore:    refs,
+	})
+}
+
+// ObjectFormat returns the hash function the repository's objects are named
+// by, as set by its extensions.objectFormat config.
+func (r *Repository) ObjectFormat() ObjectFormat {
+	return r.format
+}
+
+// ObjectStore returns the store the repository's objects are kept in.
+func (r *Repository) ObjectStore() ObjectStore {
+	return r.store
+}
+
+// RefStore returns the store the repository's refs are kept in.
+func (r *Repository) RefStore() RefStore {
+	return r.refs
+}
+
+// RepositoryCacheStats reports the activity of a Repository's caches.
+type RepositoryCacheStats struct {
+	Commits CacheStats
+	Tags    CacheStats
+	Trees   CacheStats
+
+	// DeltaBases is only filled in for repositories backed by a
+	// FileObjectStore. Its Size is in bytes.
+	DeltaBases CacheStats
+}
+
+// CacheStats returns hit, miss and eviction counters for the repository's
+// caches, to help tune their sizes.
+func (r *Repository) CacheStats() RepositoryCacheStats {
+	stats := RepositoryCacheStats{
+		Commits: r.commitCache.stats(),
+		Tags:    r.tagCache.stats(),
+		Trees:   r.treeCache.stats(),
+	}
+	if s, ok := r.store.(*FileObjectStore); ok {
+		stats.DeltaBases = s.deltaBaseCache.stats()
+	}
+	return stats
+}
+
+func (r *Repository) Close() error {
+	err := r.store.Close()
+
+	r.graphMu.Lock()
+	defer r.graphMu.Unlock()
+	graphs := r.staleGraphs
+	if r.graph != nil {
+		graphs = append(graphs, r.graph)
+	}
+	for _, g := range graphs {
+		if graphErr := g.Close(); graphErr != nil && err == nil {
+			err = graphErr
 		}
 	}
-	return nil
+	return err
 }
@@ -5,7 +5,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 var (
@@ -18,12 +17,16 @@ func IsBranchExist(repoPath, branchName string) bool {
 }
 
 func (repo *Repository) IsBranchExist(branchName string) bool {
-	branchPath := filepath.Join(repo.Path, "refs/heads", branchName)
-	return isFile(branchPath)
+	return repo.isRefExist("refs/heads/" + branchName)
+}
+
+func (repo *Repository) isRefExist(refpath string) bool {
+	_, err := repo.refs.Ref(refpath)
+	return err == nil
 }
 
 func (repo *Repository) GetBranches() ([]string, error) {
-	return repo.readRefDir("refs/heads", "")
+	return repo.refs.Refs("refs/heads")
 }
 
 func (repo *Repository) CreateBranch(branchName, idStr string) error {
@@ -31,55 +34,11 @@ func (repo *Repository) CreateBranch(branchName, idStr string) error {
 }
 
 func (repo *Repository) createRef(head, branchName, idStr string) error {
-	branchPath := filepath.Join(repo.Path, "refs/"+head, branchName)
-	if isFile(branchPath) {
+	refpath := "refs/" + head + "/" + branchName
+	if repo.isRefExist(refpath) {
 		return ErrBranchExisted
 	}
-
-	f, err := os.Create(branchPath)
-	if err != nil {
-		return err
-	}
-
-	defer f.Close()
-
-	_, err = io.WriteString(f, idStr)
-	return err
-}
-
-func (repo *Repository) readRefDir(prefix, relPath string) ([]string, error) {
-	dirPath := filepath.Join(repo.Path, prefix, relPath)
-	f, err := os.Open(dirPath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	fis, err := f.Readdir(0)
-	if err != nil {
-		return nil, err
-	}
-
-	names := make([]string, 0, len(fis))
-	for _, fi := range fis {
-		if strings.Contains(fi.Name(), ".DS_Store") {
-			continue
-		}
-
-		relFileName := filepath.Join(relPath, fi.Name())
-		if fi.IsDir() {
-			subnames, err := repo.readRefDir(prefix, relFileName)
-			if err != nil {
-				return nil, err
-			}
-			names = append(names, subnames...)
-			continue
-		}
-
-		names = append(names, relFileName)
-	}
-
-	return names, nil
+	return repo.refs.SetRef(refpath, idStr)
 }
 
 func CreateBranch(repoPath, branchName, id string) error {
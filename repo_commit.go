@@ -1,13 +1,9 @@
 package git
 
 import (
-	"bufio"
 	"container/list"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
@@ -15,8 +11,6 @@ import (
 
 var refRexp = regexp.MustCompile("ref: (.*)\n")
 
-var ErrNoPackedRefs = errors.New("no packed-refs")
-
 // RefNotFound error returned when a commit is fetched by ref that is not found.
 type RefNotFound string
 
@@ -53,28 +47,21 @@ func (repo *Repository) GetCommitIdOfTag(tagName string) (string, error) {
 
 func (repo *Repository) GetCommitIdOfRef(refpath string) (string, error) {
 start:
-	path := filepath.Join(repo.Path, refpath)
-	f, err := ioutil.ReadFile(path)
-	if os.IsNotExist(err) {
-		// Check for packed ref
-		var packedErr error
-		f, packedErr = repo.getCommitIdOfPackedRef(refpath)
-		if packedErr == ErrNoPackedRefs {
-			return "", RefNotFound(refpath)
-		} else if packedErr != nil {
-			return "", packedErr
-		}
-	} else if err != nil {
+	f, err := repo.refs.Ref(refpath)
+	if err != nil {
 		return "", err
 	}
 
-	allMatches := refRexp.FindAllStringSubmatch(string(f), 1)
+	allMatches := refRexp.FindAllStringSubmatch(f, 1)
 	if allMatches == nil {
 		// let's assume this is a ObjectID
 		if len(f) < 40 {
 			return "", errors.New("ObjectID hash too short")
 		}
-		id := string(f[:40])
+		id := f
+		if i := strings.IndexAny(f, " \t\r\n"); i >= 0 {
+			id = f[:i]
+		}
 		if !IsObjectIDHex(id) {
 			return "", fmt.Errorf("heads file wrong ObjectID string %s", id)
 		}
@@ -85,66 +72,18 @@ start:
 	goto start
 }
 
-func (repo *Repository) getCommitIdOfPackedRef(refpath string) ([]byte, error) {
-	path := filepath.Join(repo.Path, "packed-refs")
-	f, err := os.Open(path)
-	if err != nil && os.IsNotExist(err) {
-		return nil, ErrNoPackedRefs
-	}
-	defer f.Close()
-
-	scan := bufio.NewScanner(f)
-
-	for scan.Scan() {
-		if refLine, err := parseRefLine(scan.Text()); err == nil && refLine.refpath == refpath {
-			return []byte(refLine.commit), nil
-		}
-	}
-
-	if err := scan.Err(); err != nil {
+// Find the commit object in the repository. The id may be abbreviated.
+func (repo *Repository) GetCommit(commitId string) (*Commit, error) {
+	id, err := repo.resolveID(commitId)
+	if err != nil {
 		return nil, err
 	}
-
-	return nil, RefNotFound(refpath)
-}
-
-type packedRef struct {
-	commit  string
-	refpath string
-}
-
-// parseRefLine parses a line in the packed-refs file. This file
-// contains lines of the form `${commit-id} ${ref-name}`,
-// `^${commit-id}, and comment lines beginning with "#". This function
-// returns the parsed ref in the first case and an error in all other
-// cases.
-func parseRefLine(line string) (packedRef, error) {
-	errParse := fmt.Errorf("could not parse ref from line %q", line)
-	fields := strings.Fields(line)
-	if len(fields) != 2 {
-		return packedRef{}, errParse
-	}
-	if len(fields[0]) != 40 {
-		return packedRef{}, errParse
-	}
-	if !strings.HasPrefix(fields[1], "refs/") {
-		return packedRef{}, errParse
-	}
-	return packedRef{commit: fields[0], refpath: fields[1]}, nil
-}
-
-// Find the commit object in the repository.
-func (repo *Repository) GetCommit(commitId string) (*Commit, error) {
-	return repo.getCommit(ObjectIDHex(commitId))
+	return repo.getCommit(id)
 }
 
 func (repo *Repository) getCommit(id ObjectID) (*Commit, error) {
-	if repo.commitCache != nil {
-		if c, ok := repo.commitCache[id]; ok {
-			return c, nil
-		}
-	} else {
-		repo.commitCache = make(map[ObjectID]*Commit, 10)
+	if c, ok := repo.commitCache.get(id); ok {
+		return c.(*Commit), nil
 	}
 
 	o, err := repo.object(id, false)
@@ -159,7 +98,7 @@ func (repo *Repository) getCommit(id ObjectID) (*Commit, error) {
 	commit.repo = repo
 	commit.Id = id
 
-	repo.commitCache[id] = commit
+	repo.commitCache.add(id, commit, 1)
 
 	return commit, nil
 }
@@ -190,7 +129,11 @@ func (repo *Repository) CommitsBetween(last *Commit, before *Commit) (*list.List
 }
 
 func (repo *Repository) CommitsBefore(commitId string) (*list.List, error) {
-	return repo.getCommitsBefore(ObjectIDHex(commitId))
+	id, err := repo.resolveID(commitId)
+	if err != nil {
+		return nil, err
+	}
+	return repo.getCommitsBefore(id)
 }
 
 func (repo *Repository) getCommitsBefore(id ObjectID) (*list.List, error) {
@@ -0,0 +1,118 @@
+package git
+
+import "os"
+
+// CommitGraph returns the repository's commit-graph, loading it on first
+// use. It returns nil and no error if the repository has no commit-graph or
+// is not backed by a FileObjectStore.
+func (repo *Repository) CommitGraph() (*CommitGraph, error) {
+	repo.graphMu.Lock()
+	defer repo.graphMu.Unlock()
+
+	if !repo.graphLoaded {
+		repo.graphLoaded = true
+		if s, ok := repo.store.(*FileObjectStore); ok {
+			repo.graph, repo.graphErr = OpenCommitGraph(s.Dir())
+			if os.IsNotExist(repo.graphErr) {
+				repo.graphErr = nil
+			}
+		}
+	}
+	return repo.graph, repo.graphErr
+}
+
+// reloadCommitGraph makes the next CommitGraph call read the commit-graph
+// from disk again. The previous graph stays open until Close, since other
+// goroutines may still be reading it.
+func (repo *Repository) reloadCommitGraph() {
+	repo.graphMu.Lock()
+	defer repo.graphMu.Unlock()
+
+	if repo.graph != nil {
+		repo.staleGraphs = append(repo.staleGraphs, repo.graph)
+	}
+	repo.graph, repo.graphErr, repo.graphLoaded = nil, nil, false
+}
+
+// commitNode returns the parents, generation number and commit time of the
+// commit with the given id. The commit-graph is used when it covers the
+// commit, otherwise the commit is read from the object store and has
+// generation GenerationNumberInfinity. Like git, a broken commit-graph is
+// ignored.
+func (repo *Repository) commitNode(id ObjectID) (*CommitGraphCommit, error) {
+	if g, err := repo.CommitGraph(); g != nil && err == nil {
+		if c, err := g.Lookup(id); err == nil {
+			return c, nil
+		}
+	}
+
+	c, err := repo.getCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	node := &CommitGraphCommit{
+		Id:         c.Id,
+		TreeId:     c.TreeId(),
+		ParentIds:  c.parents,
+		Generation: GenerationNumberInfinity,
+	}
+	if c.Committer != nil {
+		node.CommitTime = c.Committer.When
+	}
+	return node, nil
+}
+
+// canReach reports whether a commit of generation gen may reach a commit of
+// generation targetGen. Generations strictly decrease from child to parent,
+// except that commits missing from the commit-graph all have infinite
+// generation.
+func canReach(gen, targetGen uint64) bool {
+	return gen > targetGen || gen == GenerationNumberInfinity
+}
+
+// isAncestor reports whether ancestor is reachable from descendant by
+// following parents. A commit is its own ancestor. A reachability bitmap is
+// used when the repository has one.
+func (repo *Repository) isAncestor(ancestor, descendant ObjectID) (bool, error) {
+	hasBitmap, err := repo.hasReachabilityBitmap()
+	if err != nil {
+		return false, err
+	}
+	if hasBitmap {
+		reach, err := repo.reachableFrom([]ObjectID{descendant}, true)
+		if err != nil {
+			return false, err
+		}
+		return reach.Contains(ancestor), nil
+	}
+
+	target, err := repo.commitNode(ancestor)
+	if err != nil {
+		return false, err
+	}
+
+	seen := map[ObjectID]bool{descendant: true}
+	stack := []ObjectID{descendant}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == ancestor {
+			return true, nil
+		}
+
+		c, err := repo.commitNode(id)
+		if err != nil {
+			return false, err
+		}
+		if !canReach(c.Generation, target.Generation) {
+			continue
+		}
+		for _, p := range c.ParentIds {
+			if !seen[p] {
+				seen[p] = true
+				stack = append(stack, p)
+			}
+		}
+	}
+	return false, nil
+}
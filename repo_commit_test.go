@@ -20,7 +20,7 @@ func Test_Repository_getCommitIdOfPackedRef(t *testing.T) {
 	for _, test := range tests {
 		func() {
 			r := openTestRepo(t, test.testRepo)
-			commitID, err := r.getCommitIdOfPackedRef(test.refPath)
+			commitID, err := r.refs.(*FileRefStore).getCommitIdOfPackedRef(test.refPath)
 			if string(commitID) != test.expCommitID {
 				t.Errorf("expected commit %q, got %q", test.expCommitID, string(commitID))
 			}
@@ -30,3 +30,30 @@ func Test_Repository_getCommitIdOfPackedRef(t *testing.T) {
 		}()
 	}
 }
+
+func TestGetCommitShortID(t *testing.T) {
+	r := openTestRepo(t, "repo")
+	defer r.Close()
+
+	c, err := r.GetCommit("8b61789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Id.String() != "8b61789a76de9edaa49b2529d3aaa302ba238c0b" {
+		t.Errorf("expected the commit 8b61789a76de9edaa49b2529d3aaa302ba238c0b, got %s", c.Id)
+	}
+	for _, id := range []string{"8b6", "0000000", "not hex", "8b61789a76de9edaa49b2529d3aaa302ba238c0bz"} {
+		if _, err := r.GetCommit(id); err == nil {
+			t.Errorf("%q: expected an error", id)
+		}
+		if _, err := r.GetTree(id); err == nil {
+			t.Errorf("%q: expected an error from GetTree", id)
+		}
+		if _, err := r.CommitsBefore(id); err == nil {
+			t.Errorf("%q: expected an error from CommitsBefore", id)
+		}
+		if c.IsAncestor(id) {
+			t.Errorf("%q: expected no ancestor", id)
+		}
+	}
+}
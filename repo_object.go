@@ -1,13 +1,11 @@
 package git
 
 import (
-	"bufio"
-	"compress/zlib"
+	"bytes"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
-	"os"
-	"path/filepath"
-	"strconv"
 )
 
 // ObjectNotFound error returned when a repo query is performed for an ID that does not exist.
@@ -17,6 +15,17 @@ func (id ObjectNotFound) Error() string {
 	return fmt.Sprintf("object not found: %s", ObjectID(id))
 }
 
+// ObjectCorrupt error returned by a repository opened with VerifyObjects when
+// the content read for an object does not hash to its ID.
+type ObjectCorrupt struct {
+	Id     ObjectID
+	Actual ObjectID // hash of the content that was read
+}
+
+func (e ObjectCorrupt) Error() string {
+	return fmt.Sprintf("object corrupt: %s hashes to %s", e.Id, e.Actual)
+}
+
 type ObjectType int
 
 const (
@@ -49,85 +58,116 @@ type Object struct {
 	Data []byte
 }
 
-func filepathFromSHA1(rootdir, id string) string {
-	return filepath.Join(rootdir, "objects", id[:2], id[2:])
-}
-
 func (repo *Repository) Object(id ObjectID) (*Object, error) {
 	return repo.object(id, false)
 }
 
 func (repo *Repository) object(id ObjectID, metaOnly bool) (*Object, error) {
-	o, err := readLooseObject(filepathFromSHA1(repo.Path, id.String()), metaOnly)
-	if err == nil {
-		return o, nil
+	o, err := repo.store.Get(id, metaOnly)
+	if err != nil || metaOnly || !repo.verifyObjects {
+		return o, err
 	}
-	if !os.IsNotExist(err) {
+	if err := verifyObject(id, o); err != nil {
 		return nil, err
 	}
+	return o, nil
+}
 
-	for _, p := range repo.packs {
-		o, err := p.object(id, metaOnly)
-		if err != nil {
-			if _, ok := err.(ObjectNotFound); ok {
-				continue
-			}
-			return nil, err
-		}
-		return o, nil
+// verifyObject returns ObjectCorrupt if o does not hash to id, with the hash
+// function of id's object format.
+func verifyObject(id ObjectID, o *Object) error {
+	h := objectFormatOf(id).New()
+	io.WriteString(h, objectHeader(o.Type, int64(len(o.Data))))
+	h.Write(o.Data)
+	if actual := ObjectID(h.Sum(nil)); actual != id {
+		return ObjectCorrupt{id, actual}
 	}
+	return nil
+}
 
-	return nil, ObjectNotFound(id)
+// ObjectReader streams the contents of an object. The caller must Close it.
+type ObjectReader struct {
+	Type ObjectType
+	Size uint64
+	io.ReadCloser
 }
 
-func readLooseObject(path string, metaOnly bool) (*Object, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// ObjectReader returns a reader for the contents of the object with the given
+// id. Unlike Object, it does not inflate the whole object into memory when
+// the object store supports streaming.
+//
+// If the repository verifies objects, reading the end of the contents
+// returns ObjectCorrupt instead of io.EOF if they do not hash to id.
+func (repo *Repository) ObjectReader(id ObjectID) (*ObjectReader, error) {
+	s, ok := repo.store.(ObjectStreamer)
+	if !ok {
+		o, err := repo.object(id, false)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectReader{o.Type, o.Size, ioutil.NopCloser(bytes.NewReader(o.Data))}, nil
 	}
-	defer f.Close()
 
-	zr, err := zlib.NewReader(f)
-	if err != nil {
-		return nil, err
+	r, err := s.Reader(id)
+	if err != nil || !repo.verifyObjects {
+		return r, err
 	}
-	defer zr.Close()
+	h := objectFormatOf(id).New()
+	io.WriteString(h, objectHeader(r.Type, int64(r.Size)))
+	r.ReadCloser = &verifyingReader{ReadCloser: r.ReadCloser, id: id, hash: h}
+	return r, nil
+}
 
-	br := bufio.NewReader(zr)
+// verifyingReader hashes the contents of an object as they are read, and
+// checks the hash against the object's ID at the end.
+type verifyingReader struct {
+	io.ReadCloser
+	id   ObjectID
+	hash hash.Hash
+}
 
-	typStr, err := br.ReadString(' ')
-	if err != nil {
-		return nil, err
-	}
-	var typ ObjectType
-	switch typStr[:len(typStr)-1] {
-	case "blob":
-		typ = ObjectBlob
-	case "tree":
-		typ = ObjectTree
-	case "commit":
-		typ = ObjectCommit
-	case "tag":
-		typ = ObjectTag
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := ObjectID(r.hash.Sum(nil)); actual != r.id {
+			return n, ObjectCorrupt{r.id, actual}
+		}
 	}
+	return n, err
+}
 
-	sizeStr, err := br.ReadString(0)
-	if err != nil {
-		return nil, err
+// sizedReader reads exactly size bytes of inflated object data from r,
+// reporting io.ErrUnexpectedEOF if the data ends early.
+type sizedReader struct {
+	r       io.Reader
+	remain  uint64
+	closers []io.Closer
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	if r.remain == 0 {
+		return 0, io.EOF
 	}
-	size, err := strconv.ParseUint(sizeStr[:len(sizeStr)-1], 10, 64)
-	if err != nil {
-		return nil, err
+	if uint64(len(p)) > r.remain {
+		p = p[:r.remain]
 	}
-
-	if metaOnly {
-		return &Object{typ, size, nil}, nil
+	n, err := r.r.Read(p)
+	r.remain -= uint64(n)
+	if err == io.EOF {
+		if r.remain > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
 	}
+	return n, err
+}
 
-	data, err := ioutil.ReadAll(br)
-	if err != nil {
-		return nil, err
+func (r *sizedReader) Close() (err error) {
+	for _, c := range r.closers {
+		if thisErr := c.Close(); thisErr != nil && err == nil {
+			err = thisErr
+		}
 	}
-
-	return &Object{typ, size, data}, nil
+	return
 }
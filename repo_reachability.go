@@ -0,0 +1,275 @@
+package git
+
+import "runtime"
+
+// ReachableSet is the set of objects reachable from some tips, as returned by
+// Repository.ReachableFrom. Objects in a pack with a reachability bitmap are
+// held as bits of that bitmap, any other objects by id.
+type ReachableSet struct {
+	bitmap *packBitmap // nil if the repository has no bitmap
+	pin    *bitmapPin
+	bits   bitset
+	extra  map[ObjectID]ObjectType
+}
+
+// bitmapPin keeps the pack of a reachability bitmap open while any
+// ReachableSet using the bitmap is, even if a rescan of the pack directory
+// drops the pack.
+type bitmapPin struct {
+	release func()
+}
+
+func newBitmapPin(release func()) *bitmapPin {
+	pin := &bitmapPin{release}
+	runtime.SetFinalizer(pin, func(pin *bitmapPin) { pin.release() })
+	return pin
+}
+
+func newReachableSet(bitmap *packBitmap, pin *bitmapPin) *ReachableSet {
+	s := &ReachableSet{
+		bitmap: bitmap,
+		pin:    pin,
+		extra:  map[ObjectID]ObjectType{},
+	}
+	if bitmap != nil {
+		s.bits = newBitset(int(bitmap.numObjects))
+	}
+	return s
+}
+
+// Contains reports whether the object is in the set.
+func (s *ReachableSet) Contains(id ObjectID) bool {
+	if s.bitmap != nil {
+		if bit, ok := s.bitmap.bit(id); ok {
+			return s.bits.test(bit)
+		}
+	}
+	_, ok := s.extra[id]
+	return ok
+}
+
+func (s *ReachableSet) add(id ObjectID, typ ObjectType) {
+	if s.bitmap != nil {
+		if bit, ok := s.bitmap.bit(id); ok {
+			s.bits.set(bit)
+			return
+		}
+	}
+	s.extra[id] = typ
+}
+
+// Count returns the number of objects in the set.
+func (s *ReachableSet) Count() int {
+	return s.bits.count() + len(s.extra)
+}
+
+// CountType returns the number of objects of the given type in the set.
+func (s *ReachableSet) CountType(typ ObjectType) int {
+	n := 0
+	if s.bitmap != nil {
+		var typeBits bitset
+		switch typ {
+		case ObjectCommit:
+			typeBits = s.bitmap.commits
+		case ObjectTree:
+			typeBits = s.bitmap.trees
+		case ObjectBlob:
+			typeBits = s.bitmap.blobs
+		case ObjectTag:
+			typeBits = s.bitmap.tags
+		}
+		bits := s.bits.clone()
+		bits.and(typeBits)
+		n = bits.count()
+	}
+	for _, t := range s.extra {
+		if t == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// AndNot returns the objects of s that are not in o. Both sets must come
+// from the same repository.
+func (s *ReachableSet) AndNot(o *ReachableSet) *ReachableSet {
+	r := newReachableSet(s.bitmap, s.pin)
+	if s.bitmap != nil {
+		copy(r.bits, s.bits)
+		r.bits.andNot(o.bits)
+	}
+	for id, typ := range s.extra {
+		if !o.Contains(id) {
+			r.extra[id] = typ
+		}
+	}
+	return r
+}
+
+// ForEach calls fn for every object in the set. Objects held by the bitmap
+// are visited in pack order, the others in no particular order.
+func (s *ReachableSet) ForEach(fn func(id ObjectID, typ ObjectType) error) error {
+	if s.bitmap != nil {
+		err := s.bits.forEach(func(bit uint32) error {
+			return fn(s.bitmap.objectAt(bit), s.bitmap.typeAt(bit))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for id, typ := range s.extra {
+		if err := fn(id, typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReachableFrom returns every object reachable from the given ids: the ids
+// themselves, the ancestry of commits with their trees and blobs, and the
+// objects annotated tags point at. Like `git rev-list --objects`, submodule
+// commits are not included.
+//
+// When a pack has a reachability bitmap (see `git repack -b`), the bitmaps of
+// the commits it covers are used instead of walking their history.
+func (repo *Repository) ReachableFrom(ids ...ObjectID) (*ReachableSet, error) {
+	return repo.reachableFrom(ids, false)
+}
+
+// reachabilityBitmap returns the repository's reachability bitmap, or nil.
+// The bitmap can be used until release is called.
+func (repo *Repository) reachabilityBitmap() (b *packBitmap, release func(), err error) {
+	s, ok := repo.store.(*FileObjectStore)
+	if !ok {
+		return nil, func() {}, nil
+	}
+	return s.reachabilityBitmap()
+}
+
+// hasReachabilityBitmap reports whether the repository has a reachability
+// bitmap.
+func (repo *Repository) hasReachabilityBitmap() (bool, error) {
+	b, release, err := repo.reachabilityBitmap()
+	release()
+	return b != nil, err
+}
+
+// reachableFrom computes the objects reachable from ids. With commitsOnly,
+// trees and blobs of commits not covered by a bitmap are left out, which is
+// enough for counting commits.
+func (repo *Repository) reachableFrom(ids []ObjectID, commitsOnly bool) (*ReachableSet, error) {
+	bitmap, release, err := repo.reachabilityBitmap()
+	if err != nil {
+		return nil, err
+	}
+	var pin *bitmapPin
+	if bitmap != nil {
+		pin = newBitmapPin(release)
+	}
+	s := newReachableSet(bitmap, pin)
+
+	stack := append([]ObjectID(nil), ids...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s.Contains(id) {
+			continue
+		}
+
+		if bitmap != nil {
+			bits, ok, err := bitmap.commitBitmap(id)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				s.bits.or(bits)
+				continue
+			}
+		}
+
+		o, err := repo.object(id, false)
+		if err != nil {
+			return nil, err
+		}
+		switch o.Type {
+		case ObjectCommit:
+			c, err := parseCommitData(o.Data)
+			if err != nil {
+				return nil, err
+			}
+			s.add(id, ObjectCommit)
+			if !commitsOnly {
+				if err := repo.addReachableTree(s, c.Tree.Id); err != nil {
+					return nil, err
+				}
+			}
+			stack = append(stack, c.parents...)
+		case ObjectTag:
+			tag, err := parseTagData(o.Data)
+			if err != nil {
+				return nil, err
+			}
+			s.add(id, ObjectTag)
+			stack = append(stack, tag.Object)
+		case ObjectTree:
+			if !commitsOnly {
+				if err := repo.addReachableTree(s, id); err != nil {
+					return nil, err
+				}
+			}
+		case ObjectBlob:
+			if !commitsOnly {
+				s.add(id, ObjectBlob)
+			}
+		}
+	}
+	return s, nil
+}
+
+// addReachableTree adds the tree and everything below it to s. A tree already
+// in s is skipped along with its entries, which are in s too.
+func (repo *Repository) addReachableTree(s *ReachableSet, id ObjectID) error {
+	if s.Contains(id) {
+		return nil
+	}
+	entries, err := repo.treeEntries(id)
+	if err != nil {
+		return err
+	}
+	s.add(id, ObjectTree)
+
+	for _, e := range entries {
+		switch {
+		case e.mode == ModeCommit:
+			continue
+		case e.Type == ObjectTree:
+			if err := repo.addReachableTree(s, e.Id); err != nil {
+				return err
+			}
+		case !s.Contains(e.Id):
+			s.add(e.Id, ObjectBlob)
+		}
+	}
+	return nil
+}
+
+// behindAhead counts the commits reachable from target but not from id, and
+// reachable from id but not from target. ErrDisjoint is returned when the two
+// commits have no common ancestor.
+func (repo *Repository) behindAhead(id, target ObjectID) (behind int, ahead int, err error) {
+	reach, err := repo.reachableFrom([]ObjectID{id}, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	targetReach, err := repo.reachableFrom([]ObjectID{target}, true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	behind = targetReach.AndNot(reach).CountType(ObjectCommit)
+	ahead = reach.AndNot(targetReach).CountType(ObjectCommit)
+	if reach.CountType(ObjectCommit) == ahead {
+		return behind, ahead, ErrDisjoint
+	}
+	return behind, ahead, nil
+}
@@ -2,13 +2,11 @@ package git
 
 import (
 	"errors"
-	"io/ioutil"
 	"path/filepath"
 )
 
 func (repo *Repository) IsTagExist(tagName string) bool {
-	tagPath := filepath.Join(repo.Path, "refs/tags", tagName)
-	return isFile(tagPath)
+	return repo.isRefExist("refs/tags/" + tagName)
 }
 
 func (repo *Repository) TagPath(tagName string) string {
@@ -17,7 +15,7 @@ func (repo *Repository) TagPath(tagName string) string {
 
 // GetTags returns all tags of given repository.
 func (repo *Repository) GetTags() ([]string, error) {
-	return repo.readRefDir("refs/tags", "")
+	return repo.refs.Refs("refs/tags")
 }
 
 func (repo *Repository) CreateTag(tagName, idStr string) error {
@@ -29,26 +27,28 @@ func CreateTag(repoPath, tagName, id string) error {
 }
 
 func (repo *Repository) GetTag(tagName string) (*Tag, error) {
-	d, err := ioutil.ReadFile(repo.TagPath(tagName))
+	idStr, err := repo.GetCommitIdOfTag(tagName)
 	if err != nil {
 		return nil, err
 	}
 
-	tag, err := repo.getTag(ObjectIDHex(string(d)))
+	id, err := parseObjectIDHex(idStr)
 	if err != nil {
 		return nil, err
 	}
+	cached, err := repo.getTag(id)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the cached tag, it may be shared by other goroutines.
+	tag := *cached
 	tag.Name = tagName
-	return tag, nil
+	return &tag, nil
 }
 
 func (repo *Repository) getTag(id ObjectID) (*Tag, error) {
-	if repo.tagCache != nil {
-		if c, ok := repo.tagCache[id]; ok {
-			return c, nil
-		}
-	} else {
-		repo.tagCache = make(map[ObjectID]*Tag, 10)
+	if t, ok := repo.tagCache.get(id); ok {
+		return t.(*Tag), nil
 	}
 
 	o, err := repo.object(id, false)
@@ -56,31 +56,30 @@ func (repo *Repository) getTag(id ObjectID) (*Tag, error) {
 		return nil, err
 	}
 
-	// tag with only reference to commit
-	if o.Type == ObjectCommit {
-		tag := new(Tag)
+	var tag *Tag
+	switch o.Type {
+	case ObjectCommit:
+		// tag with only reference to commit
+		tag = new(Tag)
 		tag.Id = id
 		tag.Object = id
 		tag.Type = "commit"
 		tag.repo = repo
-		repo.tagCache[id] = tag
 
-		return tag, nil
-	}
+	case ObjectTag:
+		// tag with message
+		tag, err = parseTagData(o.Data)
+		if err != nil {
+			return nil, err
+		}
+		tag.Id = id
+		tag.repo = repo
 
-	// tag with message
-	if o.Type != ObjectTag {
+	default:
 		return nil, errors.New("Expected tag type, read error.")
 	}
 
-	tag, err := parseTagData(o.Data)
-	if err != nil {
-		return nil, err
-	}
-
-	tag.Id = id
-	tag.repo = repo
-	repo.tagCache[id] = tag
+	repo.tagCache.add(id, tag, 1)
 
 	return tag, nil
 }
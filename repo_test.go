@@ -0,0 +1,83 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func storeTestObject(t *testing.T, r *Repository, typ ObjectType, data string) ObjectID {
+	id, err := r.StoreObjectLoose(typ, bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestMemoryRepository(t *testing.T) {
+	r := OpenMemoryRepository()
+
+	blob := storeTestObject(t, r, ObjectBlob, "test")
+	if blob != ObjectIDHex("30d74d258442c7c65512eafab474568dd706c430") {
+		t.Fatalf("wrong blob id %s", blob)
+	}
+	sub := storeTestObject(t, r, ObjectTree, "100644 test.txt\x00"+string(blob))
+	root := storeTestObject(t, r, ObjectTree, "100644 test.txt\x00"+string(blob)+"40000 dir\x00"+string(sub))
+	commit := storeTestObject(t, r, ObjectCommit, fmt.Sprintf(
+		"tree %s\nauthor A U Thor <author@example.com> 1112904793 +0200\ncommitter C O Mitter <committer@example.com> 1112904794 +0200\n\ntest commit\n", root))
+
+	if err := r.CreateBranch("master", commit.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateTag("v1", commit.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateBranch("master", commit.String()); err != ErrBranchExisted {
+		t.Errorf("expected ErrBranchExisted, got %v", err)
+	}
+
+	c, err := r.GetCommitOfBranch("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Id != commit || c.TreeId() != root || c.Message() != "test commit\n" {
+		t.Errorf("wrong commit %+v", c)
+	}
+	head, err := r.GetCommitIdOfRef("HEAD")
+	if err != nil || head != commit.String() {
+		t.Errorf("expected HEAD to resolve to %s, got %q, %v", commit, head, err)
+	}
+	if c, err := r.GetCommitOfTag("v1"); err != nil || c.Id != commit {
+		t.Errorf("wrong tag commit %v, %v", c, err)
+	}
+
+	var paths []string
+	err = c.Tree.Walk(func(path string, te *TreeEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(paths) != "[test.txt dir dir/test.txt]" {
+		t.Errorf("wrong walk %v", paths)
+	}
+
+	b, err := c.Tree.GetBlobByPath("dir/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Data()
+	if err != nil || string(data) != "test" {
+		t.Errorf("wrong blob data %q, %v", data, err)
+	}
+
+	branches, err := r.GetBranches()
+	if err != nil || fmt.Sprint(branches) != "[master]" {
+		t.Errorf("wrong branches %v, %v", branches, err)
+	}
+	tags, err := r.GetTags()
+	if err != nil || fmt.Sprint(tags) != "[v1]" {
+		t.Errorf("wrong tags %v, %v", tags, err)
+	}
+}
@@ -1,10 +1,18 @@
 package git
 
 func (repo *Repository) GetTree(idStr string) (*Tree, error) {
-	return repo.getTree(ObjectIDHex(idStr))
+	id, err := repo.resolveID(idStr)
+	if err != nil {
+		return nil, err
+	}
+	return repo.getTree(id)
 }
 
 func (repo *Repository) getTree(id ObjectID) (*Tree, error) {
+	if t, ok := repo.treeCache.get(id); ok {
+		return t.(*Tree), nil
+	}
+
 	_, err := repo.object(id, true)
 	if err != nil {
 		if _, ok := err.(ObjectNotFound); ok {
@@ -13,5 +21,7 @@ func (repo *Repository) getTree(id ObjectID) (*Tree, error) {
 		return nil, err
 	}
 
-	return NewTree(repo, id), nil
+	tree := NewTree(repo, id)
+	repo.treeCache.add(id, tree, 1)
+	return tree, nil
 }
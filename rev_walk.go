@@ -0,0 +1,527 @@
+package git
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+var ErrRevWalkStarted = errors.New("commits cannot be added to a RevWalk after Next")
+
+// RevWalkOrder is the order in which a RevWalk returns commits.
+type RevWalkOrder int
+
+const (
+	// OrderDefault returns commits newest committer date first as the
+	// history is walked, like git log. With clock skew, a parent may come
+	// before one of its children.
+	OrderDefault RevWalkOrder = iota
+	// OrderDate never returns a parent before all of its children, and
+	// otherwise goes by committer date, like --date-order.
+	OrderDate
+	// OrderAuthorDate is like OrderDate, by author date.
+	OrderAuthorDate
+	// OrderTopo never returns a parent before all of its children, and
+	// avoids interleaving lines of history, like --topo-order.
+	OrderTopo
+)
+
+// RevWalkOptions are the options of a RevWalk, named after those of git
+// rev-list.
+type RevWalkOptions struct {
+	Order RevWalkOrder
+
+	// Reverse returns the commits that would be returned otherwise, after
+	// Skip and MaxCount, in reverse order.
+	Reverse bool
+
+	// FirstParent only follows the first parent of merge commits.
+	FirstParent bool
+
+	// NoMerges leaves out commits with more than one parent, Merges those
+	// with less than two.
+	NoMerges bool
+	Merges   bool
+
+	// Skip leaves out the first Skip commits, and a positive MaxCount stops
+	// after MaxCount commits.
+	Skip     int
+	MaxCount int
+
+	// Commits with a committer date before Since are left out and their
+	// ancestry is not walked, commits after Until are left out. Zero times
+	// are ignored.
+	Since time.Time
+	Until time.Time
+
+	// Paths limits the walk to the commits that change any of the given
+	// files or directories, like git log -- <path>.... A commit that has
+	// the same entries at the paths as one of its parents is TREESAME to
+	// it. By default, commits that are TREESAME to a parent are left out
+	// and only that parent's history is walked.
+	Paths []string
+
+	// FullHistory walks all parents, and leaves out the commits that are
+	// TREESAME to all their parents.
+	FullHistory bool
+
+	// SimplifyMerges walks all parents, and then drops the commits and
+	// side branches that do not contribute changes, like
+	// --simplify-merges. The order is OrderTopo unless another is set.
+	SimplifyMerges bool
+}
+
+// RevWalk walks the commits reachable from a set of included commits and
+// not from any excluded commit, like git rev-list.
+type RevWalk struct {
+	repo  *Repository
+	opts  RevWalkOptions
+	nodes map[ObjectID]int // revSeen, revUninteresting and revTreeSame flags
+	tips  []*Commit
+
+	paths     []string
+	rewritten map[ObjectID][]ObjectID // the parents walked from a TREESAME commit
+	treesame  map[ObjectID][]bool     // whether a commit is TREESAME to each parent
+
+	started  bool
+	limited  bool
+	queue    *commitQueue
+	list     []*Commit // the commits left to return in limited walks
+	skipped  int
+	returned int
+}
+
+const (
+	revSeen = 1 << iota
+	revUninteresting
+	revTreeSame
+)
+
+// revWalkSlop is how many uninteresting commits a limited walk looks at,
+// once there seem to be no more interesting commits, in case of clock skew.
+const revWalkSlop = 5
+
+// NewRevWalk returns a walk of no commits. Add commits with Push, Hide and
+// PushRevision, then call Next for every commit.
+func (repo *Repository) NewRevWalk(opts RevWalkOptions) *RevWalk {
+	w := &RevWalk{
+		repo:      repo,
+		opts:      opts,
+		nodes:     map[ObjectID]int{},
+		rewritten: map[ObjectID][]ObjectID{},
+		treesame:  map[ObjectID][]bool{},
+	}
+	if len(opts.Paths) > 0 {
+		w.paths = cleanPaths(opts.Paths)
+	}
+	if opts.SimplifyMerges && opts.Order == OrderDefault {
+		w.opts.Order = OrderTopo
+	}
+	return w
+}
+
+// Push includes the commit id, or the commit a tag points to, and its
+// ancestry in the walk.
+func (w *RevWalk) Push(id ObjectID) error {
+	return w.add(id, 0)
+}
+
+// Hide excludes the commit id, or the commit a tag points to, and its
+// ancestry from the walk.
+func (w *RevWalk) Hide(id ObjectID) error {
+	return w.add(id, revUninteresting)
+}
+
+// PushRevision adds the commits of a revision argument of git rev-list: a
+// revision to include, ^<rev> to exclude, <a>..<b> for the commits of b not
+// in a, or <a>...<b> for the commits of either that are not in both. An
+// omitted end of a range is HEAD. Revisions are resolved by ResolveRevision.
+func (w *RevWalk) PushRevision(spec string) error {
+	if strings.HasPrefix(spec, "^") {
+		id, err := w.repo.ResolveRevision(spec[1:])
+		if err != nil {
+			return err
+		}
+		return w.Hide(id)
+	}
+
+	dots := strings.Index(spec, "..")
+	if dots < 0 {
+		id, err := w.repo.ResolveRevision(spec)
+		if err != nil {
+			return err
+		}
+		return w.Push(id)
+	}
+	symmetric := strings.HasPrefix(spec[dots:], "...")
+	from, to := spec[:dots], spec[dots+2:]
+	if symmetric {
+		to = spec[dots+3:]
+	}
+	if from == "" {
+		from = "HEAD"
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+	a, err := w.repo.ResolveRevision(from)
+	if err != nil {
+		return err
+	}
+	if a, err = w.repo.peelTo(a, ObjectCommit); err != nil {
+		return err
+	}
+	b, err := w.repo.ResolveRevision(to)
+	if err != nil {
+		return err
+	}
+	if b, err = w.repo.peelTo(b, ObjectCommit); err != nil {
+		return err
+	}
+
+	if !symmetric {
+		if err := w.Hide(a); err != nil {
+			return err
+		}
+		return w.Push(b)
+	}
+	bases, err := w.repo.mergeBases(a, []ObjectID{b})
+	if err != nil {
+		return err
+	}
+	for _, base := range bases {
+		if err := w.Hide(base); err != nil {
+			return err
+		}
+	}
+	if err := w.Push(a); err != nil {
+		return err
+	}
+	return w.Push(b)
+}
+
+func (w *RevWalk) add(id ObjectID, flags int) error {
+	if w.started {
+		return ErrRevWalkStarted
+	}
+	id, err := w.repo.peelTo(id, ObjectCommit)
+	if err != nil {
+		return err
+	}
+	c, err := w.repo.getCommit(id)
+	if err != nil {
+		return err
+	}
+	w.nodes[id] |= flags
+	w.tips = append(w.tips, c)
+	return nil
+}
+
+// Next returns the next commit of the walk, or io.EOF after the last one.
+func (w *RevWalk) Next() (*Commit, error) {
+	if !w.started {
+		if err := w.start(); err != nil {
+			return nil, err
+		}
+	}
+	if w.opts.MaxCount > 0 && w.returned >= w.opts.MaxCount {
+		return nil, io.EOF
+	}
+
+	for {
+		var c *Commit
+		if w.limited {
+			if len(w.list) == 0 {
+				return nil, io.EOF
+			}
+			c, w.list = w.list[0], w.list[1:]
+		} else {
+			var err error
+			if c, err = w.walk(); err != nil {
+				return nil, err
+			}
+			if c == nil {
+				return nil, io.EOF
+			}
+			if !w.shown(c) {
+				continue
+			}
+		}
+		if w.skipped < w.opts.Skip {
+			w.skipped++
+			continue
+		}
+		w.returned++
+		return c, nil
+	}
+}
+
+// start queues the tips. A walk that excludes commits, or needs to see all
+// commits before returning the first, is limited: its commits are found,
+// ordered and filtered at once. Other walks return commits as they go.
+func (w *RevWalk) start() error {
+	w.started = true
+	w.queue = &commitQueue{}
+	for _, c := range w.tips {
+		if w.nodes[c.Id]&revSeen != 0 {
+			continue
+		}
+		w.nodes[c.Id] |= revSeen
+		heap.Push(w.queue, c)
+		if w.nodes[c.Id]&revUninteresting != 0 {
+			w.limited = true
+		}
+	}
+	if w.opts.Order != OrderDefault || w.opts.Reverse || w.opts.SimplifyMerges {
+		w.limited = true
+	}
+	if !w.limited {
+		return nil
+	}
+
+	list, err := w.limit()
+	if err != nil {
+		return err
+	}
+	if w.opts.Order != OrderDefault {
+		list = w.sortTopologically(list)
+	}
+	if w.paths != nil && w.opts.SimplifyMerges {
+		if list, err = w.simplifyMerges(list); err != nil {
+			return err
+		}
+	}
+	w.list = list[:0]
+	for _, c := range list {
+		if w.shown(c) {
+			w.list = append(w.list, c)
+		}
+	}
+
+	if w.opts.Reverse {
+		list := w.list
+		if w.opts.Skip < len(list) {
+			list = list[w.opts.Skip:]
+		} else {
+			list = nil
+		}
+		if w.opts.MaxCount > 0 && w.opts.MaxCount < len(list) {
+			list = list[:w.opts.MaxCount]
+		}
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+		w.list = list
+		w.opts.Skip, w.opts.MaxCount = 0, 0
+	}
+	return nil
+}
+
+// walk returns the next commit in date order and queues its parents, or nil
+// when there are no more. Commits before Since end their line of history.
+func (w *RevWalk) walk() (*Commit, error) {
+	for w.queue.Len() > 0 {
+		c := heap.Pop(w.queue).(*Commit)
+		if !w.opts.Since.IsZero() && commitTime(c) < w.opts.Since.Unix() {
+			continue
+		}
+		if err := w.addParents(c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// addParents queues the parents of c that have not been seen. The parents
+// of an uninteresting commit, and their known ancestry, are uninteresting
+// too. Path-limited walks simplify interesting commits first.
+func (w *RevWalk) addParents(c *Commit) error {
+	uninteresting := w.nodes[c.Id]&revUninteresting != 0
+	parents := c.parents
+	if !uninteresting {
+		if w.paths != nil {
+			if err := w.simplify(c); err != nil {
+				return err
+			}
+			parents = w.parentsOf(c)
+		}
+		if w.opts.FirstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+	}
+	for _, parent := range parents {
+		p, err := w.repo.getCommit(parent)
+		if err != nil {
+			return err
+		}
+		if uninteresting {
+			w.nodes[parent] |= revUninteresting
+			w.markParentsUninteresting(p)
+		}
+		if w.nodes[parent]&revSeen != 0 {
+			continue
+		}
+		w.nodes[parent] |= revSeen
+		heap.Push(w.queue, p)
+	}
+	return nil
+}
+
+// markParentsUninteresting marks the ancestors of c that have already been
+// seen as uninteresting. The others are marked when they are queued.
+func (w *RevWalk) markParentsUninteresting(c *Commit) {
+	stack := append([]ObjectID(nil), c.parents...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		flags, ok := w.nodes[id]
+		if !ok || flags&revUninteresting != 0 {
+			continue
+		}
+		w.nodes[id] |= revUninteresting
+		if p, err := w.repo.getCommit(id); err == nil {
+			stack = append(stack, p.parents...)
+		}
+	}
+}
+
+// limit walks the history until only uninteresting commits are left to
+// walk, as git's limit_list does, and returns the interesting commits by
+// date. Commits before Since are uninteresting.
+func (w *RevWalk) limit() ([]*Commit, error) {
+	var list []*Commit
+	date := int64(math.MaxInt64)
+	slop := revWalkSlop
+	for w.queue.Len() > 0 {
+		c := heap.Pop(w.queue).(*Commit)
+		if !w.opts.Since.IsZero() && commitTime(c) < w.opts.Since.Unix() {
+			w.nodes[c.Id] |= revUninteresting
+		}
+		if err := w.addParents(c); err != nil {
+			return nil, err
+		}
+		if w.nodes[c.Id]&revUninteresting != 0 {
+			w.markParentsUninteresting(c)
+			if slop = w.stillInteresting(date, slop); slop == 0 {
+				break
+			}
+			continue
+		}
+		date = commitTime(c)
+		list = append(list, c)
+	}
+
+	// Commits found to be uninteresting after they were listed are left
+	// out.
+	interesting := list[:0]
+	for _, c := range list {
+		if w.nodes[c.Id]&revUninteresting == 0 {
+			interesting = append(interesting, c)
+		}
+	}
+	return interesting, nil
+}
+
+// stillInteresting returns the slop left to a limited walk: the walk goes on
+// while the queue has interesting commits or commits newer than the last
+// interesting one, and for a few more commits after that.
+func (w *RevWalk) stillInteresting(date int64, slop int) int {
+	if w.queue.Len() == 0 {
+		return 0
+	}
+	if date <= commitTime(w.queue.commits[0]) {
+		return revWalkSlop
+	}
+	for _, c := range w.queue.commits {
+		if w.nodes[c.Id]&revUninteresting == 0 {
+			return revWalkSlop
+		}
+	}
+	return slop - 1
+}
+
+// parentsOf returns the parents of c, or the one walked if c was found
+// TREESAME to it.
+func (w *RevWalk) parentsOf(c *Commit) []ObjectID {
+	if parents, ok := w.rewritten[c.Id]; ok {
+		return parents
+	}
+	return c.parents
+}
+
+// shown reports whether c passes the path, merge and Until filters.
+func (w *RevWalk) shown(c *Commit) bool {
+	if w.paths != nil && !w.opts.SimplifyMerges && w.nodes[c.Id]&revTreeSame != 0 {
+		return false
+	}
+	if w.opts.NoMerges && len(c.parents) > 1 || w.opts.Merges && len(c.parents) < 2 {
+		return false
+	}
+	return w.opts.Until.IsZero() || commitTime(c) <= w.opts.Until.Unix()
+}
+
+// sortTopologically orders list so that no commit comes before any of its
+// children, as git's sort_in_topological_order does. Among the commits
+// ready to be returned, OrderTopo picks the last one made ready, so a line
+// of history is finished before the next, and the other orders pick the
+// newest.
+func (w *RevWalk) sortTopologically(list []*Commit) []*Commit {
+	indegree := make(map[ObjectID]int, len(list))
+	commits := make(map[ObjectID]*Commit, len(list))
+	for _, c := range list {
+		indegree[c.Id] = 1
+		commits[c.Id] = c
+	}
+	for _, c := range list {
+		for _, parent := range w.parentsOf(c) {
+			if indegree[parent] > 0 {
+				indegree[parent]++
+			}
+		}
+	}
+
+	queue := &commitQueue{authorDate: w.opts.Order == OrderAuthorDate}
+	var stack []*Commit
+	for _, c := range list {
+		if indegree[c.Id] == 1 {
+			if w.opts.Order == OrderTopo {
+				stack = append(stack, c)
+			} else {
+				heap.Push(queue, c)
+			}
+		}
+	}
+	// The first tip is returned first.
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+
+	sorted := make([]*Commit, 0, len(list))
+	for len(stack) > 0 || queue.Len() > 0 {
+		var c *Commit
+		if w.opts.Order == OrderTopo {
+			c, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		} else {
+			c = heap.Pop(queue).(*Commit)
+		}
+		for _, parent := range w.parentsOf(c) {
+			if indegree[parent] == 0 {
+				continue
+			}
+			if indegree[parent]--; indegree[parent] == 1 {
+				if w.opts.Order == OrderTopo {
+					stack = append(stack, commits[parent])
+				} else {
+					heap.Push(queue, commits[parent])
+				}
+			}
+		}
+		indegree[c.Id] = 0
+		sorted = append(sorted, c)
+	}
+	return sorted
+}
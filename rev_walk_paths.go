@@ -0,0 +1,267 @@
+package git
+
+import (
+	"path"
+	"strings"
+)
+
+// simplify decides, for a path-limited walk, whether the commit c is
+// TREESAME, that is has the same entries at the walk's paths as its
+// parents, as git's try_to_simplify_commit does. In the default mode, a
+// commit TREESAME to an interesting parent is only walked through that
+// parent. Otherwise a commit is TREESAME if it is TREESAME to all its
+// parents, and a root commit if it has none of the paths.
+func (w *RevWalk) simplify(c *Commit) error {
+	tree := c.TreeId()
+	if len(c.parents) == 0 {
+		same, err := w.repo.sameAtPaths("", tree, w.paths)
+		if err != nil {
+			return err
+		}
+		if same {
+			w.nodes[c.Id] |= revTreeSame
+		}
+		return nil
+	}
+
+	parents := c.parents
+	if w.opts.FirstParent {
+		parents = parents[:1]
+	}
+	treesame := make([]bool, len(parents))
+	all := true
+	for i, parent := range parents {
+		p, err := w.repo.getCommit(parent)
+		if err != nil {
+			return err
+		}
+		if treesame[i], err = w.repo.sameAtPaths(p.TreeId(), tree, w.paths); err != nil {
+			return err
+		}
+		if treesame[i] && !w.opts.FullHistory && !w.opts.SimplifyMerges && w.nodes[parent]&revUninteresting == 0 {
+			w.rewritten[c.Id] = []ObjectID{parent}
+			w.nodes[c.Id] |= revTreeSame
+			return nil
+		}
+		all = all && treesame[i]
+	}
+	w.treesame[c.Id] = treesame
+	if all {
+		w.nodes[c.Id] |= revTreeSame
+	}
+	return nil
+}
+
+// simplifyMerges returns the commits of list, in topological order, that
+// remain after git's --simplify-merges. Going from the oldest commit,
+// every commit is replaced by its only parent if it is TREESAME to it,
+// after its parents are replaced in turn, and parents that are ancestors
+// of other parents are dropped, keeping at least one it is TREESAME to.
+// TREESAME merges of several remaining parents are kept to tie together
+// the history.
+func (w *RevWalk) simplifyMerges(list []*Commit) ([]*Commit, error) {
+	simplified := map[ObjectID]ObjectID{}
+	shown := map[ObjectID]bool{}
+	for i := len(list) - 1; i >= 0; i-- {
+		c := list[i]
+		if len(c.parents) == 0 {
+			simplified[c.Id] = c.Id
+			shown[c.Id] = w.nodes[c.Id]&revTreeSame == 0
+			continue
+		}
+
+		// Replace the parents by their simplifications, which commits
+		// outside of the walk are themselves.
+		var parents []ObjectID
+		var treesame []bool
+		for j, same := range w.treesame[c.Id] {
+			p := c.parents[j]
+			if s, ok := simplified[p]; ok {
+				p = s
+			}
+			k := 0
+			for k < len(parents) && parents[k] != p {
+				k++
+			}
+			if k == len(parents) {
+				parents = append(parents, p)
+				treesame = append(treesame, same)
+			} else {
+				treesame[k] = treesame[k] || same
+			}
+		}
+
+		if len(parents) > 1 {
+			marked := make([]bool, len(parents))
+			n := 0
+			for j, p := range parents {
+				redundant, err := w.redundantParent(p, parents)
+				if err != nil {
+					return nil, err
+				}
+				if redundant {
+					marked[j] = true
+					n++
+				}
+			}
+			if n > 0 {
+				// Keep a parent c is TREESAME to, if there is one.
+				unmark := -1
+				for j := range parents {
+					if !treesame[j] {
+						continue
+					}
+					if !marked[j] {
+						unmark = -1
+						break
+					}
+					if unmark < 0 {
+						unmark = j
+					}
+				}
+				if unmark >= 0 {
+					marked[unmark] = false
+				}
+				kept := 0
+				for j := range parents {
+					if !marked[j] {
+						parents[kept], treesame[kept] = parents[j], treesame[j]
+						kept++
+					}
+				}
+				parents, treesame = parents[:kept], treesame[:kept]
+			}
+		}
+
+		same := true
+		for _, t := range treesame {
+			same = same && t
+		}
+		if !same || len(parents) != 1 {
+			simplified[c.Id] = c.Id
+			shown[c.Id] = !same || len(parents) > 1
+		} else {
+			simplified[c.Id] = parents[0]
+		}
+	}
+
+	kept := list[:0]
+	for _, c := range list {
+		if simplified[c.Id] == c.Id && shown[c.Id] {
+			kept = append(kept, c)
+		}
+	}
+	return kept, nil
+}
+
+// redundantParent reports whether p is an ancestor of another of the
+// parents, or a root commit without any of the walk's paths.
+func (w *RevWalk) redundantParent(p ObjectID, parents []ObjectID) (bool, error) {
+	for _, other := range parents {
+		if other == p {
+			continue
+		}
+		ok, err := w.repo.isAncestor(p, other)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	c, err := w.repo.getCommit(p)
+	if err != nil {
+		return false, err
+	}
+	if len(c.parents) > 0 {
+		return false, nil
+	}
+	return w.repo.sameAtPaths("", c.TreeId(), w.paths)
+}
+
+// cleanPaths returns the paths of a path-limited walk, relative to the root
+// without leading or trailing slashes. An empty path or "." stands for the
+// whole tree.
+func cleanPaths(paths []string) []string {
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = strings.Trim(path.Clean("/"+p), "/")
+	}
+	return cleaned
+}
+
+// sameAtPaths reports whether the trees a and b, either of which may be
+// empty, have the same entries at every one of the given cleaned paths.
+func (repo *Repository) sameAtPaths(a, b ObjectID, paths []string) (bool, error) {
+	for _, p := range paths {
+		same, err := repo.sameAtPath(a, b, p)
+		if err != nil || !same {
+			return same, err
+		}
+	}
+	return true, nil
+}
+
+// sameAtPath looks up p in the trees a and b one directory at a time, and
+// stops as soon as the subtrees are the same.
+func (repo *Repository) sameAtPath(a, b ObjectID, p string) (bool, error) {
+	if p == "" {
+		return a == b, nil
+	}
+	var ea, eb *TreeEntry
+	for _, name := range strings.Split(p, "/") {
+		if a == b {
+			return true, nil
+		}
+		var err error
+		if ea, err = repo.treeEntryNamed(a, name); err != nil {
+			return false, err
+		}
+		if eb, err = repo.treeEntryNamed(b, name); err != nil {
+			return false, err
+		}
+		a, b = "", ""
+		if ea != nil && ea.Type == ObjectTree {
+			a = ea.Id
+		}
+		if eb != nil && eb.Type == ObjectTree {
+			b = eb.Id
+		}
+	}
+	if ea == nil || eb == nil {
+		return ea == nil && eb == nil, nil
+	}
+	return ea.Id == eb.Id && ea.mode == eb.mode, nil
+}
+
+// treeEntryAtPath returns the entry at the cleaned path p of the tree with
+// the given id, or nil if there is none or the id is empty.
+func (repo *Repository) treeEntryAtPath(id ObjectID, p string) (*TreeEntry, error) {
+	var e *TreeEntry
+	for _, name := range strings.Split(p, "/") {
+		if id == "" {
+			return nil, nil
+		}
+		var err error
+		if e, err = repo.treeEntryNamed(id, name); err != nil || e == nil {
+			return nil, err
+		}
+		id = ""
+		if e.Type == ObjectTree {
+			id = e.Id
+		}
+	}
+	return e, nil
+}
+
+// treeEntryNamed returns the entry with the given name in the tree with the
+// given id, or nil if there is none or the id is empty.
+func (repo *Repository) treeEntryNamed(id ObjectID, name string) (*TreeEntry, error) {
+	entries, err := repo.treeEntries(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.name == name {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
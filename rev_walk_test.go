@@ -0,0 +1,176 @@
+package git
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Commits of testdata/repo7, see testdata/prepare_repo7.sh.
+const (
+	repo7A  = "b3aea54dd1c8806e2ba3637b405c14e9dff4e64c"
+	repo7B  = "01159f5462851ab76626fb3098a874adbd1c4c09"
+	repo7C  = "00b9c867dcbd5a1f5f559f8380c71dda63e7d14c"
+	repo7D  = "cfd4a0594d940301bc0d4f94792a41023f88e3d2"
+	repo7X1 = "1925c5ec390d8308c1e3dac5abd1132cb792f7e9"
+	repo7X2 = "b3f67eef0f206ad5a431de131b7bf9b84828b026"
+	repo7E  = "2669de360add0a6aba180e55d598fca8ed5b29c5"
+	repo7F  = "a6f8905ccf437d70d6e2d8e67de7c9ba9fede484"
+	repo7G  = "857f6beff744af773b33359767cdc8d2e816c38d"
+	repo7H  = "536b007aea75d4dc70b0862bd163918ec9d2d0ab"
+	repo7I  = "44ee28593562c90173ae166e5748601c404d4234"
+	repo7K  = "55489219cfce7fa3c835771d3a9abf5754c4c90c"
+	repo7J  = "b78e1ea5394b5bf64b519f17c15408ac67f800b7"
+	repo7L  = "752901ad1b7c4d032ac737374f4cf9a5a46d5216"
+	repo7T1 = "a606aafc28e68f214182fca03930e308d9a6273e"
+	repo7T2 = "3d554ba0a75671165947cee8a682972764654074"
+	repo7T  = "04ad011a0d43e86b2c237b3963fb40f2b1c54af4"
+)
+
+// walkSummaries returns the summaries of the commits of a walk, which are
+// the commit names in repo7.
+func walkSummaries(t *testing.T, w *RevWalk) string {
+	var names []string
+	for {
+		c, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, c.Summary())
+	}
+	return strings.Join(names, " ")
+}
+
+func TestRevWalk(t *testing.T) {
+	r := openTestRepo(t, "repo7")
+	defer r.Close()
+
+	// Expected results are from git rev-list.
+	for _, test := range []struct {
+		revs []string
+		opts RevWalkOptions
+		want string
+	}{
+		{[]string{"master"}, RevWalkOptions{}, "L J K I H F E X2 X1 D C B G A"},
+		{[]string{"master"}, RevWalkOptions{Order: OrderDate}, "L J K I H F E X1 G X2 D C B A"},
+		{[]string{"master"}, RevWalkOptions{Order: OrderAuthorDate}, "L J K I H F E X1 G X2 D C B A"},
+		{[]string{"master"}, RevWalkOptions{Order: OrderTopo}, "L J K I H G F X2 E X1 C D B A"},
+		{[]string{"master"}, RevWalkOptions{Reverse: true}, "A G B C D X1 X2 E F H I K J L"},
+		{[]string{"master"}, RevWalkOptions{FirstParent: true}, "L J I H E X1 D B A"},
+		{[]string{"master"}, RevWalkOptions{NoMerges: true}, "L K I F E D C B G A"},
+		{[]string{"master"}, RevWalkOptions{Merges: true}, "J H X2 X1"},
+		{[]string{"master"}, RevWalkOptions{Skip: 2, MaxCount: 3}, "K I H"},
+		{[]string{"master"}, RevWalkOptions{Reverse: true, MaxCount: 3}, "K J L"},
+		{[]string{"master"}, RevWalkOptions{Since: time.Unix(1112900450, 0)}, "L J K I H F E X2 X1"},
+		{[]string{"master"}, RevWalkOptions{Until: time.Unix(1112900850, 0)}, "F E X2 X1 D C B G A"},
+		{[]string{"topic..master"}, RevWalkOptions{}, "L J K I H E X1 G"},
+		{[]string{"master...topic"}, RevWalkOptions{}, "L J K I H E X1 G"},
+		{[]string{"side..topic"}, RevWalkOptions{}, "F"},
+		{[]string{"master", "^side"}, RevWalkOptions{}, "L J K I H F E X1"},
+		{[]string{"master", "^v1"}, RevWalkOptions{}, "L J K I H F E X2 G"},
+		{[]string{"topic", "side"}, RevWalkOptions{Order: OrderTopo}, "F G X2 D C B A"},
+		{[]string{"master"}, RevWalkOptions{Paths: []string{"c.txt"}, FullHistory: true, Order: OrderTopo}, "H G X2 X1 D"},
+		{[]string{"master"}, RevWalkOptions{Paths: []string{"c.txt", "b.txt"}, FirstParent: true}, "H X1 D A"},
+		{[]string{"master~3..twins"}, RevWalkOptions{Paths: []string{"e.txt", "c.txt"}}, "T1"},
+		{[]string{"master~3..twins"}, RevWalkOptions{Paths: []string{"e.txt", "c.txt"}, SimplifyMerges: true}, "T T2 T1"},
+	} {
+		w := r.NewRevWalk(test.opts)
+		for _, rev := range test.revs {
+			if err := w.PushRevision(rev); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := walkSummaries(t, w); got != test.want {
+			t.Errorf("%v %+v: expected %s, got %s", test.revs, test.opts, test.want, got)
+		}
+	}
+}
+
+func TestRevWalkPaths(t *testing.T) {
+	r := openTestRepo(t, "repo7")
+	defer r.Close()
+
+	const (
+		simplified = iota
+		fullHistory
+		simplifyMerges
+	)
+	// The expectations need the renames of prepare_repo7.sh: a.txt is gone
+	// from E on and dir/a2.txt from L on.
+	for id, path := range map[string]string{repo7E: "a.txt", repo7L: "dir/a2.txt"} {
+		c, err := r.getCommit(ObjectIDHex(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, err := r.treeEntryAtPath(c.TreeId(), path); e != nil || err != nil {
+			t.Fatalf("%s: expected %s to be removed, got %v, %v", c.Summary(), path, e, err)
+		}
+	}
+
+	// Expected results are from git 2.39, with
+	// git rev-list --format=%s [--full-history | --simplify-merges] <rev> -- <paths>
+	// on the repository made by prepare_repo7.sh.
+	for _, test := range []struct {
+		rev   string
+		paths []string
+		want  [3]string
+	}{
+		{"master", []string{"a.txt"}, [3]string{"E B A", "H E B A", "E B A"}},
+		{"master", []string{"b.txt"}, [3]string{"F C A", "H F X2 X1 C A", "F C A"}},
+		{"master", []string{"c.txt"}, [3]string{"G D", "H X2 X1 D G", "G D"}},
+		{"master", []string{"dir/"}, [3]string{"L I E", "L I H E", "L I E"}},
+		{"master", []string{"copy.txt"}, [3]string{"I", "I", "I"}},
+		{"master", []string{"d.txt"}, [3]string{"K", "J K", "K"}},
+		{"master", []string{"dir/a2.txt", "moved.txt"}, [3]string{"L I E", "L I H E", "L I E"}},
+		{"master", []string{"nothing"}, [3]string{"", "", ""}},
+		{"twins", []string{"e.txt"}, [3]string{"T1", "T2 T1", "T T2 T1"}},
+		{"twins", []string{"c.txt", "e.txt"}, [3]string{"T1 G D", "T2 T1 H X2 X1 D G", "T T2 T1 G D"}},
+	} {
+		for mode, want := range test.want {
+			opts := RevWalkOptions{
+				Paths:          test.paths,
+				FullHistory:    mode == fullHistory,
+				SimplifyMerges: mode == simplifyMerges,
+			}
+			w := r.NewRevWalk(opts)
+			if err := w.PushRevision(test.rev); err != nil {
+				t.Fatal(err)
+			}
+			if got := walkSummaries(t, w); got != want {
+				t.Errorf("%s %v mode %d: expected %q, got %q", test.rev, test.paths, mode, want, got)
+			}
+		}
+	}
+}
+
+func TestRevWalkPaging(t *testing.T) {
+	r := openTestRepo(t, "repo7")
+	defer r.Close()
+
+	var pages []string
+	for skip := 0; skip < 14; skip += 5 {
+		w := r.NewRevWalk(RevWalkOptions{Skip: skip, MaxCount: 5})
+		if err := w.Push(ObjectIDHex(repo7L)); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, walkSummaries(t, w))
+	}
+	if got, want := strings.Join(pages, " | "), "L J K I H | F E X2 X1 D | C B G A"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	w := r.NewRevWalk(RevWalkOptions{})
+	if err := w.Push(ObjectIDHex(repo7L)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Hide(ObjectIDHex(repo7A)); err != ErrRevWalkStarted {
+		t.Errorf("expected ErrRevWalkStarted, got %v", err)
+	}
+}
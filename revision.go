@@ -0,0 +1,639 @@
+package git
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidRevision is returned for revisions that are malformed, use an
+// unsupported form, or peel an object to a type it cannot be peeled to.
+var ErrInvalidRevision = errors.New("invalid revision")
+
+// RevisionNotFound is returned by ResolveRevision when a revision names no
+// ref or object, or an ancestor, path or reflog entry that does not exist.
+type RevisionNotFound string
+
+func (r RevisionNotFound) Error() string {
+	return fmt.Sprintf("unknown revision %s", string(r))
+}
+
+// refRevParseRules are the full ref names tried for a short name, in order,
+// see git-rev-parse(1).
+var refRevParseRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// ResolveRevision returns the id of the object named by spec, in the syntax
+// of gitrevisions(7):
+//
+//	HEAD, @, <full or abbreviated id>, <ref name>
+//	<rev>~N, <rev>^N, <rev>^{}, <rev>^{commit|tree|blob|tag|object}
+//	<rev>^{/regex}, :/regex, <rev>:<path>
+//	<ref>@{N}, @{-N}, <branch>@{upstream}, <branch>@{u}
+//
+// Short ref names are expanded in git's order: the name itself, refs/,
+// refs/tags/, refs/heads/, refs/remotes/ and refs/remotes/<name>/HEAD. Reflog
+// dates, @{push} and :<path> index lookups are not supported.
+func (repo *Repository) ResolveRevision(spec string) (ObjectID, error) {
+	if strings.HasPrefix(spec, ":/") {
+		return repo.searchMessage(spec, spec[2:], nil)
+	}
+	if strings.HasPrefix(spec, ":") {
+		return "", fmt.Errorf("%v: %q: index lookups are not supported", ErrInvalidRevision, spec)
+	}
+
+	// The first colon outside of braces separates a path.
+	depth := 0
+	for i := 0; i < len(spec); i++ {
+		switch spec[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ':':
+			if depth == 0 {
+				return repo.resolvePath(spec[:i], spec[i+1:])
+			}
+		}
+	}
+	return repo.resolveRev(spec)
+}
+
+// resolvePath returns the id of the object at path in the tree of rev, or
+// the tree itself if path is empty.
+func (repo *Repository) resolvePath(rev, path string) (ObjectID, error) {
+	id, err := repo.resolveRev(rev)
+	if err != nil {
+		return "", err
+	}
+	treeId, err := repo.peelTo(id, ObjectTree)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return treeId, nil
+	}
+	tree, err := repo.getTree(treeId)
+	if err != nil {
+		return "", err
+	}
+	entry, err := tree.GetTreeEntryByPath(path)
+	if err == ErrNotExist {
+		return "", RevisionNotFound(rev + ":" + path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return entry.Id, nil
+}
+
+// resolveRev resolves a revision without a path, taking its suffixes off
+// from the right as git does.
+func (repo *Repository) resolveRev(name string) (ObjectID, error) {
+	if strings.HasSuffix(name, "}") {
+		if open := strings.LastIndex(name, "^{"); open >= 0 {
+			id, err := repo.resolveRev(name[:open])
+			if err != nil {
+				return "", err
+			}
+			return repo.peelRev(name, id, name[open+2:len(name)-1])
+		}
+	}
+
+	// ~N and ^N, where a missing N means 1.
+	i := len(name)
+	for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+		i--
+	}
+	if i > 0 && (name[i-1] == '~' || name[i-1] == '^') {
+		n := 1
+		if i < len(name) {
+			var err error
+			if n, err = strconv.Atoi(name[i:]); err != nil {
+				return "", fmt.Errorf("%v: %q", ErrInvalidRevision, name)
+			}
+		}
+		id, err := repo.resolveRev(name[:i-1])
+		if err != nil {
+			return "", err
+		}
+		if id, err = repo.peelTo(id, ObjectCommit); err != nil {
+			return "", err
+		}
+		if name[i-1] == '^' {
+			return repo.nthParent(name, id, n)
+		}
+		return repo.nthAncestor(name, id, n)
+	}
+
+	return repo.resolveName(name)
+}
+
+// resolveName resolves a revision without suffixes: an object id, a ref name
+// or a reflog selector.
+func (repo *Repository) resolveName(name string) (ObjectID, error) {
+	if name == "" {
+		return "", fmt.Errorf("%v: empty revision", ErrInvalidRevision)
+	}
+	if at := strings.Index(name, "@{"); at >= 0 && strings.HasSuffix(name, "}") {
+		return repo.resolveAt(name, name[:at], name[at+2:len(name)-1])
+	}
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	if len(name) == 2*repo.format.Size() && IsObjectIDHex(name) {
+		id := ObjectIDHex(name)
+		ok, err := repo.store.Has(id)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", RevisionNotFound(name)
+		}
+		return id, nil
+	}
+
+	id, _, err := repo.dwimRef(name)
+	if err == nil {
+		return id, nil
+	}
+	if _, ok := err.(RevisionNotFound); !ok {
+		return "", err
+	}
+	if len(name) >= minPrefixLen && isHex(name) {
+		id, err := repo.ResolvePrefix(name)
+		if _, ok := err.(PrefixNotFound); ok {
+			return "", RevisionNotFound(name)
+		}
+		return id, err
+	}
+	return "", RevisionNotFound(name)
+}
+
+// dwimRef returns the id of the first ref that name expands to, and the
+// ref's full name.
+func (repo *Repository) dwimRef(name string) (ObjectID, string, error) {
+	for _, refpath := range refCandidates(name) {
+		idStr, err := repo.GetCommitIdOfRef(refpath)
+		if _, ok := err.(RefNotFound); ok {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return ObjectIDHex(idStr), refpath, nil
+	}
+	return "", "", RevisionNotFound(name)
+}
+
+// refCandidates returns the valid full ref names that name may expand to,
+// in order. The name itself is only tried for names below refs/ and
+// all-caps names like HEAD and FETCH_HEAD.
+func refCandidates(name string) []string {
+	var refpaths []string
+	for _, rule := range refRevParseRules {
+		refpath := fmt.Sprintf(rule, name)
+		if rule == "%s" && !strings.HasPrefix(name, "refs/") && !isPseudoRefName(name) {
+			continue
+		}
+		if isValidRefName(refpath) {
+			refpaths = append(refpaths, refpath)
+		}
+	}
+	return refpaths
+}
+
+func isPseudoRefName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if (name[i] < 'A' || name[i] > 'Z') && name[i] != '_' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// isValidRefName reports whether name is a well-formed ref name, following
+// the rules of git-check-ref-format(1).
+func isValidRefName(name string) bool {
+	if name == "" || name == "@" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "//") ||
+		strings.Contains(name, "@{") {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if ch := name[i]; ch < 0x20 || ch == 0x7f || strings.IndexByte(" ~^:?*[\\", ch) >= 0 {
+			return false
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') && (ch < 'A' || ch > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// peelRev applies a ^{...} suffix of the revision name to id.
+func (repo *Repository) peelRev(name string, id ObjectID, inner string) (ObjectID, error) {
+	switch inner {
+	case "":
+		return repo.peel(id)
+	case "object":
+		return id, nil
+	case "commit":
+		return repo.peelTo(id, ObjectCommit)
+	case "tree":
+		return repo.peelTo(id, ObjectTree)
+	case "blob":
+		return repo.peelTo(id, ObjectBlob)
+	case "tag":
+		return repo.peelTo(id, ObjectTag)
+	}
+	if strings.HasPrefix(inner, "/") {
+		commitId, err := repo.peelTo(id, ObjectCommit)
+		if err != nil {
+			return "", err
+		}
+		return repo.searchMessage(name, inner[1:], []ObjectID{commitId})
+	}
+	return "", fmt.Errorf("%v: %q", ErrInvalidRevision, name)
+}
+
+// peelTo follows tags, and a commit to its tree, until it reaches an object
+// of type typ.
+func (repo *Repository) peelTo(id ObjectID, typ ObjectType) (ObjectID, error) {
+	for {
+		o, err := repo.object(id, false)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case o.Type == typ:
+			return id, nil
+		case o.Type == ObjectTag:
+			tag, err := parseTagData(o.Data)
+			if err != nil {
+				return "", err
+			}
+			id = tag.Object
+		case o.Type == ObjectCommit && typ == ObjectTree:
+			c, err := repo.getCommit(id)
+			if err != nil {
+				return "", err
+			}
+			return c.TreeId(), nil
+		default:
+			return "", fmt.Errorf("%v: %s is a %v, not a %v", ErrInvalidRevision, id, o.Type, typ)
+		}
+	}
+}
+
+// nthParent returns the nth parent of the commit id, or the commit itself
+// if n is 0.
+func (repo *Repository) nthParent(name string, id ObjectID, n int) (ObjectID, error) {
+	if n == 0 {
+		return id, nil
+	}
+	c, err := repo.getCommit(id)
+	if err != nil {
+		return "", err
+	}
+	if n > c.ParentCount() {
+		return "", RevisionNotFound(name)
+	}
+	return c.parents[n-1], nil
+}
+
+// nthAncestor follows the first parents of the commit id n times.
+func (repo *Repository) nthAncestor(name string, id ObjectID, n int) (ObjectID, error) {
+	for ; n > 0; n-- {
+		c, err := repo.getCommit(id)
+		if err != nil {
+			return "", err
+		}
+		if c.ParentCount() == 0 {
+			return "", RevisionNotFound(name)
+		}
+		id = c.parents[0]
+	}
+	return id, nil
+}
+
+// resolveAt resolves the reflog and upstream selectors <ref>@{<inner>}.
+func (repo *Repository) resolveAt(name, ref, inner string) (ObjectID, error) {
+	switch strings.ToLower(inner) {
+	case "u", "upstream":
+		return repo.upstream(name, ref)
+	case "push":
+		return "", fmt.Errorf("%v: %q: @{push} is not supported", ErrInvalidRevision, name)
+	}
+
+	if strings.HasPrefix(inner, "-") {
+		n, err := strconv.Atoi(inner[1:])
+		if err != nil || n <= 0 || ref != "" {
+			return "", fmt.Errorf("%v: %q", ErrInvalidRevision, name)
+		}
+		branch, err := repo.previousBranch(name, n)
+		if err != nil {
+			return "", err
+		}
+		if idStr, err := repo.GetCommitIdOfRef("refs/heads/" + branch); err == nil {
+			return ObjectIDHex(idStr), nil
+		}
+		return repo.resolveName(branch)
+	}
+
+	n, err := strconv.Atoi(inner)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("%v: %q: reflog dates are not supported", ErrInvalidRevision, name)
+	}
+	var refpath string
+	if ref == "" {
+		if refpath, err = repo.currentRefPath(); err != nil {
+			return "", err
+		}
+	} else {
+		for _, candidate := range refCandidates(ref) {
+			if repo.hasReflog(candidate) {
+				refpath = candidate
+				break
+			}
+		}
+		if refpath == "" {
+			return "", RevisionNotFound(name)
+		}
+	}
+
+	entries, err := repo.reflog(refpath)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", RevisionNotFound(name)
+	}
+	return entries[len(entries)-1-n].New, nil
+}
+
+// currentRefPath returns the full name of the branch HEAD points to, or
+// HEAD if it is detached.
+func (repo *Repository) currentRefPath() (string, error) {
+	head, err := repo.refs.Ref("HEAD")
+	if err != nil {
+		return "", err
+	}
+	if m := refRexp.FindStringSubmatch(head); m != nil {
+		return m[1], nil
+	}
+	return "HEAD", nil
+}
+
+// previousBranch returns the branch or commit that was checked out before
+// the nth last checkout, from the messages in the reflog of HEAD.
+func (repo *Repository) previousBranch(name string, n int) (string, error) {
+	entries, err := repo.reflog("HEAD")
+	if err != nil {
+		return "", err
+	}
+	const prefix = "checkout: moving from "
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		to := strings.Index(msg, " to ")
+		if to < 0 {
+			continue
+		}
+		if n--; n == 0 {
+			return msg[len(prefix):to], nil
+		}
+	}
+	return "", RevisionNotFound(name)
+}
+
+// upstream returns the id of the remote-tracking branch that branch, or the
+// current branch if it is empty, is configured to merge from.
+func (repo *Repository) upstream(name, branch string) (ObjectID, error) {
+	if branch == "" {
+		refpath, err := repo.currentRefPath()
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(refpath, "refs/heads/") {
+			return "", fmt.Errorf("%v: %q: HEAD does not point to a branch", ErrInvalidRevision, name)
+		}
+		branch = strings.TrimPrefix(refpath, "refs/heads/")
+	} else if _, err := repo.GetCommitIdOfRef("refs/heads/" + branch); err != nil {
+		if _, ok := err.(RefNotFound); ok {
+			return "", RevisionNotFound(name)
+		}
+		return "", err
+	}
+
+	c, err := repo.config()
+	if err != nil {
+		return "", err
+	}
+	remote, ok := c.get("branch", branch, "remote")
+	merge, ok2 := c.get("branch", branch, "merge")
+	if !ok || !ok2 {
+		return "", fmt.Errorf("%v: %q: no upstream configured for branch %q", ErrInvalidRevision, name, branch)
+	}
+
+	target := merge
+	if remote != "." {
+		target = ""
+		for _, refspec := range c.getAll("remote", remote, "fetch") {
+			if dst, ok := mapRefspec(refspec, merge); ok {
+				target = dst
+				break
+			}
+		}
+		if target == "" {
+			return "", fmt.Errorf("%v: %q: upstream branch %q is not fetched from %q", ErrInvalidRevision, name, merge, remote)
+		}
+	}
+
+	idStr, err := repo.GetCommitIdOfRef(target)
+	if _, ok := err.(RefNotFound); ok {
+		return "", RevisionNotFound(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return ObjectIDHex(idStr), nil
+}
+
+// mapRefspec maps ref through the fetch refspec "[+]<src>:<dst>", where src
+// and dst may contain one '*'.
+func mapRefspec(refspec, ref string) (string, bool) {
+	refspec = strings.TrimPrefix(refspec, "+")
+	colon := strings.IndexByte(refspec, ':')
+	if colon < 0 {
+		return "", false
+	}
+	src, dst := refspec[:colon], refspec[colon+1:]
+	star := strings.IndexByte(src, '*')
+	if star < 0 {
+		if src == ref && dst != "" {
+			return dst, true
+		}
+		return "", false
+	}
+	prefix, suffix := src[:star], src[star+1:]
+	if len(ref) < len(prefix)+len(suffix) || !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) {
+		return "", false
+	}
+	return strings.Replace(dst, "*", ref[len(prefix):len(ref)-len(suffix)], 1), true
+}
+
+// searchMessage returns the youngest commit reachable from the given
+// commits, or from HEAD and all refs if there are none, whose message
+// matches pattern. A pattern starting with "!-" matches the messages that do
+// not match the rest, and "!!" stands for a literal "!".
+func (repo *Repository) searchMessage(name, pattern string, from []ObjectID) (ObjectID, error) {
+	negate := false
+	if strings.HasPrefix(pattern, "!") {
+		switch {
+		case strings.HasPrefix(pattern, "!-"):
+			negate, pattern = true, pattern[2:]
+		case strings.HasPrefix(pattern, "!!"):
+			pattern = pattern[1:]
+		default:
+			return "", fmt.Errorf("%v: %q", ErrInvalidRevision, name)
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("%v: %q: %v", ErrInvalidRevision, name, err)
+	}
+
+	if from == nil {
+		targets, err := repo.refTargets()
+		if err != nil {
+			return "", err
+		}
+		for _, id := range targets {
+			id, err := repo.peel(id)
+			if err != nil {
+				return "", err
+			}
+			from = append(from, id)
+		}
+	}
+
+	seen := map[ObjectID]bool{}
+	queue := &commitQueue{}
+	push := func(id ObjectID) error {
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		o, err := repo.object(id, true)
+		if err != nil {
+			return err
+		}
+		if o.Type != ObjectCommit {
+			return nil
+		}
+		c, err := repo.getCommit(id)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, c)
+		return nil
+	}
+	for _, id := range from {
+		if err := push(id); err != nil {
+			return "", err
+		}
+	}
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(*Commit)
+		if re.MatchString(c.CommitMessage) != negate {
+			return c.Id, nil
+		}
+		for _, parent := range c.parents {
+			if err := push(parent); err != nil {
+				return "", err
+			}
+		}
+	}
+	return "", RevisionNotFound(name)
+}
+
+// commitQueue is a heap of commits, youngest committer date, or author date
+// if authorDate is set, first and in insertion order among equal dates.
+type commitQueue struct {
+	commits    []*Commit
+	seq        []int
+	next       int
+	authorDate bool
+}
+
+func (q *commitQueue) Len() int { return len(q.commits) }
+
+func (q *commitQueue) Less(i, j int) bool {
+	ti, tj := q.time(q.commits[i]), q.time(q.commits[j])
+	if ti != tj {
+		return ti > tj
+	}
+	return q.seq[i] < q.seq[j]
+}
+
+func (q *commitQueue) Swap(i, j int) {
+	q.commits[i], q.commits[j] = q.commits[j], q.commits[i]
+	q.seq[i], q.seq[j] = q.seq[j], q.seq[i]
+}
+
+func (q *commitQueue) Push(x interface{}) {
+	q.commits = append(q.commits, x.(*Commit))
+	q.seq = append(q.seq, q.next)
+	q.next++
+}
+
+func (q *commitQueue) Pop() interface{} {
+	n := len(q.commits) - 1
+	c := q.commits[n]
+	q.commits, q.seq = q.commits[:n], q.seq[:n]
+	return c
+}
+
+func (q *commitQueue) time(c *Commit) int64 {
+	if q.authorDate {
+		if c.Author == nil {
+			return 0
+		}
+		return c.Author.When.Unix()
+	}
+	return commitTime(c)
+}
+
+func commitTime(c *Commit) int64 {
+	if c.Committer == nil {
+		return 0
+	}
+	return c.Committer.When.Unix()
+}
@@ -0,0 +1,175 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveRevision(t *testing.T) {
+	r := openTestRepo(t, "repo4")
+	defer r.Close()
+
+	for spec, want := range map[string]string{
+		"HEAD":                  repo4F,
+		"@":                     repo4F,
+		"master":                repo4F,
+		"heads/master":          repo4F,
+		"refs/heads/feature":    repo4D,
+		repo4M:                  repo4M,
+		repo4M[:7]:              repo4M,
+		"HEAD~2":                repo4M,
+		"HEAD~~":                repo4M,
+		"HEAD^^^2":              repo4D,
+		"master~2^1":            repo4C,
+		"HEAD~3^0":              repo4C,
+		"master~4":              repo4B,
+		"feature^":              repo4B,
+		"@^{commit}":            repo4F,
+		"HEAD^{}":               repo4F,
+		"HEAD^{/^C}":            repo4C,
+		":/^D":                  repo4D,
+		"HEAD~2^{/^[A-C]}":      repo4C,
+		"HEAD^{tree}":           "064279e332b715fff24455babb8973e31f8e412e",
+		"HEAD:":                 "064279e332b715fff24455babb8973e31f8e412e",
+		"HEAD:F.txt":            "c137216fe167556782049b618443432e9409fa53",
+		"feature:D.txt":         "02358d2358658574ba0767140caa4216ee7ea5bf",
+		"HEAD^{/C}:C.txt":       "96d80cd6c4e7158dbebd0849f4fb7ce513e5828c",
+		":/^[BD]":               repo4D,
+		"master~5^{tree}:A.txt": "8c7e5a667f1b771847fe88c01c3de34413a1b220",
+	} {
+		id, err := r.ResolveRevision(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if id.String() != want {
+			t.Errorf("%s: expected %s, got %s", spec, want, id)
+		}
+	}
+
+	for _, spec := range []string{"nope", "HEAD:F.txt^{blob}", "HEAD~6", "HEAD^2", "feature^^3", "HEAD:nope.txt", ":/^nothing", "0000000"} {
+		if _, err := r.ResolveRevision(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		} else if _, ok := err.(RevisionNotFound); !ok {
+			t.Errorf("%s: expected RevisionNotFound, got %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "HEAD^{tag}", "HEAD^{tree}^{commit}", "HEAD^{nope}", ":/!x", ":/(", ":F.txt", "HEAD@{yesterday}"} {
+		if _, err := r.ResolveRevision(spec); err == nil || !strings.HasPrefix(err.Error(), ErrInvalidRevision.Error()) {
+			t.Errorf("%q: expected ErrInvalidRevision, got %v", spec, err)
+		}
+	}
+}
+
+func TestResolveRevisionTagFirst(t *testing.T) {
+	// repo3 has a tag and a branch named master; refs/tags/ comes before
+	// refs/heads/.
+	r := openTestRepo(t, "repo3")
+	defer r.Close()
+	id, err := r.ResolveRevision("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "8b61789a76de9edaa49b2529d3aaa302ba238c0b" {
+		t.Errorf("unexpected id %s", id)
+	}
+}
+
+func TestResolveRevisionReflog(t *testing.T) {
+	dir := copyTestRepo(t, "repo4")
+	defer os.RemoveAll(dir)
+
+	write := func(name, data string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zero := strings.Repeat("0", 40)
+	sig := " A U Thor <author@example.com> 1112904674 -0700\t"
+	write("logs/HEAD", zero+" "+repo4A+sig+"commit (initial): A\n"+
+		repo4A+" "+repo4D+sig+"checkout: moving from master to feature\n"+
+		repo4D+" "+repo4C+sig+"checkout: moving from feature to master\n"+
+		repo4C+" "+repo4F+sig+"commit: F\n")
+	write("logs/refs/heads/master", zero+" "+repo4A+sig+"commit (initial): A\n"+
+		repo4A+" "+repo4C+sig+"commit: C\n"+
+		"not a reflog line\n"+
+		repo4C+" "+repo4F+sig+"commit: F\n")
+	write("refs/remotes/origin/master", repo4B+"\n")
+	write("config", "[core]\n\tbare = true\n"+
+		"[remote \"origin\"]\n\turl = https://example.com/repo.git\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n"+
+		"[branch \"master\"]\n\tremote = origin\n\tmerge = refs/heads/master\n"+
+		"[branch \"feature\"]\n\tremote = .\n\tmerge = refs/heads/master\n")
+
+	r, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tagId, err := r.StoreObjectLoose(ObjectTag, strings.NewReader("object "+repo4C+"\ntype commit\ntag v1\ntagger"+sig[:len(sig)-1]+"\n\nv1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("refs/tags/v1", tagId.String()+"\n")
+
+	for spec, want := range map[string]string{
+		"@{0}":                  repo4F,
+		"@{1}":                  repo4C,
+		"master@{2}":            repo4A,
+		"master@{1}~1":          repo4B,
+		"HEAD@{1}":              repo4C,
+		"HEAD@{2}":              repo4D,
+		"@{-1}":                 repo4D,
+		"@{-2}":                 repo4F,
+		"@{u}":                  repo4B,
+		"master@{UPSTREAM}":     repo4B,
+		"feature@{u}":           repo4F,
+		"v1":                    tagId.String(),
+		"v1^{tag}":              tagId.String(),
+		"v1^{}":                 repo4C,
+		"v1^{commit}":           repo4C,
+		"v1~1":                  repo4B,
+		"tags/v1:C.txt":         "96d80cd6c4e7158dbebd0849f4fb7ce513e5828c",
+		"origin/master":         repo4B,
+		"remotes/origin/master": repo4B,
+	} {
+		id, err := r.ResolveRevision(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if id.String() != want {
+			t.Errorf("%s: expected %s, got %s", spec, want, id)
+		}
+	}
+
+	for _, spec := range []string{"master@{3}", "feature@{0}", "@{-3}", "nope@{u}"} {
+		if _, err := r.ResolveRevision(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestMapRefspec(t *testing.T) {
+	for _, test := range []struct {
+		refspec, ref, want string
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/a/b", "refs/remotes/origin/a/b"},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/main", "refs/remotes/origin/main"},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/other", ""},
+		{"refs/heads/*-wip:refs/wip/*", "refs/heads/x-wip", "refs/wip/x"},
+		{"refs/heads/*", "refs/heads/x", ""},
+	} {
+		got, _ := mapRefspec(test.refspec, test.ref)
+		if got != test.want {
+			t.Errorf("mapRefspec(%q, %q) = %q, expected %q", test.refspec, test.ref, got, test.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package git
 
 import (
 	"bytes"
+	"fmt"
 	"strconv"
 	"time"
 )
@@ -22,12 +23,21 @@ type Signature struct {
 func newSignatureFromCommitline(line []byte) (*Signature, error) {
 	sig := new(Signature)
 	emailstart := bytes.IndexByte(line, '<')
-	sig.Name = string(line[:emailstart-1])
-	emailstop := bytes.IndexByte(line, '>')
+	if emailstart < 0 {
+		return nil, fmt.Errorf("failed to parse signature: no email in %q", line)
+	}
+	emailstop := bytes.IndexByte(line[emailstart:], '>')
+	if emailstop < 0 {
+		return nil, fmt.Errorf("failed to parse signature: unterminated email in %q", line)
+	}
+	emailstop += emailstart
+	sig.Name = string(bytes.TrimSuffix(line[:emailstart], []byte{' '}))
 	sig.Email = string(line[emailstart+1 : emailstop])
-	timestop := bytes.IndexByte(line[emailstop+2:], ' ')
-	timestring := string(line[emailstop+2 : emailstop+2+timestop])
-	seconds, err := strconv.ParseInt(timestring, 10, 64)
+	fields := bytes.Fields(line[emailstop+1:])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("failed to parse signature: no date in %q", line)
+	}
+	seconds, err := strconv.ParseInt(string(fields[0]), 10, 64)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,9 @@
 package git
 
-import "bytes"
+import (
+	"bytes"
+	"fmt"
+)
 
 // Tag
 type Tag struct {
@@ -31,10 +34,17 @@ l:
 		case eol > 0:
 			line := data[nextline : nextline+eol]
 			spacepos := bytes.IndexByte(line, ' ')
+			if spacepos < 0 {
+				return nil, fmt.Errorf("failed to parse tag data: %q", line)
+			}
 			reftype := line[:spacepos]
 			switch string(reftype) {
 			case "object":
-				tag.Object = ObjectIDHex(string(line[spacepos+1:]))
+				id, err := parseObjectIDHex(string(line[spacepos+1:]))
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse tag object: %v", err)
+				}
+				tag.Object = id
 			case "type":
 				// A commit can have one or more parents
 				tag.Type = string(line[spacepos+1:])
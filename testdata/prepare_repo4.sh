@@ -0,0 +1,72 @@
+#!/bin/bash
+
+# Description: Creates a repo with merge history and a split commit-graph
+# chain of two layers.
+#
+# History (oldest first), master is F:
+# ```
+# A - B - C - M - E - F
+#      \     /
+#       - D -
+# ```
+#
+# The first commit-graph layer covers A-M, the second layer covers E and F.
+# The pack has a reachability bitmap.
+#
+# A 83d0f7870a75a28fa0fb4b0d5e190e451cbd97ab
+# B 817d5c49b120028f87827f9c08c16ec197608b19
+# C 3daa4461796af116024d7289456c0b1d7f6991aa
+# D cf6cdf17e780522ce04aa73afab39f6deb4dec53
+# M 83ba50bae5cbe85d1b0c7863a445d8aa3a61ca98
+# E 7c9050ebe09858101a66e507b96ecd5c13ec6db5
+# F 430d7cd2491c852bb37cdaad4719573b240ace05
+
+set -ex
+
+export GIT_DIR=repo4
+export GIT_AUTHOR_NAME="Test Author"
+export GIT_AUTHOR_EMAIL="author@example.com"
+export GIT_COMMITTER_NAME="Test Committer"
+export GIT_COMMITTER_EMAIL="committer@example.com"
+
+rm -rf $GIT_DIR
+
+git init --bare
+git config core.commitGraph true
+
+n=0
+commit() {
+  # commit <message> [<parent>...]
+  local msg=$1
+  shift
+  n=$((n+1))
+  export GIT_AUTHOR_DATE="Thu, 07 Apr 2005 22:$((10+n)):13 +0200"
+  export GIT_COMMITTER_DATE="Thu, 07 Apr 2005 22:$((10+n)):14 +0200"
+  local blob=`echo -n "$msg" | git hash-object -w --stdin`
+  git update-index --add --cacheinfo 100644 $blob $msg.txt
+  local tree=`git write-tree`
+  local parents=""
+  for p in "$@"; do
+    parents="$parents -p $p"
+  done
+  git commit-tree -m "$msg" $parents $tree
+}
+
+A=`commit A`
+B=`commit B $A`
+C=`commit C $B`
+D=`commit D $B`
+M=`commit M $C $D`
+git update-ref refs/heads/master $M
+git update-ref refs/heads/feature $D
+git commit-graph write --reachable --split
+
+E=`commit E $M`
+F=`commit F $E`
+git update-ref refs/heads/master $F
+git commit-graph write --reachable --split=no-merge
+
+git repack -a -d -b
+git prune-packed
+
+echo "A=$A B=$B C=$C D=$D M=$M E=$E F=$F"
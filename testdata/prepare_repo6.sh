@@ -0,0 +1,57 @@
+#!/bin/bash
+
+# Description: Creates a repo with the SHA-256 object format.
+#
+# History (oldest first), master is D:
+# ```
+# A - B - C - D
+# ```
+#
+# A and B are in a pack with a bitmap, covered by a multi-pack-index and a
+# commit-graph. C is in a second pack, and D is a loose object.
+
+set -ex
+
+export GIT_DIR=repo6
+export GIT_AUTHOR_NAME="Test Author"
+export GIT_AUTHOR_EMAIL="author@example.com"
+export GIT_COMMITTER_NAME="Test Committer"
+export GIT_COMMITTER_EMAIL="committer@example.com"
+
+rm -rf $GIT_DIR
+
+git init --bare --object-format=sha256
+
+n=0
+commit() {
+  # commit <message> [<parent>...]
+  local msg=$1
+  shift
+  n=$((n+1))
+  export GIT_AUTHOR_DATE="Thu, 07 Apr 2005 22:$((10+n)):13 +0200"
+  export GIT_COMMITTER_DATE="Thu, 07 Apr 2005 22:$((10+n)):14 +0200"
+  local blob=`echo -n "$msg" | git hash-object -w --stdin`
+  git update-index --add --cacheinfo 100644 $blob $msg.txt
+  local tree=`git write-tree`
+  local parents=""
+  for p in "$@"; do
+    parents="$parents -p $p"
+  done
+  git commit-tree -m "$msg" $parents $tree
+}
+
+A=`commit A`
+B=`commit B $A`
+git update-ref refs/heads/master $B
+git repack -adb
+git multi-pack-index write
+git commit-graph write --reachable
+
+C=`commit C $B`
+git update-ref refs/heads/master $C
+git repack -d --no-write-bitmap-index
+
+D=`commit D $C`
+git update-ref refs/heads/master $D
+
+echo "A=$A B=$B C=$C D=$D"
@@ -0,0 +1,124 @@
+#!/bin/bash
+
+# Description: Creates a repo with criss-cross and octopus merges, clock
+# skew, and files that change, move and are copied, for history walks and
+# merge bases.
+#
+# History (oldest first), master is L:
+# ```
+#        - C ---- X2 - F ------
+#       /     \  /       \     \
+# A - B        \/         \     \
+#       \      /\          G --- H - I - J - L
+#        - D ---- X1 - E -------/     \  /
+#                                      K
+# ```
+#
+# X1 merges C into D and X2 merges D into C, so E and F have two merge
+# bases, C and D. G is a child of X2 with a committer date before all other
+# commits but A. H is an octopus merge of E, F and G. K is on branch other
+# and J merges it into master.
+#
+# Files:
+# A adds a.txt and b.txt, B changes a.txt, C changes b.txt, D adds c.txt,
+# E renames a.txt to dir/a2.txt, F changes b.txt, G changes c.txt, H takes
+# b.txt from F and c.txt from G, I copies dir/a2.txt to copy.txt and
+# changes dir/a2.txt, K adds d.txt, and L renames dir/a2.txt to moved.txt
+# with a small change.
+#
+# Branch twins has two children of L, T1 and T2, that add the same e.txt,
+# and their merge T.
+#
+# Branches: master (L), topic (F), side (G), other (K), twins (T). Tag v1 is
+# an annotated tag of X1.
+
+set -ex
+
+export GIT_DIR=repo7
+export GIT_AUTHOR_NAME="Test Author"
+export GIT_AUTHOR_EMAIL="author@example.com"
+export GIT_COMMITTER_NAME="Test Committer"
+export GIT_COMMITTER_EMAIL="committer@example.com"
+
+rm -rf $GIT_DIR
+
+git init --bare
+export GIT_INDEX_FILE=`mktemp`
+
+lines() {
+  # lines <tag> <changed line>: ten lines, line 5 is the changed line
+  for i in 1 2 3 4; do echo "$1 line $i"; done
+  echo "$2"
+  for i in 6 7 8 9 10; do echo "$1 line $i"; done
+}
+
+commit() {
+  # commit <time> <message> <parents> [<path>=<content> | -<path>]...
+  # The tree starts from the first parent's.
+  local time=$1 msg=$2 parents=$3
+  shift 3
+  rm -f $GIT_INDEX_FILE
+  local first=${parents%% *}
+  if [ -n "$first" ]; then
+    git read-tree $first
+  fi
+  for op in "$@"; do
+    if [ "${op:0:1}" = "-" ]; then
+      printf '0 %040d\t%s\n' 0 "${op:1}" | git update-index --index-info
+    else
+      local blob=`printf '%s' "${op#*=}" | git hash-object -w --stdin`
+      git update-index --add --cacheinfo 100644 $blob "${op%%=*}"
+    fi
+  done
+  local tree=`git write-tree`
+  for op in "$@"; do
+    # Fail if a removal did not take, as update-index --force-remove
+    # silently does without a work tree.
+    if [ "${op:0:1}" = "-" ] && [ -n "`git ls-tree $tree -- "${op:1}"`" ]; then
+      echo "$msg: ${op:1} was not removed" >&2
+      exit 1
+    fi
+  done
+  local args=""
+  for p in $parents; do
+    args="$args -p $p"
+  done
+  GIT_AUTHOR_DATE="$((1112900000+time)) +0000" GIT_COMMITTER_DATE="$((1112900000+time)) +0000" \
+    git commit-tree -m "$msg" $args $tree
+}
+
+A1=`lines a "a one"`
+A2=`lines a "a two"`
+A3=`lines a "a three"`
+A4=`lines a "a four"`
+
+A=`commit 100 A "" "a.txt=$A1" "b.txt=b1"`
+B=`commit 200 B "$A" "a.txt=$A2"`
+C=`commit 300 C "$B" "b.txt=b2"`
+D=`commit 400 D "$B" "c.txt=c1"`
+X1=`commit 500 X1 "$D $C" "b.txt=b2"`
+X2=`commit 600 X2 "$C $D" "c.txt=c1"`
+E=`commit 700 E "$X1" "-a.txt" "dir/a2.txt=$A2"`
+F=`commit 800 F "$X2" "b.txt=b3"`
+G=`commit 150 G "$X2" "c.txt=c2"`
+H=`commit 900 H "$E $F $G" "b.txt=b3" "c.txt=c2"`
+I=`commit 1000 I "$H" "copy.txt=$A2" "dir/a2.txt=$A3"`
+K=`commit 1100 K "$I" "d.txt=d1"`
+J=`commit 1200 J "$I $K" "d.txt=d1"`
+L=`commit 1300 L "$J" "-dir/a2.txt" "moved.txt=$A4"`
+T1=`commit 1400 T1 "$L" "e.txt=e1"`
+T2=`commit 1500 T2 "$L" "e.txt=e1"`
+T=`commit 1600 T "$T1 $T2"`
+
+git update-ref refs/heads/master $L
+git update-ref refs/heads/topic $F
+git update-ref refs/heads/side $G
+git update-ref refs/heads/other $K
+git update-ref refs/heads/twins $T
+GIT_COMMITTER_DATE="1112901400 +0000" git tag -a -m v1 v1 $X1
+
+git repack -a -d
+git prune-packed
+rm -f $GIT_INDEX_FILE
+
+echo "A=$A B=$B C=$C D=$D X1=$X1 X2=$X2 E=$E F=$F G=$G H=$H I=$I K=$K J=$J L=$L T1=$T1 T2=$T2 T=$T"
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"path"
 	"strings"
+	"sync"
 )
 
 var (
@@ -21,9 +22,7 @@ type Tree struct {
 	Id   ObjectID
 	repo *Repository
 
-	// parent tree
-	ptree *Tree
-
+	entriesMu     sync.Mutex
 	entries       Entries
 	entriesParsed bool
 }
@@ -35,11 +34,7 @@ func (t *Tree) Walk(walkFn TreeWalkFunc) error {
 }
 
 func (t *Tree) walkSubtree(te *TreeEntry) (*Tree, error) {
-	commit, err := t.repo.getCommit(te.Id)
-	if err != nil {
-		return nil, err
-	}
-	return t.repo.getTree(commit.Id)
+	return t.repo.getTree(te.Id)
 }
 
 func (t *Tree) walk(dir string, walkFn TreeWalkFunc) error {
@@ -92,19 +87,19 @@ func (t *Tree) SubTree(rpath string) (*Tree, error) {
 		if err != nil {
 			return nil, err
 		}
-		g.ptree = p
 		p = g
 	}
 	return g, nil
 }
 
 func (t *Tree) ListEntries() (Entries, error) {
+	t.entriesMu.Lock()
+	defer t.entriesMu.Unlock()
+
 	if t.entriesParsed {
 		return t.entries, nil
 	}
 
-	t.entriesParsed = true
-
 	var entries Entries
 
 	scanner, err := t.Scanner()
@@ -121,6 +116,7 @@ func (t *Tree) ListEntries() (Entries, error) {
 	}
 
 	t.entries = entries
+	t.entriesParsed = true
 	return t.entries, nil
 }
 
@@ -0,0 +1,121 @@
+package git
+
+import "path"
+
+// treeChange is a difference between two trees found by diffTree. From is
+// nil for added entries and To is nil for deleted ones. Both are set for
+// entries whose id or mode changed.
+type treeChange struct {
+	Path string
+	From *TreeEntry
+	To   *TreeEntry
+}
+
+// diffTree compares the trees with ids a and b recursively and calls fn for
+// every blob or submodule entry that differs, in git's tree order. Either id
+// may be empty to stand for the empty tree. Returning an error from fn stops
+// the diff.
+func (repo *Repository) diffTree(a, b ObjectID, fn func(treeChange) error) error {
+	return repo.diffTreeAt("", a, b, fn)
+}
+
+func (repo *Repository) diffTreeAt(dir string, a, b ObjectID, fn func(treeChange) error) error {
+	if a == b {
+		return nil
+	}
+
+	aEntries, err := repo.treeEntries(a)
+	if err != nil {
+		return err
+	}
+	bEntries, err := repo.treeEntries(b)
+	if err != nil {
+		return err
+	}
+
+	for len(aEntries) > 0 || len(bEntries) > 0 {
+		var from, to *TreeEntry
+		switch {
+		case len(bEntries) == 0:
+			from, aEntries = aEntries[0], aEntries[1:]
+		case len(aEntries) == 0:
+			to, bEntries = bEntries[0], bEntries[1:]
+		default:
+			switch c := compareTreeEntries(aEntries[0], bEntries[0]); {
+			case c < 0:
+				from, aEntries = aEntries[0], aEntries[1:]
+			case c > 0:
+				to, bEntries = bEntries[0], bEntries[1:]
+			default:
+				from, aEntries = aEntries[0], aEntries[1:]
+				to, bEntries = bEntries[0], bEntries[1:]
+			}
+		}
+
+		if err := repo.diffEntries(dir, from, to, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntries reports the differences between two entries with the same
+// name, either of which may be nil.
+func (repo *Repository) diffEntries(dir string, from, to *TreeEntry, fn func(treeChange) error) error {
+	var name string
+	if from != nil {
+		name = from.name
+	} else {
+		name = to.name
+	}
+	p := path.Join(dir, name)
+
+	// Trees and non-trees never share a sort key, so both entries are
+	// either trees or not.
+	switch {
+	case from != nil && from.Type == ObjectTree:
+		var toId ObjectID
+		if to != nil {
+			toId = to.Id
+		}
+		return repo.diffTreeAt(p, from.Id, toId, fn)
+	case to != nil && to.Type == ObjectTree:
+		return repo.diffTreeAt(p, "", to.Id, fn)
+	case from != nil && to != nil && from.Id == to.Id && from.mode == to.mode:
+		return nil
+	default:
+		return fn(treeChange{Path: p, From: from, To: to})
+	}
+}
+
+// treeEntries returns the entries of the tree with the given id, or nothing
+// for the empty id.
+func (repo *Repository) treeEntries(id ObjectID) (Entries, error) {
+	if id == "" {
+		return nil, nil
+	}
+	t, err := repo.getTree(id)
+	if err != nil {
+		return nil, err
+	}
+	return t.ListEntries()
+}
+
+// compareTreeEntries compares entries in git's tree order, in which a tree
+// sorts as if its name ended in a slash.
+func compareTreeEntries(a, b *TreeEntry) int {
+	an, bn := a.name, b.name
+	if a.Type == ObjectTree {
+		an += "/"
+	}
+	if b.Type == ObjectTree {
+		bn += "/"
+	}
+	switch {
+	case an < bn:
+		return -1
+	case an > bn:
+		return 1
+	}
+	return 0
+}
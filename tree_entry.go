@@ -3,6 +3,7 @@ package git
 import (
 	"os"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -62,8 +63,9 @@ type TreeEntry struct {
 	//	commit   *Commit
 	commited bool
 
-	size  int64
-	sized bool
+	sizeMu sync.Mutex
+	size   int64
+	sized  bool
 
 	//	modTime time.Time
 }
@@ -81,6 +83,9 @@ func (te *TreeEntry) Size() int64 {
 		return 0
 	}
 
+	te.sizeMu.Lock()
+	defer te.sizeMu.Unlock()
+
 	if te.sized {
 		return te.size
 	}
@@ -17,12 +17,23 @@ type TreeScanner struct {
 	err       error
 }
 
+// NewTreeScanner returns a scanner of the entries of the tree data read from
+// r. The entries' IDs are in the object format of parent, or SHA-1 if parent
+// is nil.
 func NewTreeScanner(parent *Tree, r io.Reader) *TreeScanner {
+	format := ObjectFormatSHA1
+	if parent != nil {
+		format = objectFormatOf(parent.Id)
+	}
+	return newTreeScanner(parent, r, format)
+}
+
+func newTreeScanner(parent *Tree, r io.Reader, format ObjectFormat) *TreeScanner {
 	ts := &TreeScanner{
 		parent:  parent,
 		Scanner: bufio.NewScanner(r),
 	}
-	ts.Split(ScanTreeEntry)
+	ts.Split(scanTreeEntries(format.Size()))
 	return ts
 }
 
@@ -78,33 +89,42 @@ func (t *TreeScanner) Err() error {
 	return t.err
 }
 
+// ScanTreeEntry is a bufio.SplitFunc for the entries of a tree with SHA-1
+// object IDs.
 func ScanTreeEntry(
 	data []byte,
 	atEOF bool,
 ) (
 	advance int, token []byte, err error,
 ) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
-	}
-	const shaLen = 20
+	return scanTreeEntries(ObjectFormatSHA1.Size())(data, atEOF)
+}
 
-	nullIndex := bytes.IndexByte(data, '\x00')
-	if nullIndex == -1 {
-		return 0, nil, nil // Request more data.
-	}
-	recordLength := nullIndex + 1 + shaLen
-	if recordLength <= len(data) {
-		// We found 20 bytes after a null, we're done.
-		return recordLength, data[:recordLength], nil
-	}
+// scanTreeEntries returns a bufio.SplitFunc for the entries of a tree whose
+// object IDs are idLen bytes long.
+func scanTreeEntries(idLen int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		nullIndex := bytes.IndexByte(data, '\x00')
+		if nullIndex == -1 {
+			return 0, nil, nil // Request more data.
+		}
+		recordLength := nullIndex + 1 + idLen
+		if recordLength <= len(data) {
+			// We found the ID after a null, we're done.
+			return recordLength, data[:recordLength], nil
+		}
+
+		if atEOF {
+			// atEOF but don't have a complete record
+			return 0, nil, fmt.Errorf("malformed record %q", data)
+		}
 
-	if atEOF {
-		// atEOF but don't have a complete record
-		return 0, nil, fmt.Errorf("malformed record %q", data)
+		return 0, nil, nil // Request more data.
 	}
-
-	return 0, nil, nil // Request more data.
 }
 
 func (t *TreeScanner) TreeEntry() *TreeEntry {